@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAbsolutePathPolicies(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	absolute := filepath.ToSlash(filepath.Join(root, "etc", "hosts"))
+	archive := filepath.Join(dir, "absolute.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "relative.txt", body: "relative"},
+		testEntry{name: absolute, body: "absolute"},
+	)
+	relocated := strings.TrimLeft(absolute, "/")
+
+	t.Run("relocate", func(t *testing.T) {
+		output := filepath.Join(dir, "relocate")
+		if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestFile(t, filepath.Join(output, filepath.FromSlash(relocated))); got != "absolute" {
+			t.Errorf("relocated entry holds %q", got)
+		}
+		if _, err := os.Stat(filepath.FromSlash(absolute)); !os.IsNotExist(err) {
+			t.Errorf("relocate wrote to the absolute path: %v", err)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		output := filepath.Join(dir, "reject")
+		_, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, AbsolutePathPolicy: "reject"})
+		if err == nil || !strings.Contains(err.Error(), "has an absolute path") {
+			t.Errorf("got %v, want the absolute entry rejected", err)
+		}
+	})
+
+	t.Run("restore-to-root", func(t *testing.T) {
+		output := filepath.Join(dir, "restore")
+		if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, AbsolutePathPolicy: "restore-to-root"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTestFile(t, filepath.FromSlash(absolute)); got != "absolute" {
+			t.Errorf("restored entry holds %q", got)
+		}
+		if got := readTestFile(t, filepath.Join(output, "relative.txt")); got != "relative" {
+			t.Errorf("relative entry holds %q", got)
+		}
+		if _, err := os.Stat(filepath.Join(output, filepath.FromSlash(relocated))); !os.IsNotExist(err) {
+			t.Errorf("restore-to-root also relocated the entry: %v", err)
+		}
+	})
+}
+
+// restore-to-root writes outside the output, so the API only takes it when
+// the operator allowed it
+func TestRestoreToRootNeedsFlag(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	absolute := filepath.ToSlash(filepath.Join(root, "etc", "hosts"))
+	archive := filepath.Join(dir, "absolute.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: absolute, body: "absolute"})
+
+	body, err := json.Marshal(DecompressRequest{Archive: archive, OutputDir: filepath.Join(dir, "out"), AbsolutePathPolicy: "restore-to-root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, endpoint := range []struct {
+		path    string
+		handler http.HandlerFunc
+	}{{"/api/decompress", handleDecompress}, {"/api/scan", handleScan}} {
+		rec := httptest.NewRecorder()
+		endpoint.handler(rec, httptest.NewRequest(http.MethodPost, endpoint.path, bytes.NewReader(body)))
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "disabled on this server") {
+			t.Errorf("%s answered %d %s with the flag off", endpoint.path, rec.Code, rec.Body.String())
+		}
+	}
+	if _, err := os.Stat(filepath.FromSlash(absolute)); !os.IsNotExist(err) {
+		t.Errorf("refused request wrote to the absolute path: %v", err)
+	}
+	if slices.Contains(serverCapabilities().Features, "restore-to-root") {
+		t.Error("restore-to-root listed with the flag off")
+	}
+
+	allowRestoreToRoot = true
+	defer func() { allowRestoreToRoot = false }()
+	if !slices.Contains(serverCapabilities().Features, "restore-to-root") {
+		t.Error("restore-to-root not listed with the flag on")
+	}
+	rec := httptest.NewRecorder()
+	handleDecompress(rec, httptest.NewRequest(http.MethodPost, "/api/decompress", bytes.NewReader(body)))
+	if got := readTestFile(t, filepath.FromSlash(absolute)); got != "absolute" {
+		t.Errorf("allowed request restored %q: %s", got, rec.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// appendFormat returns the format of an existing archive that Append
+// rewrites with new inputs
+func appendFormat(archive string) (string, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return "", fmt.Errorf("Archive to append to not found: %v", err)
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(ageMagic))
+	n, _ := io.ReadFull(file, magic)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		return "zstd", nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gzip", nil
+	case bytes.HasPrefix(magic, zipMagic):
+		return "zip", nil
+	case bytes.HasPrefix(magic, ageMagic):
+		return "", errors.New("Encrypted archives can't be appended to")
+	}
+	return "", errors.New("Only zstd, gzip and zip archives can be appended to")
+}
+
+// copyArchive writes the entries of the archive being appended to, headers
+// and contents as they are, ahead of the new inputs. Entries stream from the
+// old archive's decoder into the new encoder, so the tar is never held whole.
+func (b *tarBuilder) copyArchive(archive string) error {
+	var read readOptions
+	if b.req.Dictionary != "" {
+		dictionary, err := loadDictionary(b.req.Dictionary)
+		if err != nil {
+			return err
+		}
+		read.dictionaries = append(read.dictionaries, dictionary)
+	}
+
+	if b.names == nil {
+		b.names = make(map[string]bool)
+	}
+
+	return walkArchiveWith(archive, read, func(header *tar.Header, body io.Reader) error {
+		if err := b.contextErr(); err != nil {
+			return err
+		}
+
+		// New provenance replaces the old
+		if header.Typeflag == tar.TypeXGlobalHeader && b.req.Provenance {
+			return nil
+		}
+
+		if err := b.tarWriter.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to copy %s: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+		b.names[header.Name] = true
+
+		if header.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(b.tarWriter, body); err != nil {
+				return fmt.Errorf("failed to copy %s: %v", header.Name, err)
+			}
+			b.totalSize += header.Size
+		}
+		return nil
+	})
+}
+
+// handleAppend adds files to an existing archive, named by output, taking
+// the same request as /api/compress. Framed archives get the new inputs as
+// new frames; other archives are rewritten with their entries unchanged,
+// followed by the new ones.
+func handleAppend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, false, "Invalid request format", nil)
+		return
+	}
+	if req.Output == "" {
+		sendResponse(w, false, "No archive to append to", nil)
+		return
+	}
+	req.Append = true
+	req.Context = r.Context()
+
+	globMatches, err := prepareCompressRequest(&req)
+	if err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+
+	stats, err := compressFiles(req)
+	if err != nil {
+		sendJobResponse(w, req.WebhookURL, "append", false, fmt.Sprintf("Append failed: %v", err), nil)
+		return
+	}
+	stats.GlobMatches = globMatches
+
+	sendJobResponse(w, req.WebhookURL, "append", true, fmt.Sprintf("Appended to %s", stats.OutputFile), stats)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// archiveHeaders returns the headers of an archive's entries by name
+func archiveHeaders(t *testing.T, archive string) map[string]tar.Header {
+	t.Helper()
+	headers := make(map[string]tar.Header)
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		headers[header.Name] = *header
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return headers
+}
+
+func TestAppendKeepsOldEntries(t *testing.T) {
+	for _, format := range []string{"zstd", "gzip"} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, map[string]string{"old/a.txt": "alpha", "old/sub/b.txt": "bravo"}, testMtime)
+			if err := os.Chmod(filepath.Join(dir, "old", "a.txt"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			req := CompressRequest{Files: []string{filepath.Join(dir, "old")}, Output: filepath.Join(dir, "archive"), Level: 3, Format: format}
+			if _, err := prepareCompressRequest(&req); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := compressFiles(req); err != nil {
+				t.Fatal(err)
+			}
+			archive := req.Output
+			before := archiveHeaders(t, archive)
+
+			writeTestFiles(t, dir, map[string]string{"new.txt": "appended"})
+			body, _ := json.Marshal(CompressRequest{Files: []string{filepath.Join(dir, "new.txt")}, Output: archive, Level: 3})
+			rec := httptest.NewRecorder()
+			handleAppend(rec, httptest.NewRequest(http.MethodPost, "/api/append", bytes.NewReader(body)))
+			var resp Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !resp.Success {
+				t.Fatalf("append failed: %s", resp.Message)
+			}
+
+			want := map[string]string{"old": "", "old/a.txt": "alpha", "old/sub": "", "old/sub/b.txt": "bravo", "new.txt": "appended"}
+			got := archiveNames(t, archive)
+			if len(got) != len(want) {
+				t.Errorf("archive holds %v, want %v", got, want)
+			}
+			for name, body := range want {
+				if got[name] != body {
+					t.Errorf("%s holds %q, want %q", name, got[name], body)
+				}
+			}
+
+			// The old entries' headers are copied as they were
+			after := archiveHeaders(t, archive)
+			for name, header := range before {
+				copied := after[name]
+				if copied.Mode != header.Mode || !copied.ModTime.Equal(header.ModTime) {
+					t.Errorf("%s was mode %o at %v, now mode %o at %v", name, header.Mode, header.ModTime, copied.Mode, copied.ModTime)
+				}
+			}
+			if mode := after["old/a.txt"].Mode; mode&0777 != 0600 {
+				t.Errorf("old/a.txt has mode %o", mode)
+			}
+			if when := after["old/a.txt"].ModTime; !when.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+				t.Errorf("old/a.txt has mtime %v", when)
+			}
+
+			// The replacement was written next to the archive and renamed
+			leftovers, _ := filepath.Glob(filepath.Join(dir, ".*"))
+			if len(leftovers) > 0 {
+				t.Errorf("append left %v behind", leftovers)
+			}
+		})
+	}
+}
+
+func TestAppendNeedsAnArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"new.txt": "appended"})
+	req := CompressRequest{Files: []string{filepath.Join(dir, "new.txt")}, Output: filepath.Join(dir, "missing.tar.zst"), Append: true}
+	if _, err := prepareCompressRequest(&req); err == nil {
+		t.Error("appending to a missing archive was accepted")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Limits of the archive cache, set with -archive-cache-size and
+// -archive-cache-entries. The cache is off unless a size is set.
+var (
+	archiveCacheMaxSize    int64
+	archiveCacheMaxEntries = 32
+)
+
+// cachedArchive is a copy of an archive compressFiles produced, kept so an
+// identical request can be answered without compressing again
+type cachedArchive struct {
+	key   string
+	path  string
+	size  int64
+	stats CompressionStats
+
+	// Copies being made of the file, which an eviction leaves for the last
+	// of them to delete
+	readers int
+	evicted bool
+}
+
+var (
+	archiveCacheMu   sync.Mutex
+	archiveCacheSize int64
+	archiveCacheLRU  = list.New() // most recently used first
+	archiveCacheKeys = make(map[string]*list.Element)
+)
+
+// archiveCacheKey hashes what req would archive (entry names, sizes and
+// modification times) with every option of the request but its output, so
+// any change to the inputs, the level or the format changes the key. File
+// contents aren't read, so a file rewritten with the same size and mtime
+// keeps its key. Empty means req can't be cached.
+func archiveCacheKey(req CompressRequest) string {
+	if archiveCacheMaxSize <= 0 || req.Measure || req.Append || req.MaxEntriesPerVolume > 0 ||
+		req.BaseArchive != "" || req.StateFile != "" || !req.Deadline.IsZero() || req.MaxDuration > 0 ||
+		req.NameTransform != nil && req.NameTransformSpec == "" ||
+		req.SymlinkRewrite != nil && req.SymlinkRewriteSpec == "" ||
+		req.EntryFilter != nil && len(req.EntryFilterSpecs) == 0 || len(req.Readers) > 0 {
+		return ""
+	}
+
+	fingerprint, err := inputFingerprint(req)
+	if err != nil {
+		return ""
+	}
+
+	options := req
+	options.Output = ""
+	options.NamePattern = ""
+	options.LogFile = ""
+	options.WebhookURL = ""
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", fingerprint, encoded)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// useCachedArchive copies the cached archive for key, if there is one, to
+// output and returns its stats, less the first run's timings and memory
+// peak. The copy is made without holding the cache lock, so other
+// compressions aren't held up by it; with sync, it's fsynced like a
+// compressed archive would be.
+func useCachedArchive(key, output string, sync bool) (*CompressionStats, bool) {
+	archiveCacheMu.Lock()
+	element, ok := archiveCacheKeys[key]
+	if !ok {
+		archiveCacheMu.Unlock()
+		return nil, false
+	}
+	cached := element.Value.(*cachedArchive)
+	cached.readers++
+	archiveCacheLRU.MoveToFront(element)
+	archiveCacheMu.Unlock()
+
+	err := copyFileTo(cached.path, output, sync)
+	if err == nil && sync {
+		err = syncDir(filepath.Dir(output))
+	}
+
+	archiveCacheMu.Lock()
+	defer archiveCacheMu.Unlock()
+	cached.readers--
+	if err != nil && !cached.evicted {
+		removeCachedArchive(element)
+	} else if cached.evicted && cached.readers == 0 {
+		removeCachedFile(cached)
+	}
+	if err != nil {
+		log.Printf("Failed to reuse cached archive %s: %v", cached.path, err)
+		return nil, false
+	}
+
+	// Timings and memory belong to the run that compressed the archive
+	stats := cached.stats
+	stats.OutputFile = output
+	stats.CacheHit = true
+	stats.Slowest = nil
+	stats.PeakHeapBytes = 0
+	return &stats, true
+}
+
+// cacheArchive keeps a copy of the archive compressFiles wrote for key,
+// evicting the least recently used archives to stay within the limits
+func cacheArchive(key string, stats *CompressionStats) {
+	info, err := os.Stat(stats.OutputFile)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > archiveCacheMaxSize {
+		return
+	}
+
+	ext := strings.TrimPrefix(stats.OutputFile, trimArchiveExt(stats.OutputFile))
+	file, err := os.CreateTemp("", "zstd_cache_*"+ext)
+	if err != nil {
+		log.Printf("Failed to cache archive %s: %v", stats.OutputFile, err)
+		return
+	}
+	path := file.Name()
+	file.Close()
+	if err := copyFileTo(stats.OutputFile, path, false); err != nil {
+		os.Remove(path)
+		log.Printf("Failed to cache archive %s: %v", stats.OutputFile, err)
+		return
+	}
+
+	archiveCacheMu.Lock()
+	defer archiveCacheMu.Unlock()
+
+	if element, ok := archiveCacheKeys[key]; ok {
+		removeCachedArchive(element)
+	}
+	cached := &cachedArchive{key: key, path: path, size: info.Size(), stats: *stats}
+	archiveCacheKeys[key] = archiveCacheLRU.PushFront(cached)
+	archiveCacheSize += cached.size
+
+	for archiveCacheSize > archiveCacheMaxSize || archiveCacheLRU.Len() > archiveCacheMaxEntries {
+		removeCachedArchive(archiveCacheLRU.Back())
+	}
+}
+
+// removeCachedArchive drops an entry and deletes its file, or leaves that
+// to the last copy still being made of it. The caller holds archiveCacheMu.
+func removeCachedArchive(element *list.Element) {
+	cached := archiveCacheLRU.Remove(element).(*cachedArchive)
+	delete(archiveCacheKeys, cached.key)
+	archiveCacheSize -= cached.size
+
+	cached.evicted = true
+	if cached.readers == 0 {
+		removeCachedFile(cached)
+	}
+}
+
+func removeCachedFile(cached *cachedArchive) {
+	if err := os.Remove(cached.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove cached archive %s: %v", cached.path, err)
+	}
+}
+
+// clearArchiveCache deletes every cached archive
+func clearArchiveCache() {
+	archiveCacheMu.Lock()
+	defer archiveCacheMu.Unlock()
+
+	for archiveCacheLRU.Len() > 0 {
+		removeCachedArchive(archiveCacheLRU.Back())
+	}
+}
+
+// copyFileTo copies the file at src to dst, replacing dst, and with sync
+// flushes dst to stable storage
+func copyFileTo(src, dst string, sync bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if sync {
+		if err := fsync(out); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
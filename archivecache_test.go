@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// enableArchiveCache turns the archive cache on for a test, emptying it
+// afterwards
+func enableArchiveCache(t *testing.T, size int64, entries int) {
+	t.Helper()
+
+	oldSize, oldEntries := archiveCacheMaxSize, archiveCacheMaxEntries
+	archiveCacheMaxSize, archiveCacheMaxEntries = size, entries
+	t.Cleanup(func() {
+		clearArchiveCache()
+		archiveCacheMaxSize, archiveCacheMaxEntries = oldSize, oldEntries
+	})
+}
+
+func compressForCache(t *testing.T, input, output string) *CompressionStats {
+	t.Helper()
+
+	stats, err := compressFiles(CompressRequest{Files: []string{input}, Output: output, Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestArchiveCacheIsOffByDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+
+	if key := archiveCacheKey(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Level: 3}); key != "" {
+		t.Errorf("got cache key %s with the cache off", key)
+	}
+}
+
+func TestArchiveCacheHitAndMiss(t *testing.T) {
+	enableArchiveCache(t, 1<<20, 4)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "contents"})
+
+	first := compressForCache(t, input, filepath.Join(dir, "1.tar.zst"))
+	if first.CacheHit {
+		t.Fatal("first compression was a cache hit")
+	}
+
+	second := compressForCache(t, input, filepath.Join(dir, "2.tar.zst"))
+	if !second.CacheHit {
+		t.Fatal("identical compression missed the cache")
+	}
+	if second.OutputFile != filepath.Join(dir, "2.tar.zst") {
+		t.Errorf("hit reports output %s", second.OutputFile)
+	}
+	if readTestFile(t, filepath.Join(dir, "1.tar.zst")) != readTestFile(t, filepath.Join(dir, "2.tar.zst")) {
+		t.Error("cached copy differs from the archive")
+	}
+
+	// Any change to the inputs is a different archive
+	later := time.Now().Add(time.Hour)
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "changed!"})
+	if err := os.Chtimes(filepath.Join(input, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if third := compressForCache(t, input, filepath.Join(dir, "3.tar.zst")); third.CacheHit {
+		t.Error("changed inputs hit the cache")
+	}
+}
+
+// A hit reports its own run, not the one that compressed the archive
+func TestArchiveCacheHitReportsItsOwnRun(t *testing.T) {
+	enableArchiveCache(t, 1<<20, 4)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "contents"})
+
+	req := CompressRequest{Files: []string{input}, Output: filepath.Join(dir, "1.tar.zst"), Level: 3, SlowestFiles: 1, ReportMemory: true}
+	first, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Slowest) == 0 || first.PeakHeapBytes == 0 {
+		t.Fatalf("first run reported slowest %v and peak heap %d", first.Slowest, first.PeakHeapBytes)
+	}
+
+	var events []Progress
+	req.Output = filepath.Join(dir, "2.tar.zst")
+	req.OnProgress = func(p Progress) { events = append(events, p) }
+	second, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.CacheHit {
+		t.Fatal("identical compression missed the cache")
+	}
+	if len(second.Slowest) != 0 || second.PeakHeapBytes != 0 {
+		t.Errorf("hit reported slowest %v and peak heap %d from the first run", second.Slowest, second.PeakHeapBytes)
+	}
+	if second.Duration == "" {
+		t.Error("hit reported no duration")
+	}
+	if len(events) == 0 || events[len(events)-1].Bytes != second.OriginalSize {
+		t.Errorf("hit reported progress %+v, want a final event with %d bytes", events, second.OriginalSize)
+	}
+}
+
+func TestArchiveCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	enableArchiveCache(t, 1<<20, 1)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a/file.txt": "a", "b/file.txt": "b"})
+
+	compressForCache(t, filepath.Join(dir, "a"), filepath.Join(dir, "a1.tar.zst"))
+	compressForCache(t, filepath.Join(dir, "b"), filepath.Join(dir, "b1.tar.zst"))
+
+	archiveCacheMu.Lock()
+	var cachedPaths []string
+	for element := archiveCacheLRU.Front(); element != nil; element = element.Next() {
+		cachedPaths = append(cachedPaths, element.Value.(*cachedArchive).path)
+	}
+	archiveCacheMu.Unlock()
+	if len(cachedPaths) != 1 {
+		t.Fatalf("cache holds %d archives, want 1", len(cachedPaths))
+	}
+
+	if stats := compressForCache(t, filepath.Join(dir, "a"), filepath.Join(dir, "a2.tar.zst")); stats.CacheHit {
+		t.Error("evicted archive was a cache hit")
+	}
+	if stats := compressForCache(t, filepath.Join(dir, "a"), filepath.Join(dir, "a3.tar.zst")); !stats.CacheHit {
+		t.Error("recached archive missed")
+	}
+	if _, err := os.Stat(cachedPaths[0]); !os.IsNotExist(err) {
+		t.Errorf("evicted archive %s is still on disk", cachedPaths[0])
+	}
+}
+
+// An archive evicted while it's copied stays until the copy is done
+func TestArchiveCacheKeepsFileWhileCopied(t *testing.T) {
+	enableArchiveCache(t, 1<<20, 4)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+	compressForCache(t, filepath.Join(dir, "in"), filepath.Join(dir, "1.tar.zst"))
+
+	archiveCacheMu.Lock()
+	element := archiveCacheLRU.Front()
+	cached := element.Value.(*cachedArchive)
+	cached.readers++
+	removeCachedArchive(element)
+	archiveCacheMu.Unlock()
+
+	defer removeCachedFile(cached)
+
+	if _, err := os.Stat(cached.path); err != nil {
+		t.Fatalf("archive being copied was deleted: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lowerPasswordWorkFactor keeps scrypt from slowing the tests down
+func lowerPasswordWorkFactor(t *testing.T) {
+	old := passwordWorkFactor
+	passwordWorkFactor = 10
+	t.Cleanup(func() { passwordWorkFactor = old })
+}
+
+// auditedPost sends body to handler through the audit log, with password in
+// the password header when set
+func auditedPost(t *testing.T, handler http.HandlerFunc, body any, password string) Response {
+	t.Helper()
+
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	if password != "" {
+		req.Header.Set(passwordHeader, password)
+	}
+	rec := httptest.NewRecorder()
+	auditLog(handler).ServeHTTP(rec, req)
+
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestPasswordHeaderEncryptsAndIsRedacted(t *testing.T) {
+	lowerPasswordWorkFactor(t)
+	const password = "correct horse battery staple"
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"in/secret.txt": "contents"})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	oldAudit := auditRequests
+	auditRequests = true
+	defer func() { auditRequests = oldAudit }()
+
+	resp := auditedPost(t, handleCompress, CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: "in"}, password)
+	if !resp.Success {
+		t.Fatalf("compress failed: %s", resp.Message)
+	}
+	archive := filepath.Join(dir, "in.zst.age")
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, ageMagic) || bytes.Contains(data, []byte("secret.txt")) {
+		t.Fatalf("archive isn't encrypted")
+	}
+
+	resp = auditedPost(t, handleDecompress, DecompressRequest{Archive: archive, OutputDir: "out"}, password)
+	if !resp.Success {
+		t.Fatalf("decompress failed: %s", resp.Message)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "out", "in", "secret.txt")); got != "contents" {
+		t.Errorf("extracted %q", got)
+	}
+
+	if strings.Contains(logged.String(), password) {
+		t.Errorf("audit log holds the password: %s", logged.String())
+	}
+	if strings.Count(logged.String(), "X-Archive-Password=[redacted]") != 2 {
+		t.Errorf("audit log doesn't show the redacted header for both requests: %s", logged.String())
+	}
+}
+
+func TestPasswordIsNeededToExtract(t *testing.T) {
+	lowerPasswordWorkFactor(t)
+
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"in/secret.txt": "contents"})
+
+	resp := auditedPost(t, handleCompress, CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: "in", Password: "right"}, "")
+	if !resp.Success {
+		t.Fatalf("compress failed: %s", resp.Message)
+	}
+	archive := filepath.Join(dir, "in.zst.age")
+
+	for _, password := range []string{"", "wrong"} {
+		resp := auditedPost(t, handleDecompress, DecompressRequest{Archive: archive, OutputDir: "out"}, password)
+		if resp.Success {
+			t.Errorf("password %q extracted the archive", password)
+		}
+	}
+}
+
+func TestPasswordHeaderConflictsWithBody(t *testing.T) {
+	resp := auditedPost(t, handleDecompress, DecompressRequest{Archive: "a.tar.zst", Password: "one"}, "two")
+	if resp.Success || !strings.Contains(resp.Message, "both") {
+		t.Errorf("conflicting passwords got %q", resp.Message)
+	}
+}
+
+func TestEncryptedZipIsRejected(t *testing.T) {
+	resp := auditedPost(t, handleCompress, CompressRequest{Files: []string{"a.txt"}, Format: "zip", Password: "pw"}, "")
+	if resp.Success {
+		t.Errorf("an encrypted zip was accepted")
+	}
+}
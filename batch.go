@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// batchParallelism is how many archives of a batch are compressed at once
+// at most, set with -batch-parallelism
+var batchParallelism = runtime.NumCPU()
+
+// BatchResult is the outcome of one archive in a batch, at the index of its
+// request. Data is what /api/compress would have returned for it.
+type BatchResult struct {
+	Index   int         `json:"index"`
+	Output  string      `json:"output"`
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// handleCompressBatch compresses several independent archives concurrently,
+// given as a JSON array of compress requests. An archive that fails doesn't
+// stop the others; each gets its own result. parallelism=N lowers the
+// number compressed at once below -batch-parallelism.
+func handleCompressBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reqs []CompressRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		sendResponse(w, false, "Invalid request format", nil)
+		return
+	}
+	if len(reqs) == 0 {
+		sendResponse(w, false, "No archives in the batch", nil)
+		return
+	}
+
+	parallelism := batchParallelism
+	if value := r.URL.Query().Get("parallelism"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			sendResponse(w, false, "Invalid parallelism", nil)
+			return
+		}
+		if n < parallelism {
+			parallelism = n
+		}
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make([]BatchResult, len(reqs))
+	outputs := make(map[string]int)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+
+	for i := range reqs {
+		req := reqs[i]
+		req.Context = r.Context()
+		results[i] = BatchResult{Index: i, Output: req.Output}
+
+		globMatches, err := prepareCompressRequest(&req)
+		if err != nil {
+			results[i].Message = err.Error()
+			continue
+		}
+		results[i].Output = req.Output
+
+		// Two archives written to one file would corrupt each other
+		if !req.DryRun && !req.Measure {
+			output, _ := filepath.Abs(req.Output)
+			if first, ok := outputs[output]; ok {
+				results[i].Message = fmt.Sprintf("Output is the same as archive %d's", first)
+				continue
+			}
+			outputs[output] = i
+		}
+
+		wg.Add(1)
+		go func(i int, req CompressRequest) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i].Success, results[i].Message, results[i].Data = compressBatchEntry(req, globMatches)
+			notifyWebhook(req.WebhookURL, "compress", results[i].Success, results[i].Message, results[i].Data)
+		}(i, req)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+
+	sendResponse(w, true, fmt.Sprintf("Batch completed: %d of %d archives succeeded", succeeded, len(reqs)), results)
+}
+
+// compressBatchEntry runs one prepared request of a batch, replying as
+// handleCompress would
+func compressBatchEntry(req CompressRequest, globMatches int) (bool, string, interface{}) {
+	if req.DryRun {
+		entries, totalSize, err := dryRunEntries(req)
+		if err != nil {
+			return false, fmt.Sprintf("Dry run failed: %v", err), nil
+		}
+		return true, fmt.Sprintf("Dry run: %d entries, %d bytes uncompressed", len(entries), totalSize), entries
+	}
+
+	stats, err := compressFiles(req)
+	if err != nil {
+		return false, fmt.Sprintf("Compression failed: %v", err), nil
+	}
+	stats.GlobMatches = globMatches
+
+	return true, compressMessage(req, stats), stats
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressBatchMixedInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"one/a.txt": "alpha", "two/b.txt": "bravo"})
+
+	reqs := []CompressRequest{
+		{Files: []string{filepath.Join(dir, "one")}, Output: filepath.Join(dir, "one.tar.zst"), Level: 3},
+		{Files: []string{filepath.Join(dir, "missing")}, Output: filepath.Join(dir, "missing.tar.zst"), Level: 3},
+		{Files: []string{filepath.Join(dir, "two")}, Output: filepath.Join(dir, "two.tar.zst"), Level: 3},
+		{Files: []string{filepath.Join(dir, "two")}, Output: filepath.Join(dir, "one.tar.zst"), Level: 3},
+		{Files: []string{filepath.Join(dir, "one")}, Output: filepath.Join(dir, "bad.tar.zst"), Exclude: []string{""}},
+	}
+	body, _ := json.Marshal(reqs)
+	rec := httptest.NewRecorder()
+	handleCompressBatch(rec, httptest.NewRequest(http.MethodPost, "/api/compress-batch?parallelism=2", bytes.NewReader(body)))
+
+	var resp struct {
+		Success bool
+		Message string
+		Data    []BatchResult
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success || !strings.Contains(resp.Message, "2 of 5") {
+		t.Fatalf("batch replied %v %q", resp.Success, resp.Message)
+	}
+	if len(resp.Data) != len(reqs) {
+		t.Fatalf("got %d results for %d archives", len(resp.Data), len(reqs))
+	}
+
+	wantFailure := map[int]string{1: "Compression failed", 3: "same as archive 0", 4: "Invalid exclude pattern"}
+	for i, result := range resp.Data {
+		if result.Index != i {
+			t.Errorf("result %d has index %d", i, result.Index)
+		}
+		if want, failed := wantFailure[i]; failed {
+			if result.Success || !strings.Contains(result.Message, want) {
+				t.Errorf("archive %d: %v %q, want a failure mentioning %q", i, result.Success, result.Message, want)
+			}
+		} else if !result.Success {
+			t.Errorf("archive %d failed: %s", i, result.Message)
+		}
+	}
+
+	// The failures left the good archives alone
+	if got := archiveNames(t, filepath.Join(dir, "one.tar.zst")); got["one/a.txt"] != "alpha" {
+		t.Errorf("one.tar.zst holds %v", got)
+	}
+	if got := archiveNames(t, filepath.Join(dir, "two.tar.zst")); got["two/b.txt"] != "bravo" {
+		t.Errorf("two.tar.zst holds %v", got)
+	}
+}
+
+func TestCompressBatchRejectsBadParallelism(t *testing.T) {
+	body, _ := json.Marshal([]CompressRequest{{Files: []string{"a"}}})
+	rec := httptest.NewRecorder()
+	handleCompressBatch(rec, httptest.NewRequest(http.MethodPost, "/api/compress-batch?parallelism=0", bytes.NewReader(body)))
+	if !strings.Contains(rec.Body.String(), "Invalid parallelism") {
+		t.Errorf("parallelism=0 got %s", rec.Body.String())
+	}
+}
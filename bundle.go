@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bundleIndexName is the first entry of a bundle, listing the archives in it
+const bundleIndexName = "bundle-index.json"
+
+type bundleIndex struct {
+	Archives []bundleArchive `json:"archives"`
+}
+
+type bundleArchive struct {
+	Name    string    `json:"name"`
+	Source  string    `json:"-"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// handleDownloadBundle streams the archives named by the repeated file
+// parameter as one uncompressed tar, so several results are one download.
+// The archives are already compressed, so the bundle isn't compressed again.
+func handleDownloadBundle(w http.ResponseWriter, r *http.Request) {
+	files := r.URL.Query()["file"]
+	if len(files) == 0 {
+		http.Error(w, "File parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check every archive before the first byte is sent, since errors can't
+	// be reported once streaming has started
+	var index bundleIndex
+	seen := make(map[string]bool)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			http.Error(w, "File not found: "+filepath.Base(file), http.StatusNotFound)
+			return
+		}
+
+		name := filepath.Base(file)
+		if seen[name] || name == bundleIndexName {
+			http.Error(w, "Duplicate file name in bundle: "+name, http.StatusBadRequest)
+			return
+		}
+		seen[name] = true
+
+		index.Archives = append(index.Archives, bundleArchive{Name: name, Source: file, Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to create bundle index", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", "attachment; filename=bundle.tar")
+
+	tarWriter := tar.NewWriter(w)
+	now := time.Now()
+
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: bundleIndexName, Size: int64(len(indexData)), Mode: 0644, ModTime: now}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		log.Printf("Bundle download failed: %v", err)
+		return
+	}
+	if _, err := tarWriter.Write(indexData); err != nil {
+		log.Printf("Bundle download failed: %v", err)
+		return
+	}
+
+	for _, archive := range index.Archives {
+		if err := writeBundleEntry(tarWriter, archive); err != nil {
+			log.Printf("Bundle download failed: %v", err)
+			return
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		log.Printf("Bundle download failed: %v", err)
+	}
+}
+
+// writeBundleEntry copies one archive into the bundle. It copies exactly
+// the size recorded in the index, so a file that changed since fails the
+// bundle instead of corrupting it.
+func writeBundleEntry(tarWriter *tar.Writer, archive bundleArchive) error {
+	file, err := os.Open(archive.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: archive.Name, Size: archive.Size, Mode: 0644, ModTime: archive.ModTime}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(tarWriter, file, archive.Size)
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadBundle(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+	query := url.Values{}
+	originals := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		archive := filepath.Join(dir, name+".tar.zst")
+		if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, name+".txt")}, Output: archive, Level: 3}); err != nil {
+			t.Fatal(err)
+		}
+		query.Add("file", archive)
+		originals[name+".tar.zst"] = readTestFile(t, archive)
+	}
+
+	rec := httptest.NewRecorder()
+	handleDownloadBundle(rec, httptest.NewRequest(http.MethodGet, "/api/download-bundle?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The index comes first, then each archive byte for byte
+	bundle := tar.NewReader(rec.Body)
+	var names []string
+	contents := map[string]string{}
+	for {
+		header, err := bundle.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		contents[header.Name] = string(data)
+	}
+	if len(names) != 3 || names[0] != bundleIndexName {
+		t.Fatalf("bundle holds %v, want the index and two archives", names)
+	}
+	var index bundleIndex
+	if err := json.Unmarshal([]byte(contents[bundleIndexName]), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Archives) != 2 || index.Archives[0].Name != "a.tar.zst" || index.Archives[1].Name != "b.tar.zst" {
+		t.Errorf("index lists %+v", index.Archives)
+	}
+	for name, original := range originals {
+		if contents[name] != original {
+			t.Errorf("%s differs from the original archive", name)
+		}
+	}
+}
+
+func TestDownloadBundleRejectsDuplicateNames(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"one/x.tar.zst": "1", "two/x.tar.zst": "2"})
+	query := url.Values{"file": {filepath.Join(dir, "one", "x.tar.zst"), filepath.Join(dir, "two", "x.tar.zst")}}
+
+	rec := httptest.NewRecorder()
+	handleDownloadBundle(rec, httptest.NewRequest(http.MethodGet, "/api/download-bundle?"+query.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("duplicate names got status %d", rec.Code)
+	}
+}
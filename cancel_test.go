@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCanceledCompressionRemovesOutput(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/first.txt": "first", "in/second.txt": "second"})
+	archive := filepath.Join(dir, "canceled.tar.zst")
+	req := CompressRequest{
+		Files:   []string{filepath.Join(dir, "in")},
+		Output:  archive,
+		Level:   3,
+		Context: ctx,
+		// Cancel as soon as the first progress update arrives
+		OnProgress: func(Progress) { cancel() },
+	}
+	_, err := compressFiles(req)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("got %v, want the cancellation", err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("partial archive left behind: %v", err)
+	}
+}
+
+// cancelingFS is the OS filesystem, except that the first write to a file
+// cancels the request
+type cancelingFS struct {
+	osFS
+	cancel context.CancelFunc
+}
+
+func (c cancelingFS) OpenFile(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	file, err := c.osFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelingWriter{WriteCloser: file, cancel: c.cancel}, nil
+}
+
+type cancelingWriter struct {
+	io.WriteCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelingWriter) Write(p []byte) (int, error) {
+	c.cancel()
+	return c.WriteCloser.Write(p)
+}
+
+func TestCanceledExtractionRemovesPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "large.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "large.bin", body: strings.Repeat("x", 4<<20)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := filepath.Join(dir, "out")
+	req := DecompressRequest{Archive: archive, OutputDir: output, Context: ctx, FS: cancelingFS{cancel: cancel}}
+	if _, err := decompressFile(req); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("got %v, want the cancellation", err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "large.bin")); !os.IsNotExist(err) {
+		t.Errorf("partially extracted file left behind: %v", err)
+	}
+}
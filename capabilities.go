@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// extractFormats lists the archive formats /api/decompress reads, besides
+// single files compressed with the zstd, gzip or xz tools
+var extractFormats = []string{"zstd", "gzip", "xz", "zip"}
+
+// features lists what the server can do, unless turned off with
+// -disable-features
+var features = []string{
+	"encryption", "dictionaries", "frames", "volumes", "incremental", "append",
+	"batch", "compress-stream", "decompress-stream", "progress-websocket",
+	"remote-inputs", "inline-contents", "nested-archives", "scan", "webhooks",
+}
+
+// disabledFeatures holds the features -disable-features turned off
+var disabledFeatures = map[string]bool{}
+
+// parseDisabledFeatures parses a comma-separated list of features to turn off
+func parseDisabledFeatures(spec string) (map[string]bool, error) {
+	disabled := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, feature := range features {
+			known = known || feature == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown feature %q, expected one of: %s", name, strings.Join(features, ", "))
+		}
+		disabled[name] = true
+	}
+	return disabled, nil
+}
+
+// featureError is returned for requests using a disabled feature
+func featureError(name string) error {
+	return fmt.Errorf("The %s feature is disabled on this server", name)
+}
+
+// requireFeature serves an endpoint only while its feature is enabled
+func requireFeature(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if disabledFeatures[name] {
+			http.Error(w, featureError(name).Error(), http.StatusNotFound)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkCompressFeatures refuses compress requests using disabled features
+func checkCompressFeatures(req CompressRequest) error {
+	uses := map[string]bool{
+		"encryption":      len(req.Recipients) > 0 || req.Password != "",
+		"dictionaries":    req.Dictionary != "",
+		"frames":          req.Frames,
+		"volumes":         req.MaxEntriesPerVolume > 0,
+		"incremental":     req.BaseArchive != "" || req.StateFile != "",
+		"append":          req.Append,
+		"inline-contents": len(req.Contents) > 0,
+		"webhooks":        req.WebhookURL != "",
+	}
+	for _, file := range req.Files {
+		uses["remote-inputs"] = uses["remote-inputs"] || isRemoteURL(file)
+	}
+	return usedFeatureError(uses)
+}
+
+// checkDecompressFeatures refuses decompress and scan requests using
+// disabled features
+func checkDecompressFeatures(req DecompressRequest) error {
+	return usedFeatureError(map[string]bool{
+		"encryption":      req.Identity != "" || req.Password != "",
+		"dictionaries":    len(req.Dictionaries) > 0,
+		"incremental":     req.BaseArchive != "",
+		"nested-archives": req.ExtractNested,
+		"webhooks":        req.WebhookURL != "",
+	})
+}
+
+func usedFeatureError(uses map[string]bool) error {
+	for _, feature := range features {
+		if uses[feature] && disabledFeatures[feature] {
+			return featureError(feature)
+		}
+	}
+	return nil
+}
+
+// Capabilities describes what this server instance supports and how it's
+// configured, for clients that adapt to differently set up servers
+type Capabilities struct {
+	Version        string           `json:"version"`
+	Formats        []string         `json:"formats"`
+	DefaultFormat  string           `json:"defaultFormat"`
+	ExtractFormats []string         `json:"extractFormats"`
+	MinLevel       int              `json:"minLevel"`
+	MaxLevel       int              `json:"maxLevel"`
+	Features       []string         `json:"features"`
+	Limits         CapabilityLimits `json:"limits"`
+	AuthRequired   bool             `json:"authRequired"`
+}
+
+// CapabilityLimits are the configured limits, in bytes where they're sizes.
+// A cache size of 0 means the archive cache is off, an upload TTL of 0 that
+// uploads are kept until shutdown.
+type CapabilityLimits struct {
+	MaxUploadBytes      int64  `json:"maxUploadBytes"`
+	MaxUploadTotalBytes int64  `json:"maxUploadTotalBytes"`
+	UploadTTL           string `json:"uploadTtl"`
+	MaxListEntries      int    `json:"maxListEntries"`
+	BatchParallelism    int    `json:"batchParallelism"`
+	ArchiveCacheSize    int64  `json:"archiveCacheSize"`
+	ArchiveCacheEntries int    `json:"archiveCacheEntries"`
+	RemoteMaxSize       int64  `json:"remoteMaxSize"`
+	NestedMaxDepth      int    `json:"nestedMaxDepth"`
+}
+
+// serverCapabilities reports the capabilities of the current configuration
+func serverCapabilities() Capabilities {
+	enabled := []string{}
+	for _, feature := range features {
+		if !disabledFeatures[feature] {
+			enabled = append(enabled, feature)
+		}
+	}
+	if webhookSecret != "" && !disabledFeatures["webhooks"] {
+		enabled = append(enabled, "webhook-signatures")
+	}
+	if archiveCacheMaxSize > 0 {
+		enabled = append(enabled, "archive-cache")
+	}
+	if stripSetuid {
+		enabled = append(enabled, "strip-setuid")
+	}
+	if allowRestoreToRoot {
+		enabled = append(enabled, "restore-to-root")
+	}
+
+	return Capabilities{
+		Version:        version,
+		Formats:        supportedFormats,
+		DefaultFormat:  defaultFormat,
+		ExtractFormats: extractFormats,
+		MinLevel:       1,
+		MaxLevel:       maxLevel,
+		Features:       enabled,
+		Limits: CapabilityLimits{
+			MaxUploadBytes:      maxUploadBytes,
+			MaxUploadTotalBytes: maxUploadTotalBytes,
+			UploadTTL:           uploadTTL.String(),
+			MaxListEntries:      maxListEntries,
+			BatchParallelism:    batchParallelism,
+			ArchiveCacheSize:    archiveCacheMaxSize,
+			ArchiveCacheEntries: archiveCacheMaxEntries,
+			RemoteMaxSize:       remoteMaxSize,
+			NestedMaxDepth:      nestedMaxDepth,
+		},
+		// The server has no authentication of its own
+		AuthRequired: false,
+	}
+}
+
+// handleCapabilities reports the formats, features and limits of this
+// server, so a generic frontend can adapt to how it's configured
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sendResponse(w, true, "Server capabilities", serverCapabilities())
+}
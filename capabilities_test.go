@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// disableFeatures turns features off for a test
+func disableFeatures(t *testing.T, spec string) {
+	t.Helper()
+
+	disabled, err := parseDisabledFeatures(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := disabledFeatures
+	disabledFeatures = disabled
+	t.Cleanup(func() { disabledFeatures = old })
+}
+
+func TestCapabilitiesReflectConfiguration(t *testing.T) {
+	if !slices.Contains(serverCapabilities().Features, "encryption") {
+		t.Fatal("encryption isn't listed by default")
+	}
+
+	disableFeatures(t, "encryption, scan")
+	listed := serverCapabilities().Features
+	if slices.Contains(listed, "encryption") || slices.Contains(listed, "scan") {
+		t.Errorf("disabled features still listed: %v", listed)
+	}
+	if !slices.Contains(listed, "dictionaries") {
+		t.Errorf("enabled features missing: %v", listed)
+	}
+
+	_, err := prepareCompressRequest(&CompressRequest{Files: []string{"a.txt"}, Recipients: []string{"age1x"}})
+	if err == nil || !strings.Contains(err.Error(), "encryption feature is disabled") {
+		t.Errorf("encrypting with encryption disabled got %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	requireFeature("scan", handleScan)(rec, httptest.NewRequest(http.MethodPost, "/api/scan", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("disabled endpoint answered %d", rec.Code)
+	}
+}
+
+func TestParseDisabledFeaturesRejectsUnknown(t *testing.T) {
+	if _, err := parseDisabledFeatures("encryption,teleport"); err == nil {
+		t.Error("unknown feature accepted")
+	}
+}
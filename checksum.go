@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checksumSuffix is appended to an archive's name for its SHA-256 sidecar,
+// written in the format sha256sum reads
+const checksumSuffix = ".sha256"
+
+func writeChecksumFile(archive, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(archive))
+	if err := os.WriteFile(archive+checksumSuffix, []byte(line), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %v", err)
+	}
+	return nil
+}
+
+func readChecksumFile(archive string) (string, error) {
+	data, err := os.ReadFile(archive + checksumSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to read checksum file: %v", err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("checksum file %s is malformed", filepath.Base(archive+checksumSuffix))
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// handleVerify checks an archive against the SHA-256 recorded next to it
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	expected, err := readChecksumFile(archive)
+	if err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+
+	actual, err := fileSHA256(archive)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to read archive: %v", err), nil)
+		return
+	}
+
+	data := map[string]interface{}{
+		"archive":  archive,
+		"expected": expected,
+		"actual":   actual,
+	}
+	if actual != expected {
+		sendResponse(w, false, "Checksum mismatch: the archive is corrupted or was modified", data)
+		return
+	}
+
+	sendResponse(w, true, "Archive matches its checksum", data)
+}
+
+// contentDigest hashes what an archive holds rather than its bytes: the
+// name, type, mode, size, link target, modification time (to the second) and
+// contents of every entry, in name order. The same files archived at any
+// level, or as zstd, gzip, xz or zip, get the same digest. Global headers,
+// like provenance, aren't part of the content.
+func contentDigest(archive string) (string, int, error) {
+	var records []string
+
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+
+		contents := sha256.New()
+		if _, err := io.Copy(contents, body); err != nil {
+			return fmt.Errorf("failed to read %s: %v", header.Name, err)
+		}
+
+		records = append(records, fmt.Sprintf("%s\x00%c\x00%o\x00%d\x00%s\x00%d\x00%x\n",
+			strings.TrimSuffix(header.Name, "/"), header.Typeflag, header.FileInfo().Mode(),
+			header.Size, header.Linkname, header.ModTime.Unix(), contents.Sum(nil)))
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	sort.Strings(records)
+
+	digest := sha256.New()
+	for _, record := range records {
+		io.WriteString(digest, record)
+	}
+	return hex.EncodeToString(digest.Sum(nil)), len(records), nil
+}
+
+// handleDigest reports an archive's content digest, see contentDigest
+func handleDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	digest, entries, err := contentDigest(archive)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to read archive: %v", err), nil)
+		return
+	}
+
+	sendResponse(w, true, "Content digest computed successfully", map[string]interface{}{
+		"archive": archive,
+		"digest":  digest,
+		"entries": entries,
+	})
+}
+
+// checksumTrailer carries the SHA-256 of a download's body, sent as an HTTP
+// trailer so the file can be hashed while it's served
+const checksumTrailer = "X-Content-Sha256"
+
+// checksumResponseWriter hashes everything written to the response
+type checksumResponseWriter struct {
+	http.ResponseWriter
+	hash    hash.Hash
+	written int64
+}
+
+// WriteHeader drops the Content-Length ServeFile sets, as a response with
+// one isn't chunked and can't carry trailers
+func (c *checksumResponseWriter) WriteHeader(status int) {
+	c.Header().Del("Content-Length")
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *checksumResponseWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.hash.Write(p[:n])
+	c.written += int64(n)
+	return n, err
+}
+
+// serveWithChecksum serves a file with http.ServeFile and sends the SHA-256
+// of the bytes actually served (the requested range, for a range request)
+// in the checksumTrailer trailer.
+func serveWithChecksum(w http.ResponseWriter, r *http.Request, path string) {
+	w.Header().Set("Trailer", checksumTrailer)
+
+	cw := &checksumResponseWriter{ResponseWriter: w, hash: sha256.New()}
+	http.ServeFile(cw, r, path)
+
+	if cw.written > 0 {
+		w.Header().Set(checksumTrailer, hex.EncodeToString(cw.hash.Sum(nil)))
+	}
+}
+
+// serveFile serves path, hashing it on the way when the request asks for
+// checksum=sha256. Without it the response keeps its Content-Length, so
+// clients can show download progress.
+func serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	switch r.URL.Query().Get("checksum") {
+	case "":
+		http.ServeFile(w, r, path)
+	case "sha256":
+		serveWithChecksum(w, r, path)
+	default:
+		http.Error(w, "Unsupported checksum", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func verifyArchive(t *testing.T, archive string) Response {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handleVerify(rec, httptest.NewRequest(http.MethodGet, "/api/verify?archive="+url.QueryEscape(archive), nil))
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": strings.Repeat("checksummed ", 100)})
+	archive := filepath.Join(dir, "sum.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: archive, Level: 3, Checksum: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sidecar is in the format sha256sum reads
+	sum, err := fileSHA256(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, archive+checksumSuffix); got != sum+"  sum.tar.zst\n" {
+		t.Errorf("sidecar holds %q", got)
+	}
+	if resp := verifyArchive(t, archive); !resp.Success {
+		t.Fatalf("intact archive failed verification: %s", resp.Message)
+	}
+
+	// Flip one byte in the middle
+	data := []byte(readTestFile(t, archive))
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := verifyArchive(t, archive); resp.Success || !strings.Contains(resp.Message, "Checksum mismatch") {
+		t.Errorf("corrupted archive got %+v", resp)
+	}
+}
+
+func TestNoSidecarByDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha"})
+
+	resp := postCompress(t, CompressRequest{Files: []string{"a.txt"}, Output: "plain.tar.zst", Level: 3})
+	if !resp.Success {
+		t.Fatalf("compression failed: %s", resp.Message)
+	}
+	if resp.Data.Checksum != "" {
+		t.Errorf("stats carry checksum %s", resp.Data.Checksum)
+	}
+	if _, err := os.Stat("plain.tar.zst" + checksumSuffix); !os.IsNotExist(err) {
+		t.Errorf("sidecar written without checksum: %v", err)
+	}
+}
+
+func TestVerifyWithoutSidecar(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plain.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "a.txt", body: "alpha"})
+	if resp := verifyArchive(t, archive); resp.Success || !strings.Contains(resp.Message, "failed to read checksum file") {
+		t.Errorf("archive without a sidecar got %+v", resp)
+	}
+}
+
+func TestContentDigestIgnoresCompression(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFiles(t, input, map[string]string{"a.txt": strings.Repeat("digest ", 1000), "sub/b.txt": "bravo"}, testMtime)
+
+	digest := func(output string, level int, format string) string {
+		t.Helper()
+		output = filepath.Join(dir, output)
+		if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: output, Level: level, Format: format}); err != nil {
+			t.Fatal(err)
+		}
+		sum, entries, err := contentDigest(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries != 4 {
+			t.Errorf("%s digest covers %d entries, want 4", output, entries)
+		}
+		return sum
+	}
+
+	fast := digest("fast.tar.zst", 3, "zstd")
+	if best := digest("best.tar.zst", 19, "zstd"); best != fast {
+		t.Errorf("level 19 digest %s differs from level 3's %s", best, fast)
+	}
+	if gzipped := digest("gzip.tar.gz", 6, "gzip"); gzipped != fast {
+		t.Errorf("gzip digest %s differs from zstd's %s", gzipped, fast)
+	}
+
+	// Changing a file changes the digest. The change dates the file, as an
+	// edit would, or the archive cache would answer with the old archive.
+	writeTestFiles(t, input, map[string]string{"sub/b.txt": "BRAVO"}, testMtime.Add(time.Hour))
+	if changed := digest("changed.tar.zst", 3, "zstd"); changed == fast {
+		t.Error("digest didn't change with the contents")
+	}
+}
+
+// fetchWithTrailer downloads path from a test server running handler and
+// returns the body with the checksum trailer
+func fetchWithTrailer(t *testing.T, handler http.HandlerFunc, path string, header http.Header) (*http.Response, []byte, string) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Trailers are only filled in once the body has been read
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body, resp.Trailer.Get(checksumTrailer)
+}
+
+func TestDownloadChecksumTrailer(t *testing.T) {
+	defer cleanupExpiredDownloads(time.Now().Add(downloadZipTTL + time.Second))
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"extracted/a.txt": strings.Repeat("downloaded ", 1000)})
+	file := filepath.Join(dir, "extracted", "a.txt")
+
+	sha := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	resp, body, trailer := fetchWithTrailer(t, handleDownload, "/api/download?checksum=sha256&file="+url.QueryEscape(file), nil)
+	if string(body) != readTestFile(t, file) {
+		t.Fatalf("downloaded %d bytes, status %d", len(body), resp.StatusCode)
+	}
+	if trailer != sha(body) {
+		t.Errorf("trailer checksum %q doesn't match the body's %s", trailer, sha(body))
+	}
+
+	// A range gets the checksum of the bytes actually sent
+	_, body, trailer = fetchWithTrailer(t, handleDownload, "/api/download?checksum=sha256&file="+url.QueryEscape(file), http.Header{"Range": {"bytes=10-99"}})
+	if len(body) != 90 || trailer != sha(body) {
+		t.Errorf("range got %d bytes with trailer %q, want 90 bytes with %s", len(body), trailer, sha(body))
+	}
+
+	// The zip of an extracted directory too
+	_, body, trailer = fetchWithTrailer(t, handleDownloadExtracted, "/api/download-extracted?checksum=sha256&dir="+url.QueryEscape(filepath.Join(dir, "extracted")), nil)
+	if len(body) == 0 || trailer != sha(body) {
+		t.Errorf("extracted zip trailer %q doesn't match the body's %s", trailer, sha(body))
+	}
+
+	// Without checksum=sha256 there's no trailer, and the length is known
+	resp, _, trailer = fetchWithTrailer(t, handleDownload, "/api/download?file="+url.QueryEscape(file), nil)
+	if trailer != "" || resp.ContentLength != int64(len(readTestFile(t, file))) {
+		t.Errorf("plain download has trailer %q and length %d", trailer, resp.ContentLength)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClampMtime(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"old.txt": "old", "new.txt": "new"})
+	clamp := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := clamp.Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "clamped.tar.zst")
+	inputs := []string{filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.txt")}
+	if _, err := compressFiles(CompressRequest{Files: inputs, Output: archive, Level: 3, ClampMtime: clamp}); err != nil {
+		t.Fatal(err)
+	}
+
+	mtimes := map[string]time.Time{}
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		mtimes[header.Name] = header.ModTime
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mtimes["new.txt"]; !got.Equal(clamp) {
+		t.Errorf("new.txt has mtime %v, want the clamp %v", got, clamp)
+	}
+	if got := mtimes["old.txt"]; !got.Equal(old) {
+		t.Errorf("old.txt has mtime %v, want its own %v", got, old)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readFileList reads one path per line from r, or NUL-separated paths when
+// null is set, as `find -print0` writes them. Empty names are skipped.
+func readFileList(r io.Reader, null bool) ([]string, error) {
+	delim := byte('\n')
+	if null {
+		delim = 0
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	var files []string
+	for scanner.Scan() {
+		if name := scanner.Text(); name != "" {
+			files = append(files, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %v", err)
+	}
+	return files, nil
+}
+
+// runCompressList compresses the files listed in filesFrom ("-" for stdin)
+// into output, without starting the server. Each file keeps its relative
+// path in the archive, like a glob match does.
+func runCompressList(filesFrom string, null bool, output string, level int) error {
+	list := io.Reader(os.Stdin)
+	if filesFrom != "-" {
+		file, err := os.Open(filesFrom)
+		if err != nil {
+			return fmt.Errorf("failed to open file list: %v", err)
+		}
+		defer file.Close()
+		list = file
+	}
+
+	files, err := readFileList(list, null)
+	if err != nil {
+		return err
+	}
+
+	req := CompressRequest{Output: output, Level: level}
+	for _, file := range files {
+		target := sanitizeTarPath(filepath.ToSlash(filepath.Clean(file)))
+		for strings.HasPrefix(target, "../") {
+			target = strings.TrimPrefix(target, "../")
+		}
+		req.Entries = append(req.Entries, ArchiveEntry{Source: file, Target: target})
+	}
+
+	if _, err := prepareCompressRequest(&req); err != nil {
+		return err
+	}
+
+	stats, err := compressFiles(req)
+	if err != nil {
+		return fmt.Errorf("compression failed: %v", err)
+	}
+
+	fmt.Printf("Wrote %s: %d files, %d -> %d bytes (%.1f%% saved)\n",
+		stats.OutputFile, len(files), stats.OriginalSize, stats.CompressedSize, stats.SpaceSavingPercent)
+	return nil
+}
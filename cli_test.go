@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCompressNulDelimitedList(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	files := map[string]string{"with space.txt": "spaced", "sub/plain.txt": "plain"}
+	if runtime.GOOS != "windows" {
+		files["new\nline.txt"] = "newline"
+	}
+	writeTestFiles(t, dir, files)
+
+	var list strings.Builder
+	for name := range files {
+		list.WriteString(name)
+		list.WriteByte(0)
+	}
+	if err := os.WriteFile("list", []byte(list.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runCompressList("list", true, "listed.tar.zst", 3); err != nil {
+		t.Fatal(err)
+	}
+	entries := archiveNames(t, "listed.tar.zst")
+	if len(entries) != len(files) {
+		t.Errorf("archive holds %d entries, want %d", len(entries), len(files))
+	}
+	for name, body := range files {
+		if entries[name] != body {
+			t.Errorf("%q holds %q, want %q", name, entries[name], body)
+		}
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input string
+		null  bool
+		want  []string
+	}{
+		{"newlines", "a.txt\nwith space.txt\n\nlast", false, []string{"a.txt", "with space.txt", "last"}},
+		{"nul", "a.txt\x00new\nline.txt\x00\x00last\x00", true, []string{"a.txt", "new\nline.txt", "last"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := readFileList(strings.NewReader(test.input), test.null)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(files, test.want) {
+				t.Errorf("read %q, want %q", files, test.want)
+			}
+		})
+	}
+}
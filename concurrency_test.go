@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConcurrencyIsValidated(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "a"})
+
+	// Far more goroutines than CPUs is capped rather than refused
+	resp := postCompress(t, CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: filepath.Join(dir, "a.tar.zst"), Concurrency: 1 << 20})
+	if !resp.Success {
+		t.Errorf("huge concurrency failed: %s", resp.Message)
+	}
+
+	resp = postCompress(t, CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: filepath.Join(dir, "a.tar.zst"), Concurrency: -1})
+	if resp.Success || !strings.Contains(resp.Message, "Invalid concurrency") {
+		t.Errorf("negative concurrency got %v %q", resp.Success, resp.Message)
+	}
+}
+
+func TestConcurrencyRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": strings.Repeat("concurrent ", 100000)})
+	for _, concurrency := range []int{1, 8} {
+		archive := filepath.Join(dir, fmt.Sprintf("c%d.tar.zst", concurrency))
+		req := CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: archive, Level: 3, Concurrency: concurrency}
+		if _, err := compressFiles(req); err != nil {
+			t.Fatal(err)
+		}
+		if got := archiveNames(t, archive)["a.txt"]; got != strings.Repeat("concurrent ", 100000) {
+			t.Errorf("concurrency %d round trip gave %d bytes", concurrency, len(got))
+		}
+	}
+}
+
+func BenchmarkEncoderConcurrency(b *testing.B) {
+	// Large enough to give every encoder goroutine blocks to work on
+	rng := rand.New(rand.NewSource(1))
+	words := strings.Fields("the quick brown fox jumps over lazy dog archive frame level ratio stream")
+	var text strings.Builder
+	for text.Len() < 32<<20 {
+		text.WriteString(words[rng.Intn(len(words))])
+		text.WriteByte(' ')
+	}
+	dir := b.TempDir()
+	writeTestFiles(b, dir, map[string]string{"large.txt": text.String()}, testMtime)
+	input := filepath.Join(dir, "large.txt")
+
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			b.SetBytes(int64(text.Len()))
+			for i := 0; i < b.N; i++ {
+				req := CompressRequest{Files: []string{input}, Output: filepath.Join(dir, "out.zst"), Level: 3, Concurrency: concurrency}
+				if _, err := compressFiles(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
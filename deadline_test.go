@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSlowFiles writes count files which take a while each to compress at
+// a high level
+func writeSlowFiles(t *testing.T, dir string, count int) {
+	t.Helper()
+
+	files := map[string]string{}
+	for i := range count {
+		files[fmt.Sprintf("in/%02d.bin", i)] = slowText(1 << 20)
+	}
+	writeTestFiles(t, dir, files)
+}
+
+func TestDeadlineFinalizesPartialArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeSlowFiles(t, dir, 20)
+	archive := filepath.Join(dir, "partial.tar.zst")
+	req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 19, Deadline: time.Now().Add(100 * time.Millisecond)}
+	stats, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stats.Partial {
+		t.Fatal("archive not flagged partial")
+	}
+	if len(stats.IncludedFiles) == 0 || len(stats.IncludedFiles) >= 20 {
+		t.Fatalf("%d of 20 files included, want some", len(stats.IncludedFiles))
+	}
+
+	// The archive is valid and holds exactly the included files
+	entries := archiveNames(t, archive)
+	delete(entries, "in")
+	if len(entries) != len(stats.IncludedFiles) {
+		t.Errorf("archive holds %d entries, stats list %d", len(entries), len(stats.IncludedFiles))
+	}
+	for _, name := range stats.IncludedFiles {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("included file %s isn't in the archive", name)
+		}
+	}
+}
+
+func TestMaxDurationFinalizesPartialArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeSlowFiles(t, dir, 20)
+	archive := filepath.Join(dir, "partial.tar.zst")
+	req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 19, MaxDuration: 100 * time.Millisecond}
+	stats, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stats.Partial {
+		t.Fatal("archive not flagged partial")
+	}
+	if len(stats.IncludedFiles) == 0 || len(stats.IncludedFiles) >= 20 {
+		t.Fatalf("%d of 20 files included, want some", len(stats.IncludedFiles))
+	}
+	entries := archiveNames(t, archive)
+	delete(entries, "in")
+	if len(entries) != len(stats.IncludedFiles) {
+		t.Errorf("archive holds %d entries, stats list %d", len(entries), len(stats.IncludedFiles))
+	}
+}
+
+func TestMaxDurationSpec(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "a"})
+	req := CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: filepath.Join(dir, "a.tar.zst"), MaxDurationSpec: "90s"}
+	if resp := postCompress(t, req); !resp.Success || resp.Data.Partial {
+		t.Errorf("maxDuration 90s got %v %q, partial %v", resp.Success, resp.Message, resp.Data.Partial)
+	}
+
+	for _, spec := range []string{"soon", "0s", "-1m"} {
+		req.MaxDurationSpec = spec
+		if resp := postCompress(t, req); resp.Success || !strings.Contains(resp.Message, "Invalid maximum duration") {
+			t.Errorf("maxDuration %q got %v %q", spec, resp.Success, resp.Message)
+		}
+	}
+}
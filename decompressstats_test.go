@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecompressionStats(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	files := map[string]string{
+		"in/a.txt":         "alpha",
+		"in/big.txt":       strings.Repeat("expands well ", 20000),
+		"in/sub/b.txt":     strings.Repeat("b", 4096),
+		"in/sub/empty.txt": "",
+	}
+	writeTestFiles(t, dir, files)
+	var inputSize int64
+	for _, body := range files {
+		inputSize += int64(len(body))
+	}
+
+	archive := filepath.Join(dir, "stats.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(DecompressRequest{Archive: archive, OutputDir: "out"})
+	rec := httptest.NewRecorder()
+	handleDecompress(rec, httptest.NewRequest(http.MethodPost, "/api/decompress", bytes.NewReader(body)))
+
+	var resp struct {
+		Success bool
+		Message string
+		Data    struct{ Stats DecompressionStats }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Fatalf("decompress failed: %s", resp.Message)
+	}
+
+	stats := resp.Data.Stats
+	if stats.ExtractedSize != inputSize {
+		t.Errorf("extracted %d bytes, the inputs hold %d", stats.ExtractedSize, inputSize)
+	}
+	if stats.FileCount != len(files) {
+		t.Errorf("extracted %d files, want %d", stats.FileCount, len(files))
+	}
+	if stats.ArchiveSize != info.Size() {
+		t.Errorf("archive size is %d, want %d", stats.ArchiveSize, info.Size())
+	}
+	if want := float64(inputSize) / float64(info.Size()); math.Abs(stats.ExpansionRatio-want) > 0.01 {
+		t.Errorf("expansion ratio is %f, want %f", stats.ExpansionRatio, want)
+	}
+	if duration, err := time.ParseDuration(stats.Duration); err != nil || duration <= 0 {
+		t.Errorf("duration is %q", stats.Duration)
+	}
+	if want := filepath.Join(dir, "out"); stats.OutputDir != want {
+		t.Errorf("output dir is %q, want %q", stats.OutputDir, want)
+	}
+}
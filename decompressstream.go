@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// handleDecompressStream streams the contents of an archive back as a plain
+// tar (format=tar, the default) or a zip (format=zip), written as the
+// archive is decoded, so nothing is extracted to disk first. Entries get the
+// same checks as extraction: unsafe names and symlinks leaving the archive
+// are dropped, as are entry types extraction doesn't write. Unchanged files
+// of an incremental archive live in its base and aren't included.
+func handleDecompressStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "tar"
+	case "tar", "zip":
+	default:
+		sendResponse(w, false, "Invalid stream format", nil)
+		return
+	}
+
+	if _, err := os.Stat(archive); err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to read archive: %v", err), nil)
+		return
+	}
+
+	contentType := "application/x-tar"
+	if format == "zip" {
+		contentType = "application/zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": trimArchiveExt(filepath.Base(archive)) + "." + format,
+	}))
+
+	// Once the first bytes are out, errors can only be logged; the client
+	// sees a truncated stream
+	out := &flushWriter{w: w}
+	if f, ok := w.(http.Flusher); ok {
+		out.flusher = f
+	}
+
+	var err error
+	if format == "zip" {
+		err = streamArchiveZip(archive, out)
+	} else {
+		err = streamArchiveTar(archive, out)
+	}
+	if err != nil {
+		log.Printf("Streaming the contents of %s failed: %v", archive, err)
+	}
+}
+
+// streamEntryName returns the name to stream an entry under, and the
+// target of a symlink, or "" to leave the entry out, applying extraction's
+// rules through guard
+func streamEntryName(guard *entryGuard, header *tar.Header) (string, string) {
+	if header.PAXRecords[baseHashRecord] != "" {
+		return "", ""
+	}
+
+	place, risk := guard.place(header.Name, "")
+	if risk != "" {
+		return "", ""
+	}
+	if _, ok := guard.belowSymlink(place); ok {
+		return "", ""
+	}
+	name := filepath.ToSlash(place.cleanName)
+
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg:
+		return name, ""
+	case tar.TypeSymlink:
+		// Only links that resolve inside the archive are kept
+		target, reason := guard.checkLink(place, header.Linkname)
+		if reason != "" {
+			return "", ""
+		}
+		guard.addLink(place)
+		return name, filepath.ToSlash(target)
+	}
+	return "", ""
+}
+
+// streamArchiveTar writes the archive's entries to w as an uncompressed tar
+func streamArchiveTar(archive string, w io.Writer) error {
+	tarWriter := tar.NewWriter(w)
+	guard := newEntryGuard(scanRoot, nil)
+
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		name, target := streamEntryName(guard, header)
+		if name == "" {
+			return nil
+		}
+
+		copied := *header
+		copied.Name = name
+		copied.Linkname = target
+		if header.Typeflag == tar.TypeDir {
+			copied.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(&copied); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tarWriter, body); err != nil {
+				return fmt.Errorf("failed to stream %s: %v", header.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// streamArchiveZip writes the archive's entries to w as a zip. Symlinks are
+// stored the way zip tools store them: the target as the entry's contents.
+func streamArchiveZip(archive string, w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+	guard := newEntryGuard(scanRoot, nil)
+
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		name, target := streamEntryName(guard, header)
+		if name == "" {
+			return nil
+		}
+
+		zipHeader, err := zip.FileInfoHeader(header.FileInfo())
+		if err != nil {
+			return err
+		}
+		zipHeader.Name = name
+		if header.Typeflag == tar.TypeDir {
+			zipHeader.Name += "/"
+		} else {
+			zipHeader.Method = zip.Deflate
+		}
+
+		writer, err := zipWriter.CreateHeader(zipHeader)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			if _, err := io.Copy(writer, body); err != nil {
+				return fmt.Errorf("failed to stream %s: %v", header.Name, err)
+			}
+		case tar.TypeSymlink:
+			if _, err := io.WriteString(writer, target); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zipWriter.Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecompressStreamFormats(t *testing.T) {
+	big := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "contents.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "docs/"},
+		testEntry{name: "docs/a.txt", body: "alpha"},
+		testEntry{name: "big.bin", body: string(big)},
+		testEntry{name: "link", link: "docs/a.txt"},
+		testEntry{name: "../escape.txt", body: "outside"},
+		testEntry{name: "outward", link: "../../etc/passwd"},
+	)
+
+	// Unsafe names and links leaving the archive are dropped; a symlink's
+	// contents are its target
+	want := map[string]string{
+		"docs/":      "",
+		"docs/a.txt": "alpha",
+		"big.bin":    string(big),
+		"link":       "docs/a.txt",
+	}
+
+	for _, format := range []string{"tar", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleDecompressStream(rec, httptest.NewRequest(http.MethodGet, "/api/decompress-stream?format="+format+"&archive="+url.QueryEscape(archive), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if disposition := rec.Header().Get("Content-Disposition"); !strings.Contains(disposition, "contents."+format) {
+				t.Errorf("Content-Disposition is %q", disposition)
+			}
+
+			got := make(map[string]string)
+			if format == "tar" {
+				reader := tar.NewReader(rec.Body)
+				for {
+					header, err := reader.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					body, err := io.ReadAll(reader)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if header.Typeflag == tar.TypeSymlink {
+						body = []byte(header.Linkname)
+					}
+					got[header.Name] = string(body)
+				}
+			} else {
+				reader, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, file := range reader.File {
+					body, err := file.Open()
+					if err != nil {
+						t.Fatal(err)
+					}
+					contents, err := io.ReadAll(body)
+					body.Close()
+					if err != nil {
+						t.Fatal(err)
+					}
+					got[file.Name] = string(contents)
+				}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				names := make([]string, 0, len(got))
+				for name := range got {
+					names = append(names, name)
+				}
+				t.Errorf("streamed %v, want %d entries", names, len(want))
+			}
+		})
+	}
+}
+
+func TestDecompressStreamRejectsBadRequests(t *testing.T) {
+	for query, want := range map[string]string{
+		"archive=missing.tar.zst":            "Failed to read archive",
+		"":                                   "No archive file specified",
+		"archive=missing.tar.zst&format=rar": "Invalid stream format",
+	} {
+		rec := httptest.NewRecorder()
+		handleDecompressStream(rec, httptest.NewRequest(http.MethodGet, "/api/decompress-stream?"+query, nil))
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%q got %s, want %q", query, rec.Body.String(), want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// dedupeMaxSize is the largest file Dedupe stores by content
+	dedupeMaxSize = 1 << 20
+
+	// dedupeDir holds the unique contents of a deduplicated archive, each
+	// stored once under its SHA-256 and marked with dedupeContentRecord
+	dedupeDir           = ".dedupe/"
+	dedupeContentRecord = provenancePrefix + "dedupe-content"
+
+	// dedupeRecord marks an entry stored empty, whose contents are those of
+	// the content entry with this SHA-256
+	dedupeRecord = provenancePrefix + "dedupe-sha256"
+)
+
+// writeDeduped writes a small file as a reference to its contents, storing
+// the contents first if no earlier file had them, and reports whether it
+// did. Files that can't be read whole are left to be stored normally, which
+// reports the error.
+func (b *tarBuilder) writeDeduped(entry tarEntry) (bool, error) {
+	header := entry.header
+	if !entry.local || header.Size == 0 || header.Size > dedupeMaxSize {
+		return false, nil
+	}
+
+	started := time.Now()
+	data, err := os.ReadFile(entry.path)
+	if err != nil || int64(len(data)) != header.Size {
+		return false, nil
+	}
+	if b.readLimiter != nil {
+		if err := b.readLimiter.wait(len(data)); err != nil {
+			return true, err
+		}
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if b.dedupeStored == nil {
+		b.dedupeStored = make(map[string]bool)
+	}
+	if !b.dedupeStored[hash] {
+		content := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       dedupeDir + hash,
+			Size:       header.Size,
+			Mode:       0644,
+			ModTime:    header.ModTime,
+			Format:     b.format,
+			PAXRecords: map[string]string{dedupeContentRecord: hash},
+		}
+		if err := b.tarWriter.WriteHeader(content); err != nil {
+			return true, err
+		}
+		if _, err := b.tarWriter.Write(data); err != nil {
+			return true, err
+		}
+		b.dedupeStored[hash] = true
+	} else {
+		b.dedupedFiles++
+	}
+
+	reference := *header
+	reference.Size = 0
+	reference.PAXRecords = map[string]string{dedupeRecord: hash}
+	for key, value := range header.PAXRecords {
+		reference.PAXRecords[key] = value
+	}
+	if err := b.tarWriter.WriteHeader(&reference); err != nil {
+		return true, err
+	}
+
+	// Progress still counts the file's bytes, as if it had been stored
+	if _, err := io.Copy(io.Discard, b.progress.track(header.Name, bytes.NewReader(data))); err != nil {
+		return true, err
+	}
+	b.recordFile(header.Name, header.Size, started)
+	return true, nil
+}
+
+// dedupeStore resolves the references of a deduplicated archive while it's
+// read. Contents are kept in a temp directory, created on the first one, as
+// they must outlive their entry to serve the references after it.
+type dedupeStore struct {
+	dir string
+}
+
+// resolve returns the entry to hand on for header: the entry itself, a
+// reference turned back into the file it stands for, or nil for a content
+// entry, which is kept for the references that follow
+func (s *dedupeStore) resolve(header *tar.Header, body io.Reader) (*tar.Header, io.Reader, error) {
+	if hash := header.PAXRecords[dedupeContentRecord]; hash != "" {
+		if !isSHA256Hex(hash) {
+			return nil, nil, fmt.Errorf("invalid content entry %s", header.Name)
+		}
+		if s.dir == "" {
+			dir, err := os.MkdirTemp("", "zstd_dedupe")
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to create the content store: %v", err)
+			}
+			s.dir = dir
+		}
+
+		file, err := os.Create(filepath.Join(s.dir, hash))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to store %s: %v", header.Name, err)
+		}
+		_, err = io.Copy(file, io.LimitReader(body, dedupeMaxSize))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to store %s: %v", header.Name, err)
+		}
+		return nil, nil, nil
+	}
+
+	hash := header.PAXRecords[dedupeRecord]
+	if hash == "" || header.Typeflag != tar.TypeReg {
+		return header, body, nil
+	}
+
+	if !isSHA256Hex(hash) || s.dir == "" {
+		return nil, nil, fmt.Errorf("contents of %s are missing from the archive", header.Name)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, hash))
+	if err != nil {
+		return nil, nil, fmt.Errorf("contents of %s are missing from the archive", header.Name)
+	}
+
+	resolved := *header
+	resolved.Size = int64(len(data))
+	resolved.PAXRecords = make(map[string]string, len(header.PAXRecords))
+	for key, value := range header.PAXRecords {
+		if key != dedupeRecord {
+			resolved.PAXRecords[key] = value
+		}
+	}
+	return &resolved, bytes.NewReader(data), nil
+}
+
+// remove deletes the stored contents
+func (s *dedupeStore) remove() {
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// isSHA256Hex reports whether s is a hex SHA-256, safe to use as a file name
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDedupeStoresUniqueContentsOnce(t *testing.T) {
+	dir := t.TempDir()
+	contents := []string{"shared license text", strings.Repeat("cache blob ", 100), ""}
+	files := make(map[string]string)
+	for i := range 300 {
+		files[fmt.Sprintf("in/pkg%02d/file%02d.txt", i/10, i%10)] = contents[i%len(contents)]
+	}
+	files["in/unique.txt"] = "only one of these"
+	writeTestFiles(t, dir, files)
+
+	archive := filepath.Join(dir, "deduped.tar.zst")
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3, Dedupe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Empty files have nothing to store
+	if stats.UniqueContents != 3 {
+		t.Errorf("stored %d unique contents, want 3", stats.UniqueContents)
+	}
+	if stats.DedupedFiles != 198 {
+		t.Errorf("deduplicated %d files, want 198", stats.DedupedFiles)
+	}
+
+	// Readers of the archive see through the references, so count the
+	// contents in the raw tar
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	stored := 0
+	tarReader := tar.NewReader(decoder)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(header.Name, ".dedupe/") {
+			stored++
+		}
+	}
+	if stored != stats.UniqueContents {
+		t.Errorf("archive holds %d contents, stats say %d", stored, stats.UniqueContents)
+	}
+
+	// Extraction writes every path back, and clears its content store away
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	out := filepath.Join(dir, "out")
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FileCount != len(files) {
+		t.Errorf("extracted %d files, want %d", result.FileCount, len(files))
+	}
+	for name, body := range files {
+		if got := readTestFile(t, filepath.Join(out, name)); got != body {
+			t.Errorf("%s holds %q, want %q", name, got, body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, ".dedupe")); !os.IsNotExist(err) {
+		t.Errorf("content store extracted as files: %v", err)
+	}
+	assertEmptyDir(t, tmp)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compressWithDefault posts req to /api/compress with the given default
+// format
+func compressWithDefault(t *testing.T, format string, req CompressRequest) compressResponse {
+	t.Helper()
+
+	old := defaultFormat
+	defaultFormat = format
+	defer func() { defaultFormat = old }()
+	return postCompress(t, req)
+}
+
+func TestDefaultFormatFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": strings.Repeat("a", 100)})
+
+	resp := compressWithDefault(t, "gzip", CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "in")})
+	if !resp.Success {
+		t.Fatalf("compression failed: %s", resp.Message)
+	}
+	stats := resp.Data
+	if !strings.HasSuffix(stats.OutputFile, ".tar.gz") {
+		t.Errorf("archive named %s, want a .tar.gz", stats.OutputFile)
+	}
+	if archive := readTestFile(t, stats.OutputFile); !strings.HasPrefix(archive, string(gzipMagic)) {
+		t.Error("archive isn't gzip")
+	}
+}
+
+func TestUnsupportedFormatRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+
+	resp := compressWithDefault(t, "zstd", CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "in"), Format: "rar"})
+	if resp.Success || !strings.Contains(resp.Message, "Unsupported format") {
+		t.Errorf("a rar request got %v %q", resp.Success, resp.Message)
+	}
+}
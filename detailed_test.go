@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetailedReportUnderSkipExisting(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "report.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "docs/"},
+		testEntry{name: "docs/old.txt", body: "from the archive"},
+		testEntry{name: "docs/new.txt", body: "brand new"},
+	)
+	output := filepath.Join(dir, "out")
+	writeTestFiles(t, output, map[string]string{"docs/old.txt": "already here"})
+
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, ExistingFiles: "skip", Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := map[string]ExtractedEntry{}
+	for _, entry := range result.Entries {
+		report[entry.Name] = entry
+	}
+	if len(report) != 3 {
+		t.Fatalf("report has %d entries, want 3: %+v", len(report), result.Entries)
+	}
+	if entry := report["docs/"]; entry.Action != "existing" {
+		t.Errorf("docs/ reported as %q, want existing", entry.Action)
+	}
+	if entry := report["docs/old.txt"]; entry.Action != "skipped" || entry.Reason == "" {
+		t.Errorf("docs/old.txt reported as %q (%q), want skipped with a reason", entry.Action, entry.Reason)
+	}
+	newEntry := report["docs/new.txt"]
+	if newEntry.Action != "created" || newEntry.Size != int64(len("brand new")) || newEntry.Path != filepath.Join(output, "docs", "new.txt") {
+		t.Errorf("docs/new.txt reported as %+v", newEntry)
+	}
+	if newEntry.Mode != os.FileMode(0644).String() {
+		t.Errorf("docs/new.txt has mode %s", newEntry.Mode)
+	}
+
+	// The report matches what happened on disk
+	if got := readTestFile(t, filepath.Join(output, "docs", "old.txt")); got != "already here" {
+		t.Errorf("skipped file was overwritten with %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(output, "docs", "new.txt")); got != "brand new" {
+		t.Errorf("created file holds %q", got)
+	}
+}
+
+func TestDetailedReportUnderOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "report.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "old.txt", body: "from the archive"})
+	output := filepath.Join(dir, "out")
+	writeTestFiles(t, output, map[string]string{"old.txt": "already here"})
+
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, ExistingFiles: "overwrite", Detailed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Entries) != 1 || result.Entries[0].Action != "overwritten" {
+		t.Errorf("report is %+v, want old.txt overwritten", result.Entries)
+	}
+	if got := readTestFile(t, filepath.Join(output, "old.txt")); got != "from the archive" {
+		t.Errorf("old.txt holds %q", got)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	dictDefaultSize   = 112640    // 110 KB, the zstd tool's default
+	dictMaxSampleSize = 128 << 10 // bytes read from each sample file
+	dictMaxSamples    = 10000
+	dictMaxTotalSize  = 64 << 20 // bytes read from all samples together
+)
+
+// dictExt names trained dictionaries
+const dictExt = ".dict"
+
+type TrainDictRequest struct {
+	// Files are sample files, or directories whose files are all samples
+	Files  []string `json:"files"`
+	Output string   `json:"output"`
+
+	// MaxSize caps the dictionary's size, 110 KB by default
+	MaxSize int `json:"maxSize"`
+
+	// Level tunes the dictionary for this compression level, 3 by default
+	Level int `json:"level"`
+}
+
+// DictionaryInfo describes a trained dictionary
+type DictionaryInfo struct {
+	Dictionary string `json:"dictionary"`
+	ID         uint32 `json:"id"`
+	Size       int    `json:"size"`
+	Samples    int    `json:"samples"`
+}
+
+// handleTrainDict trains a zstd dictionary on sample files. Archives of many
+// small, similar files compress much better with one, see
+// CompressRequest.Dictionary.
+func handleTrainDict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TrainDictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, false, "Invalid request format", nil)
+		return
+	}
+
+	if len(req.Files) == 0 {
+		sendResponse(w, false, "No sample files selected", nil)
+		return
+	}
+	if req.MaxSize <= 0 {
+		req.MaxSize = dictDefaultSize
+	}
+	if req.Level < 1 || req.Level > maxLevel {
+		req.Level = 3
+	}
+	if req.Output == "" {
+		req.Output = "dictionary"
+	}
+	if !strings.HasSuffix(req.Output, dictExt) {
+		req.Output += dictExt
+	}
+
+	info, err := trainDictionary(req)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Training failed: %v", err), nil)
+		return
+	}
+
+	sendResponse(w, true, fmt.Sprintf("Dictionary %d trained on %d samples", info.ID, info.Samples), info)
+}
+
+// trainDictionary reads samples from the request's files, at most
+// dictMaxSampleSize bytes of each, and writes the trained dictionary
+func trainDictionary(req TrainDictRequest) (*DictionaryInfo, error) {
+	var samples [][]byte
+	var total int
+
+	for _, input := range req.Files {
+		err := filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+			if len(samples) >= dictMaxSamples || total >= dictMaxTotalSize {
+				return filepath.SkipAll
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			sample, err := io.ReadAll(io.LimitReader(file, dictMaxSampleSize))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", path, err)
+			}
+			samples = append(samples, sample)
+			total += len(sample)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples found")
+	}
+
+	dictionary, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: req.MaxSize,
+		HashBytes:   6,
+		ZstdLevel:   zstd.EncoderLevelFromZstd(req.Level),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := dictionaryID(dictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(req.Output, dictionary, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write dictionary: %v", err)
+	}
+
+	return &DictionaryInfo{Dictionary: req.Output, ID: id, Size: len(dictionary), Samples: len(samples)}, nil
+}
+
+// loadDictionary reads and checks a zstd dictionary file
+func loadDictionary(path string) ([]byte, error) {
+	dictionary, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dictionary: %v", err)
+	}
+	if _, err := dictionaryID(dictionary); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return dictionary, nil
+}
+
+// dictionaryID checks that data is a zstd dictionary and returns its ID
+func dictionaryID(data []byte) (uint32, error) {
+	inspected, err := zstd.InspectDictionary(data)
+	if err != nil {
+		return 0, fmt.Errorf("invalid zstd dictionary: %v", err)
+	}
+	return inspected.ID(), nil
+}
+
+// frameDictionaryID returns the dictionary ID recorded in the header of the
+// zstd frame that starts data, 0 for a frame compressed without one
+func frameDictionaryID(data []byte) uint32 {
+	var header zstd.Header
+	if err := header.Decode(data); err != nil {
+		return 0
+	}
+	return header.DictionaryID
+}
+
+func hasDictionary(dictionaries [][]byte, id uint32) bool {
+	for _, dictionary := range dictionaries {
+		if dictID, err := dictionaryID(dictionary); err == nil && dictID == id {
+			return true
+		}
+	}
+	return false
+}
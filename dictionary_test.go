@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeLogRecords writes count small JSON files that share their structure
+// but not their values, like a directory of API responses
+func writeLogRecords(t *testing.T, dir string, first, count int) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	for i := first; i < first+count; i++ {
+		files[fmt.Sprintf("record-%04d.json", i)] = fmt.Sprintf(
+			`{"id":%d,"service":"checkout-%d","level":"info","message":"request completed","status":%d,"durationMs":%d,"user":{"id":"u%05d","region":"eu-west-%d"}}`,
+			i, i%7, 200+i%3, i*37%1000, i*7919%100000, i%3)
+	}
+	writeTestFiles(t, dir, files)
+	return files
+}
+
+func TestDictionaryImprovesRatio(t *testing.T) {
+	dir := t.TempDir()
+	writeLogRecords(t, filepath.Join(dir, "samples"), 0, 2000)
+	records := writeLogRecords(t, filepath.Join(dir, "batch"), 5000, 20)
+
+	info, err := trainDictionary(TrainDictRequest{
+		Files:   []string{filepath.Join(dir, "samples")},
+		Output:  filepath.Join(dir, "records.dict"),
+		MaxSize: dictDefaultSize,
+		Level:   3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Samples != 2000 || info.ID == 0 {
+		t.Errorf("trained %+v", info)
+	}
+
+	compress := func(name, dictionary string) int64 {
+		stats, err := compressFiles(CompressRequest{
+			Files:      []string{filepath.Join(dir, "batch")},
+			Output:     filepath.Join(dir, name),
+			Level:      3,
+			Dictionary: dictionary,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats.CompressedSize
+	}
+	plain := compress("plain.tar.zst", "")
+	withDict := compress("dict.tar.zst", info.Dictionary)
+	if withDict >= plain {
+		t.Errorf("dictionary archive is %d bytes, %d without", withDict, plain)
+	}
+	t.Logf("%d bytes without the dictionary, %d with", plain, withDict)
+
+	// The archive names the dictionary it needs
+	archive := filepath.Join(dir, "dict.tar.zst")
+	_, err = decompressFile(DecompressRequest{Archive: archive, OutputDir: filepath.Join(dir, "nodict")})
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprint(info.ID)) {
+		t.Errorf("extracting without the dictionary got %v", err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: out, Dictionaries: []string{info.Dictionary}}); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for name := range records {
+		got[name] = readTestFile(t, filepath.Join(out, "batch", name))
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Error("extracted records differ from the originals")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompressDirectoryField(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"project/a.txt": "a", "project/src/b.go": "b"})
+	source := filepath.Join(dir, "project")
+
+	tests := []struct {
+		name string
+		req  CompressRequest
+		want map[string]string
+	}{
+		{"base name", CompressRequest{Directory: source},
+			map[string]string{"project": "", "project/a.txt": "a", "project/src": "", "project/src/b.go": "b"}},
+		{"root name", CompressRequest{Directory: source, RootName: "renamed"},
+			map[string]string{"renamed": "", "renamed/a.txt": "a", "renamed/src": "", "renamed/src/b.go": "b"}},
+		{"flattened", CompressRequest{Directory: source, FlattenRoot: true},
+			map[string]string{"a.txt": "a", "src": "", "src/b.go": "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := test.req
+			req.Output, req.Level = filepath.Join(dir, filepath.Base(t.Name())+".tar.zst"), 3
+			if _, err := compressFiles(req); err != nil {
+				t.Fatal(err)
+			}
+			if got := archiveNames(t, req.Output); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("archive holds %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompressDirectoryFieldNeedsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"file.txt": "x"})
+
+	_, err := compressFiles(CompressRequest{Directory: filepath.Join(dir, "file.txt"), Output: filepath.Join(dir, "out.tar.zst"), Level: 3})
+	if err == nil {
+		t.Error("a file was accepted as the directory")
+	}
+}
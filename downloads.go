@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// downloadZipTTL is how long a zip of an extracted directory is kept after
+// it was last requested, so interrupted downloads can resume
+const downloadZipTTL = 30 * time.Minute
+
+type cachedZip struct {
+	path      string
+	expiresAt time.Time
+
+	// ready is closed once the zip is built, or has failed to with err
+	ready chan struct{}
+	err   error
+}
+
+var (
+	downloadZipsMu sync.Mutex
+	downloadZips   = make(map[string]*cachedZip) // keyed by directory state
+)
+
+// directoryStateKey hashes dir's path with the names, sizes and modification
+// times of everything in it, so any change to the tree changes the key.
+// Deterministic zips of the same state get a key of their own.
+func directoryStateKey(dir string, deterministic bool) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n", absDir)
+	if deterministic {
+		fmt.Fprintf(hash, "deterministic\n")
+	}
+
+	err = filepath.Walk(absDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%s\n", path, info.Size(), info.ModTime().UnixNano(), info.Mode())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cachedDirectoryZip returns a zip of dir and the key of the directory state
+// it was built from. The zip is built once per state and kept until it
+// expires, so range requests resuming a download read the same bytes.
+// Requests for a state whose zip is being built wait for it; other
+// directories aren't held up meanwhile.
+func cachedDirectoryZip(dir string, deterministic bool) (string, string, error) {
+	key, err := directoryStateKey(dir, deterministic)
+	if err != nil {
+		return "", "", err
+	}
+
+	for {
+		downloadZipsMu.Lock()
+		cached, ok := downloadZips[key]
+		if !ok {
+			cached = &cachedZip{expiresAt: time.Now().Add(downloadZipTTL), ready: make(chan struct{})}
+			downloadZips[key] = cached
+			downloadZipsMu.Unlock()
+
+			buildDirectoryZip(cached, key, dir, deterministic)
+			if cached.err != nil {
+				return "", "", cached.err
+			}
+			return cached.path, key, nil
+		}
+		cached.expiresAt = time.Now().Add(downloadZipTTL)
+		downloadZipsMu.Unlock()
+
+		<-cached.ready
+		if cached.err != nil {
+			return "", "", cached.err
+		}
+		if _, err := os.Stat(cached.path); err == nil {
+			return cached.path, key, nil
+		}
+
+		// The zip went missing, so drop it and build another
+		downloadZipsMu.Lock()
+		if downloadZips[key] == cached {
+			delete(downloadZips, key)
+		}
+		downloadZipsMu.Unlock()
+	}
+}
+
+// buildDirectoryZip zips dir for cached, then marks it ready. A zip that
+// failed is dropped from the cache, so the next request tries again.
+func buildDirectoryZip(cached *cachedZip, key, dir string, deterministic bool) {
+	defer close(cached.ready)
+
+	file, err := os.CreateTemp("", "zstd_download_*.zip")
+	if err == nil {
+		cached.path = file.Name()
+		file.Close()
+		if err = zipDirectory(dir, cached.path, deterministic); err != nil {
+			os.Remove(cached.path)
+		}
+	}
+	if err != nil {
+		cached.err = err
+		downloadZipsMu.Lock()
+		delete(downloadZips, key)
+		downloadZipsMu.Unlock()
+	}
+}
+
+func cleanupExpiredDownloads(now time.Time) {
+	downloadZipsMu.Lock()
+	var expired []string
+	for key, cached := range downloadZips {
+		select {
+		case <-cached.ready:
+		default:
+			continue // still being built
+		}
+		if now.After(cached.expiresAt) {
+			expired = append(expired, cached.path)
+			delete(downloadZips, key)
+		}
+	}
+	downloadZipsMu.Unlock()
+
+	for _, path := range expired {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove download zip %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func download(path, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleDownload(rec, httptest.NewRequest(http.MethodGet, "/api/download?file="+url.QueryEscape(path)+query, nil))
+	return rec
+}
+
+func TestDownloadDisposition(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"page.html": "<p>hi</p>", "notes": "plain text notes"})
+	page := filepath.Join(dir, "page.html")
+
+	// Attachment by default, typed by extension
+	rec := download(page, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename=page.html` {
+		t.Errorf("default disposition is %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("page.html is served as %q", got)
+	}
+
+	// Inline for previews, sandboxed so the page can't run scripts
+	rec = download(page, "&disposition=inline")
+	if got := rec.Header().Get("Content-Disposition"); got != `inline; filename=page.html` {
+		t.Errorf("inline disposition is %q", got)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("inline download has CSP %q", got)
+	}
+
+	// Without an extension the type is sniffed from the contents
+	rec = download(filepath.Join(dir, "notes"), "&disposition=inline")
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("notes is served as %q", got)
+	}
+
+	if rec = download(page, "&disposition=bogus"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid disposition got status %d", rec.Code)
+	}
+}
+
+func TestDeterministicZip(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "tree")
+	writeTestFiles(t, source, map[string]string{"b.txt": strings.Repeat("bravo ", 100), "a/c.txt": "charlie"})
+
+	zipTwice := func(deterministic bool) (string, string) {
+		t.Helper()
+		first, second := filepath.Join(dir, "first.zip"), filepath.Join(dir, "second.zip")
+		if err := zipDirectory(source, first, deterministic); err != nil {
+			t.Fatal(err)
+		}
+		// Touch a file in between, as re-extracting the archive would
+		info, err := os.Stat(filepath.Join(source, "b.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		later := info.ModTime().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(source, "b.txt"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		if err := zipDirectory(source, second, deterministic); err != nil {
+			t.Fatal(err)
+		}
+		return readTestFile(t, first), readTestFile(t, second)
+	}
+
+	if first, second := zipTwice(true); first != second {
+		t.Error("deterministic zips of the same tree differ")
+	}
+	if first, second := zipTwice(false); first == second {
+		t.Error("regular zips are identical despite new mtimes, the test proves nothing")
+	}
+
+	reader, err := zip.OpenReader(filepath.Join(dir, "first.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	if want := "tree/,tree/a/,tree/a/c.txt,tree/b.txt"; strings.Join(names, ",") != want {
+		t.Errorf("zip entries are %v, want %s", names, want)
+	}
+}
+
+func TestZipDirectoryStoresSymlinks(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "tree")
+	writeTestFiles(t, source, map[string]string{"sub/file.txt": "contents"})
+	if err := os.Symlink("sub/file.txt", filepath.Join(source, "file-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(source, "dir-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "tree.zip")
+	if err := zipDirectory(source, target, false); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := zip.OpenReader(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	links := map[string]string{}
+	for _, file := range reader.File {
+		if file.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		body, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[file.Name] = string(data)
+	}
+
+	want := map[string]string{"tree/file-link": "sub/file.txt", "tree/dir-link": "sub"}
+	for name, target := range want {
+		if links[name] != target {
+			t.Errorf("%s stored as %q, want a link to %q", name, links[name], target)
+		}
+	}
+}
+
+func downloadExtracted(t *testing.T, dir, rangeHeader, ifRange string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/download-extracted?dir="+url.QueryEscape(dir), nil)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	rec := httptest.NewRecorder()
+	handleDownloadExtracted(rec, req)
+	return rec
+}
+
+func TestDownloadExtractedResumes(t *testing.T) {
+	defer cleanupExpiredDownloads(time.Now().Add(downloadZipTTL + time.Second))
+
+	dir := filepath.Join(t.TempDir(), "extracted")
+	writeTestFiles(t, dir, map[string]string{
+		"a.txt":     strings.Repeat("first file ", 500),
+		"sub/b.txt": strings.Repeat("second file ", 500),
+	})
+
+	full := downloadExtracted(t, dir, "", "")
+	if full.Code != http.StatusOK {
+		t.Fatalf("status %d", full.Code)
+	}
+	etag := full.Header().Get("ETag")
+
+	// The first part, then the rest as a resumed download would ask for it
+	part := downloadExtracted(t, dir, "bytes=0-99", etag)
+	rest := downloadExtracted(t, dir, "bytes=100-", etag)
+	if part.Code != http.StatusPartialContent || rest.Code != http.StatusPartialContent {
+		t.Fatalf("range requests got %d and %d", part.Code, rest.Code)
+	}
+	if got := part.Body.String() + rest.Body.String(); got != full.Body.String() {
+		t.Error("resumed download doesn't match the full download")
+	}
+}
+
+// deterministic=1 gives the same bytes for a re-extracted tree; without it
+// the zip keeps the files' modification times
+func TestDownloadExtractedDeterministic(t *testing.T) {
+	defer cleanupExpiredDownloads(time.Now().Add(downloadZipTTL + time.Second))
+
+	dir := filepath.Join(t.TempDir(), "extracted")
+	writeTestFiles(t, dir, map[string]string{"a.txt": strings.Repeat("first file ", 500)})
+	download := func(query string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleDownloadExtracted(rec, httptest.NewRequest(http.MethodGet, "/api/download-extracted?dir="+url.QueryEscape(dir)+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	first := download("&deterministic=1")
+	later := time.Now().Add(time.Hour).Truncate(2 * time.Second)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if second := download("&deterministic=1"); second != first {
+		t.Error("deterministic downloads of the re-extracted tree differ")
+	}
+
+	regular := download("")
+	reader, err := zip.NewReader(strings.NewReader(regular), int64(len(regular)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := reader.Open("extracted/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(later) {
+		t.Errorf("regular download stamped a.txt with %v, want %v", info.ModTime(), later)
+	}
+}
+
+func TestDownloadExtractedBuildsOnce(t *testing.T) {
+	defer cleanupExpiredDownloads(time.Now().Add(downloadZipTTL + time.Second))
+
+	dir := filepath.Join(t.TempDir(), "extracted")
+	writeTestFiles(t, dir, map[string]string{"a.txt": "contents"})
+
+	paths := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path, _, err := cachedDirectoryZip(dir, false)
+			if err != nil {
+				t.Error(err)
+			}
+			paths[i] = path
+		}()
+	}
+	wg.Wait()
+
+	for _, path := range paths[1:] {
+		if path != paths[0] {
+			t.Fatalf("concurrent requests got zips %s and %s", paths[0], path)
+		}
+	}
+}
+
+func TestDownloadExtractedQuotesName(t *testing.T) {
+	defer cleanupExpiredDownloads(time.Now().Add(downloadZipTTL + time.Second))
+
+	dir := filepath.Join(t.TempDir(), `my "files"; x`)
+	writeTestFiles(t, dir, map[string]string{"a.txt": "contents"})
+
+	rec := downloadExtracted(t, dir, "", "")
+	want := `attachment; filename="my \"files\"; x.zip"`
+	if got := rec.Header().Get("Content-Disposition"); got != want {
+		t.Errorf("Content-Disposition is %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDryRunListsEntriesWithoutWriting(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	writeTestFiles(t, input, map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo!"})
+	output := filepath.Join(dir, "dry.tar.zst")
+	req := CompressRequest{Files: []string{input}, Output: output, DryRun: true}
+
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	handleCompress(rec, httptest.NewRequest(http.MethodPost, "/api/compress", bytes.NewReader(body)))
+
+	var resp struct {
+		Success bool
+		Message string
+		Data    []DryRunEntry
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Fatalf("dry run failed: %s", resp.Message)
+	}
+	if !strings.Contains(resp.Message, "4 entries, 11 bytes") {
+		t.Errorf("message is %q, want the entry count and total size", resp.Message)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s: %v", output, err)
+	}
+
+	manifest := map[string]DryRunEntry{}
+	var listed []string
+	for _, entry := range resp.Data {
+		manifest[entry.Path] = entry
+		listed = append(listed, entry.Path)
+	}
+	if entry := manifest["in/sub/b.txt"]; entry.Size != 6 || entry.IsDir {
+		t.Errorf("in/sub/b.txt listed as %+v", entry)
+	}
+	if entry := manifest["in/sub"]; !entry.IsDir {
+		t.Errorf("in/sub listed as %+v", entry)
+	}
+
+	// The dry run lists what the compression then archives
+	req.DryRun = false
+	if _, err := prepareCompressRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+	var archived []string
+	for name := range archiveNames(t, output) {
+		archived = append(archived, name)
+	}
+	slices.Sort(listed)
+	slices.Sort(archived)
+	if !slices.Equal(listed, archived) {
+		t.Errorf("dry run listed %v, compression archived %v", listed, archived)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnDuplicatePolicies(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"x/a.txt": "x", "y/a.txt": "y", "z/a.txt": "z", "w/.env": "w", "v/.env": "v"})
+	files := []string{
+		filepath.Join(dir, "x", "a.txt"), filepath.Join(dir, "y", "a.txt"), filepath.Join(dir, "z", "a.txt"),
+		filepath.Join(dir, "w", ".env"), filepath.Join(dir, "v", ".env"),
+	}
+
+	tests := []struct {
+		policy   string
+		entries  []string // name=contents, in archive order
+		warnings int
+		err      string
+	}{
+		{"", []string{"a.txt=x", "a.txt=y", "a.txt=z", ".env=w", ".env=v"}, 3, ""},
+		{"overwrite", []string{"a.txt=x", "a.txt=y", "a.txt=z", ".env=w", ".env=v"}, 3, ""},
+		{"rename", []string{"a.txt=x", "a-1.txt=y", "a-2.txt=z", ".env=w", ".env-1=v"}, 3, ""},
+		{"error", nil, 0, "duplicate entry name a.txt"},
+	}
+	for _, test := range tests {
+		t.Run("policy "+test.policy, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "dups.tar.zst")
+			stats, err := compressFiles(CompressRequest{Files: files, Output: archive, Level: 3, OnDuplicate: test.policy})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stats.Warnings) != test.warnings {
+				t.Errorf("warnings are %v, want %d", stats.Warnings, test.warnings)
+			}
+
+			var entries []string
+			walkTestArchive(t, archive, func(header *tar.Header, body io.Reader) {
+				data, _ := io.ReadAll(body)
+				entries = append(entries, header.Name+"="+string(data))
+			})
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Errorf("archive holds %v, want %v", entries, test.entries)
+			}
+		})
+	}
+}
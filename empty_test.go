@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmptyFileAndDirectoryRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"input/empty": "", "empty": ""})
+	for _, name := range []string{"input/emptydir", "emptydir"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Empty inputs given directly and inside a directory, side by side
+	inputs := []string{filepath.Join(dir, "input"), filepath.Join(dir, "empty"), filepath.Join(dir, "emptydir")}
+	archive := filepath.Join(dir, "empty.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: inputs, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	types := map[string]byte{}
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeReg && header.Size != 0 {
+			t.Errorf("%s has size %d", header.Name, header.Size)
+		}
+		types[header.Name] = header.Typeflag
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]byte{"input/empty": tar.TypeReg, "empty": tar.TypeReg, "input/emptydir": tar.TypeDir, "emptydir": tar.TypeDir} {
+		if got, ok := types[name]; !ok || got != want {
+			t.Errorf("%s has type %q, want %q", name, got, want)
+		}
+	}
+
+	output := filepath.Join(dir, "output")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"input/empty", "empty"} {
+		if info, err := os.Lstat(filepath.Join(output, name)); err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+			t.Errorf("%s isn't restored as an empty file: %v", name, err)
+		}
+	}
+	for _, name := range []string{"input/emptydir", "emptydir"} {
+		entries, err := os.ReadDir(filepath.Join(output, name))
+		if err != nil || len(entries) != 0 {
+			t.Errorf("%s isn't restored as an empty directory: %v", name, err)
+		}
+	}
+
+	// An empty file without an extension gets a sensible default name
+	req := CompressRequest{Files: []string{filepath.Join(dir, "empty")}, Level: 3}
+	if _, err := prepareCompressRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Output != "empty.zst" {
+		t.Errorf("default output is %s, want empty.zst", req.Output)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestPooledEncoderMatchesFreshEncoder(t *testing.T) {
+	data := []byte(strings.Repeat("pooled or not ", 10000))
+	level := zstd.EncoderLevelFromZstd(3)
+
+	var fresh bytes.Buffer
+	encoder, err := zstd.NewWriter(&fresh, zstd.WithEncoderLevel(level))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder.Write(data)
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 {
+		var pooled bytes.Buffer
+		encoder, err := getEncoder(&pooled, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoder.Write(data)
+		if err := encoder.Close(); err != nil {
+			t.Fatal(err)
+		}
+		putEncoder(encoder, level)
+		if !bytes.Equal(pooled.Bytes(), fresh.Bytes()) {
+			t.Errorf("pooled encoder %d output differs from a fresh encoder's", i)
+		}
+	}
+}
+
+func TestRepeatedCompressionsMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in.txt": strings.Repeat("pooled or not ", 10000)}, testMtime)
+	input := filepath.Join(dir, "in.txt")
+
+	var first string
+	for i := range 3 {
+		output := filepath.Join(dir, fmt.Sprintf("out%d.zst", i))
+		if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: output, Level: 3}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = readTestFile(t, output)
+		} else if readTestFile(t, output) != first {
+			t.Errorf("compression %d with a pooled encoder differs from the first", i)
+		}
+	}
+}
+
+// BenchmarkSmallCompressions compresses small inputs with pooled encoders
+// and with a fresh encoder each time
+func BenchmarkSmallCompressions(b *testing.B) {
+	data := []byte(strings.Repeat("small ", 200))
+	level := zstd.EncoderLevelFromZstd(3)
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encoder, err := getEncoder(io.Discard, level)
+			if err != nil {
+				b.Fatal(err)
+			}
+			encoder.Write(data)
+			putEncoder(encoder, level)
+		}
+	})
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			encoder, err := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(level))
+			if err != nil {
+				b.Fatal(err)
+			}
+			encoder.Write(data)
+			encoder.Close()
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// ageMagic starts the header of every age-encrypted file
+var ageMagic = []byte("age-encryption.org/")
+
+// ageExt is added to the name of an encrypted archive
+const ageExt = ".age"
+
+// passwordHeader carries the archive password for clients that keep secrets
+// out of request bodies, which proxies and clients are more likely to log
+const passwordHeader = "X-Archive-Password"
+
+// passwordWorkFactor is the scrypt work factor (log2 of N) that derives the
+// key of a password-encrypted archive. Tests lower it.
+var passwordWorkFactor = 18
+
+// parseRecipients parses age public keys (age1...), one per item
+func parseRecipients(keys []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid recipient %q: %v", key, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// parseIdentities parses age secret keys (AGE-SECRET-KEY-1...), in the
+// format of an age identity file: one per line, # starting a comment
+func parseIdentities(keys string) ([]age.Identity, error) {
+	identities, err := age.ParseIdentities(strings.NewReader(keys))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid identity: %v", err)
+	}
+	return identities, nil
+}
+
+// requestPassword fills password from the password header, refusing a
+// request that gives a different password in its body as well
+func requestPassword(r *http.Request, password *string) error {
+	header := r.Header.Get(passwordHeader)
+	if header == "" {
+		return nil
+	}
+	if *password != "" && *password != header {
+		return errors.New("Password given in both the " + passwordHeader + " header and the body")
+	}
+	*password = header
+	return nil
+}
+
+// passwordRecipient encrypts to a password, as age -p does
+func passwordRecipient(password string) (age.Recipient, error) {
+	recipient, err := age.NewScryptRecipient(password)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid password: %v", err)
+	}
+	recipient.SetWorkFactor(passwordWorkFactor)
+	return recipient, nil
+}
+
+// passwordIdentities decrypts an archive encrypted to a password; without a
+// password there are none
+func passwordIdentities(password string) ([]age.Identity, error) {
+	if password == "" {
+		return nil, nil
+	}
+	identity, err := age.NewScryptIdentity(password)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid password: %v", err)
+	}
+	return []age.Identity{identity}, nil
+}
+
+// encryptingEncoder closes a compression stream and then the age stream it
+// writes to, which adds the final authenticated chunk
+type encryptingEncoder struct {
+	io.WriteCloser
+	encryption io.WriteCloser
+	closed     bool
+}
+
+func (e *encryptingEncoder) Close() error {
+	// The archive's writer is closed more than once on some paths, and a
+	// second age Close would append another chunk
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	if err := e.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return e.encryption.Close()
+}
+
+// withEncryption wraps newEncoder so its output is encrypted to recipients
+func withEncryption(newEncoder func(w io.Writer) (io.WriteCloser, error), recipients []age.Recipient) func(w io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		encryption, err := age.Encrypt(w, recipients...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start encryption: %v", err)
+		}
+		encoder, err := newEncoder(encryption)
+		if err != nil {
+			return nil, err
+		}
+		return &encryptingEncoder{WriteCloser: encoder, encryption: encryption}, nil
+	}
+}
+
+// decryptArchive returns a reader for the archive's contents, decrypting
+// them with identities if the archive is encrypted
+func decryptArchive(file *os.File, identities []age.Identity) (io.Reader, error) {
+	magic := make([]byte, len(ageMagic))
+	if _, err := file.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, ageMagic) {
+		return file, nil
+	}
+
+	if len(identities) == 0 {
+		return nil, errors.New("archive is encrypted, a password or identity is needed to read it")
+	}
+	plain, err := age.Decrypt(file, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive: %v", err)
+	}
+	return plain, nil
+}
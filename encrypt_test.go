@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestAgeRoundTrip(t *testing.T) {
+	var identities []*age.X25519Identity
+	for range 2 {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, identity)
+	}
+	outsider, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/secret.txt": "contents", "in/sub/more.txt": "more"})
+	req := CompressRequest{
+		Files:      []string{filepath.Join(dir, "in")},
+		Output:     filepath.Join(dir, "backup.tar.zst"),
+		Level:      3,
+		Recipients: []string{identities[0].Recipient().String(), identities[1].Recipient().String()},
+	}
+	if _, err := prepareCompressRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(req.Output, ".tar.zst.age") {
+		t.Errorf("encrypted archive is named %s", req.Output)
+	}
+	stats, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(stats.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, ageMagic) {
+		t.Fatalf("archive isn't age-encrypted: %q", data[:min(len(data), 32)])
+	}
+	if bytes.Contains(data, []byte("contents")) {
+		t.Error("archive holds the plaintext")
+	}
+
+	// Either recipient can decrypt it
+	for i, identity := range identities {
+		out := filepath.Join(dir, "out", string(rune('a'+i)))
+		if _, err := decompressFile(DecompressRequest{Archive: stats.OutputFile, OutputDir: out, Identity: identity.String()}); err != nil {
+			t.Fatalf("recipient %d: %v", i, err)
+		}
+		if got := readTestFile(t, filepath.Join(out, "in", "secret.txt")); got != "contents" {
+			t.Errorf("recipient %d extracted %q", i, got)
+		}
+		if got := readTestFile(t, filepath.Join(out, "in", "sub", "more.txt")); got != "more" {
+			t.Errorf("recipient %d extracted %q", i, got)
+		}
+	}
+
+	// Anyone else can't
+	_, err = decompressFile(DecompressRequest{Archive: stats.OutputFile, OutputDir: filepath.Join(dir, "outsider"), Identity: outsider.String()})
+	if err == nil || !strings.Contains(err.Error(), "decrypt") {
+		t.Errorf("a key that isn't a recipient got %v", err)
+	}
+	_, err = decompressFile(DecompressRequest{Archive: stats.OutputFile, OutputDir: filepath.Join(dir, "nokey")})
+	if err == nil || !strings.Contains(err.Error(), "identity is needed") {
+		t.Errorf("no identity got %v", err)
+	}
+}
+
+func TestInvalidRecipientRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+	req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "a.tar.zst"), Level: 3, Recipients: []string{"age1notakey"}}
+	if _, err := prepareCompressRequest(&req); err == nil || !strings.Contains(err.Error(), "Invalid recipient") {
+		t.Errorf("an invalid recipient got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressExplicitEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"src/one.txt": "one", "other/two.txt": "two", "tree/sub/three.txt": "three"})
+
+	req := CompressRequest{
+		Entries: []ArchiveEntry{
+			{Source: filepath.Join(dir, "src", "one.txt"), Target: "docs/first.txt"},
+			{Source: filepath.Join(dir, "other", "two.txt"), Target: "second.txt"},
+			{Source: filepath.Join(dir, "tree"), Target: "nested/tree"},
+		},
+		Output: filepath.Join(dir, "mapped.tar.zst"),
+		Level:  3,
+	}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"docs/first.txt":            "one",
+		"second.txt":                "two",
+		"nested/tree":               "",
+		"nested/tree/sub":           "",
+		"nested/tree/sub/three.txt": "three",
+	}
+	if got := archiveNames(t, req.Output); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
+
+func TestCompressExplicitEntriesRejectsBadTargets(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "a.txt")
+	for _, entries := range [][]ArchiveEntry{
+		{{Source: source, Target: "../escape.txt"}},
+		{{Source: source, Target: "/abs.txt"}},
+		{{Source: source, Target: "same.txt"}, {Source: source, Target: "./same.txt"}},
+		{{Source: "", Target: "x.txt"}},
+	} {
+		req := CompressRequest{Entries: entries, Output: source + ".tar.zst", Level: 3}
+		if _, err := compressFiles(req); err == nil {
+			t.Errorf("entries %v were accepted", entries)
+		} else if !strings.Contains(err.Error(), "target") && !strings.Contains(err.Error(), "source") {
+			t.Errorf("entries %v got %v", entries, err)
+		}
+	}
+}
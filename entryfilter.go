@@ -0,0 +1,70 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// EntryFilter decides whether an entry goes into the archive, given its
+// header as it would be written: name, size, mode, modification time and
+// so on. Returning false drops the entry; dropping a directory keeps
+// whatever the filter lets through below it.
+type EntryFilter func(header *tar.Header) bool
+
+// parseEntryFilter returns the built-in filter named by spec: "max-size:N",
+// which drops files larger than N bytes, "pattern:GLOB", which keeps only
+// the files whose base name matches GLOB, or "no-hidden", which drops
+// entries with a path segment starting with a dot.
+func parseEntryFilter(spec string) (EntryFilter, error) {
+	switch {
+	case strings.HasPrefix(spec, "max-size:"):
+		limit, err := strconv.ParseInt(strings.TrimPrefix(spec, "max-size:"), 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("max-size needs a size in bytes")
+		}
+		return func(header *tar.Header) bool {
+			return header.Typeflag != tar.TypeReg || header.Size <= limit
+		}, nil
+
+	case strings.HasPrefix(spec, "pattern:"):
+		pattern := strings.TrimPrefix(spec, "pattern:")
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		return func(header *tar.Header) bool {
+			if header.Typeflag == tar.TypeDir {
+				return true
+			}
+			matched, _ := path.Match(pattern, path.Base(header.Name))
+			return matched
+		}, nil
+
+	case spec == "no-hidden":
+		return func(header *tar.Header) bool {
+			for _, segment := range strings.Split(strings.TrimSuffix(header.Name, "/"), "/") {
+				if strings.HasPrefix(segment, ".") && segment != "." && segment != ".." {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown entry filter %q", spec)
+}
+
+// allEntryFilters combines filters into one that keeps an entry only when
+// every filter does
+func allEntryFilters(filters ...EntryFilter) EntryFilter {
+	return func(header *tar.Header) bool {
+		for _, filter := range filters {
+			if filter != nil && !filter(header) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEntryFilterDropsLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"in/small.txt":     "small",
+		"in/large.bin":     strings.Repeat("x", 1000),
+		"in/sub/edge.txt":  strings.Repeat("y", 100),
+		"in/sub/large.log": strings.Repeat("z", 101),
+	})
+
+	var seen []string
+	archive := filepath.Join(dir, "filtered.tar.zst")
+	_, err := compressFiles(CompressRequest{
+		Files:  []string{filepath.Join(dir, "in")},
+		Output: archive,
+		Level:  3,
+		EntryFilter: func(header *tar.Header) bool {
+			seen = append(seen, header.Name)
+			return header.Typeflag != tar.TypeReg || header.Size <= 100
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"in": "", "in/small.txt": "small", "in/sub": "", "in/sub/edge.txt": strings.Repeat("y", 100)}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+	// The filter sees the headers as they'd be written, directories included
+	if len(seen) != 6 {
+		t.Errorf("filter saw %v", seen)
+	}
+}
+
+func TestBuiltInEntryFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"in/a.txt":       "alpha",
+		"in/big.txt":     strings.Repeat("x", 100),
+		"in/b.log":       "log",
+		"in/.hidden.txt": "hidden",
+		"in/.git/config": "[core]",
+		"in/sub/c.txt":   "charlie",
+	})
+
+	tests := []struct {
+		specs []string
+		want  []string
+	}{
+		{[]string{"max-size:10"}, []string{"in", "in/.git", "in/.git/config", "in/.hidden.txt", "in/a.txt", "in/b.log", "in/sub", "in/sub/c.txt"}},
+		{[]string{"pattern:*.txt"}, []string{"in", "in/.git", "in/.hidden.txt", "in/a.txt", "in/big.txt", "in/sub", "in/sub/c.txt"}},
+		{[]string{"no-hidden"}, []string{"in", "in/a.txt", "in/b.log", "in/big.txt", "in/sub", "in/sub/c.txt"}},
+		{[]string{"no-hidden", "pattern:*.txt", "max-size:10"}, []string{"in", "in/a.txt", "in/sub", "in/sub/c.txt"}},
+	}
+	for i, tt := range tests {
+		req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "out.tar.zst"), Level: 3, EntryFilterSpecs: tt.specs}
+		if _, err := prepareCompressRequest(&req); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := compressFiles(req); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name := range archiveNames(t, req.Output) {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%d: %v archived %v\nwant %v", i, tt.specs, names, tt.want)
+		}
+	}
+}
+
+func TestInvalidEntryFilterRejected(t *testing.T) {
+	for _, spec := range []string{"max-size:big", "pattern:[", "everything"} {
+		req := CompressRequest{Files: []string{"in"}, EntryFilterSpecs: []string{spec}}
+		if _, err := prepareCompressRequest(&req); err == nil {
+			t.Errorf("entry filter %q was accepted", spec)
+		}
+	}
+}
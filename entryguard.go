@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// entryGuard makes the checks every reader of an untrusted archive applies
+// before handing an entry on: extraction, /api/scan and
+// /api/decompress-stream. It places entries under root and remembers the
+// symlinks it accepted, so nothing is placed below a symlink and no link
+// target passes through one. With fsys set, symlinks already on disk under
+// root count too, such as those an earlier extraction left.
+type entryGuard struct {
+	root  string
+	fsys  ExtractFS
+	links map[string]bool // clean names of accepted symlinks
+}
+
+func newEntryGuard(root string, fsys ExtractFS) *entryGuard {
+	return &entryGuard{root: filepath.Clean(root), fsys: fsys, links: make(map[string]bool)}
+}
+
+// entryPlace is where an entry goes. Absolute is set for entries that named
+// an absolute path; restored ones go to that path, outside root.
+type entryPlace struct {
+	cleanName  string
+	targetPath string
+	absolute   bool
+	restored   bool
+}
+
+// place cleans an entry's name and works out where it goes under the
+// absolute path policy ("reject" is left to the caller). A risk,
+// "traversal" or "empty-name", means the entry has no safe name.
+func (g *entryGuard) place(name, absolutePolicy string) (entryPlace, string) {
+	cleanName := sanitizeExtractPath(name)
+	if cleanName == "" {
+		if strings.Contains(name, "..") {
+			return entryPlace{}, "traversal"
+		}
+		return entryPlace{}, "empty-name"
+	}
+
+	place := entryPlace{cleanName: cleanName, targetPath: filepath.Join(g.root, cleanName)}
+	if isAbsoluteEntry(name) {
+		place.absolute = true
+		if absolutePolicy == "restore-to-root" {
+			// cleanName has no ".." left, so this stays the path the entry names
+			place.targetPath = filepath.Join(string(os.PathSeparator), cleanName)
+			place.restored = true
+		}
+	}
+
+	if !place.restored && !strings.HasPrefix(place.targetPath, g.root+string(os.PathSeparator)) {
+		return entryPlace{}, "traversal"
+	}
+	return place, ""
+}
+
+// belowSymlink returns the first parent directory of the entry that is a
+// symlink; writing the entry would follow it. Restored entries are only
+// checked against the links of the archive, as system paths have their own.
+func (g *entryGuard) belowSymlink(place entryPlace) (string, bool) {
+	checkDisk := g.fsys != nil && !place.restored
+	dir := ""
+	for _, part := range strings.Split(filepath.Dir(place.cleanName), string(os.PathSeparator)) {
+		if part == "." {
+			break
+		}
+		dir = filepath.Join(dir, part)
+		if g.links[dir] {
+			return dir, true
+		}
+		if !checkDisk {
+			continue
+		}
+		info, err := g.fsys.Lstat(filepath.Join(g.root, dir))
+		if err != nil {
+			// Nothing below a missing directory exists either
+			checkDisk = false
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// checkLink follows the target of a symlink entry one component at a time,
+// the way the filesystem will. It returns the cleaned target to create the
+// link with, or why the link is refused: its target is empty, leaves root,
+// or passes through another symlink. Targets through other links are
+// refused because where those lead can change as later entries are
+// extracted. Restored links are always refused.
+func (g *entryGuard) checkLink(place entryPlace, linkname string) (string, string) {
+	if linkname == "" {
+		return "", "target is empty"
+	}
+	if place.restored || isAbsoluteEntry(linkname) || filepath.IsAbs(filepath.FromSlash(linkname)) {
+		return "", "target is outside the output directory"
+	}
+
+	parts := strings.Split(strings.ReplaceAll(linkname, "\\", "/"), "/")
+	current := filepath.Dir(place.cleanName)
+	for i, part := range parts {
+		switch part {
+		case "", ".":
+		case "..":
+			if current == "." {
+				return "", "target is outside the output directory"
+			}
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+			if i < len(parts)-1 && g.isLink(current) {
+				return "", "target passes through symlink " + filepath.ToSlash(current)
+			}
+		}
+	}
+	if current == "." {
+		return "", "target is outside the output directory"
+	}
+
+	// Store the target without the detours, so it can't lead elsewhere
+	// should a directory it passed through be replaced later
+	return filepath.Clean(filepath.FromSlash(strings.Join(parts, "/"))), ""
+}
+
+// addLink records an accepted symlink
+func (g *entryGuard) addLink(place entryPlace) {
+	g.links[place.cleanName] = true
+}
+
+// isLink reports whether dir, relative to root, is a symlink
+func (g *entryGuard) isLink(dir string) bool {
+	if g.links[dir] {
+		return true
+	}
+	if g.fsys == nil {
+		return false
+	}
+	info, err := g.fsys.Lstat(filepath.Join(g.root, dir))
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestEntryGuardPlace(t *testing.T) {
+	guard := newEntryGuard(scanRoot, nil)
+
+	tests := []struct {
+		name, policy string
+		cleanName    string
+		risk         string
+		restored     bool
+	}{
+		{name: "a/b.txt", cleanName: filepath.Join("a", "b.txt")},
+		{name: `a\b.txt`, cleanName: filepath.Join("a", "b.txt")},
+		{name: "../etc/passwd", risk: "traversal"},
+		{name: "a/../../b", risk: "traversal"},
+		{name: "./", risk: "empty-name"},
+		{name: "/etc/hosts", cleanName: filepath.Join("etc", "hosts")},
+		{name: "/etc/hosts", policy: "restore-to-root", cleanName: filepath.Join("etc", "hosts"), restored: true},
+	}
+	for _, test := range tests {
+		place, risk := guard.place(test.name, test.policy)
+		if risk != test.risk || place.cleanName != test.cleanName || place.restored != test.restored {
+			t.Errorf("place(%q, %q) = %+v, %q", test.name, test.policy, place, risk)
+		}
+	}
+}
+
+func TestEntryGuardCheckLink(t *testing.T) {
+	guard := newEntryGuard(scanRoot, nil)
+	link := func(name, target string) (string, string) {
+		place, _ := guard.place(name, "")
+		return guard.checkLink(place, target)
+	}
+
+	if target, reason := link("d/e/t", "../../f"); reason != "" || filepath.ToSlash(target) != "../../f" {
+		t.Fatalf("d/e/t -> ../../f gave %q (%s)", target, reason)
+	}
+	place, _ := guard.place("d/e/t", "")
+	guard.addLink(place)
+
+	tests := []struct {
+		name, target string
+		want         string // "" when refused
+	}{
+		{"d/e/s", "t/../../escaped", ""},
+		{"d/e/s", "t", "t"},
+		{"d/e/s", "./x/../y", "y"},
+		{"d/up", "..", ""},
+		{"d/up", "../..", ""},
+		{"d/abs", "/etc", ""},
+		{"d/empty", "", ""},
+		{"d/win", `..\x`, "../x"},
+	}
+	for _, test := range tests {
+		target, reason := link(test.name, test.target)
+		if filepath.ToSlash(target) != test.want || (test.want == "") != (reason != "") {
+			t.Errorf("%s -> %s gave %q (%s), want %q", test.name, test.target, target, reason, test.want)
+		}
+	}
+
+	if dir, ok := guard.belowSymlink(entryPlace{cleanName: filepath.Join("d", "e", "t", "x")}); !ok || dir != filepath.Join("d", "e", "t") {
+		t.Errorf("entry below d/e/t not caught")
+	}
+}
+
+// Scanning, streaming and extracting an archive all drop the same entries
+func TestEntryChecksAgree(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "risky.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "f/"},
+		testEntry{name: "d/e/t", link: "../../f"},
+		testEntry{name: "d/e/s", link: "t/../../escaped"},
+		testEntry{name: "d/e/t/evil.txt", body: "evil"},
+		testEntry{name: "../outside.txt", body: "evil"},
+		testEntry{name: "up", link: "../x"},
+		testEntry{name: "ok.txt", body: "fine"},
+	)
+
+	report, err := scanArchiveSafety(DecompressRequest{Archive: archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var risky []string
+	for _, risk := range report.Risks {
+		risky = append(risky, risk.Name)
+	}
+	sort.Strings(risky)
+	want := []string{"../outside.txt", "d/e/s", "d/e/t/evil.txt", "up"}
+	if !reflect.DeepEqual(risky, want) {
+		t.Errorf("scan flagged %v, want %v", risky, want)
+	}
+
+	var streamed bytes.Buffer
+	if err := streamArchiveTar(archive, &streamed); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tarReader := tar.NewReader(&streamed)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	output := filepath.Join(dir, "out")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	var extracted []string
+	err = filepath.Walk(output, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == output {
+			return err
+		}
+		rel, _ := filepath.Rel(output, path)
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			name += "/"
+		}
+		// Parents of the entries are created along with them
+		if name != "d/" && name != "d/e/" {
+			extracted = append(extracted, name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(extracted)
+
+	want = []string{"d/e/t", "f/", "ok.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("stream kept %v, want %v", names, want)
+	}
+	if !reflect.DeepEqual(extracted, want) {
+		t.Errorf("extraction kept %v, want %v", extracted, want)
+	}
+}
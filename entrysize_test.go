@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaxEntrySizeSkipsOversizedEntry(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	archive := filepath.Join(dir, "mixed.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "small.txt", body: "small"},
+		testEntry{name: "big.bin", body: strings.Repeat("x", 1000)},
+		testEntry{name: "also-small.txt", body: "also small"},
+	)
+
+	output := filepath.Join(dir, "out")
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: "out", MaxEntrySize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(result.SkippedEntries, []string{"big.bin"}) {
+		t.Errorf("skipped %v, want big.bin", result.SkippedEntries)
+	}
+	if _, err := os.Stat(filepath.Join(output, "big.bin")); !os.IsNotExist(err) {
+		t.Errorf("oversized entry was extracted: %v", err)
+	}
+	if readTestFile(t, filepath.Join(output, "small.txt")) != "small" || readTestFile(t, filepath.Join(output, "also-small.txt")) != "also small" {
+		t.Error("entries under the limit weren't extracted")
+	}
+}
+
+func TestMaxEntrySizeAbortPolicy(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	archive := filepath.Join(dir, "mixed.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "big.bin", body: strings.Repeat("x", 1000)})
+
+	_, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: "out", MaxEntrySize: 100, EntrySizePolicy: "abort"})
+	if err == nil || !strings.Contains(err.Error(), "exceeding the maximum entry size") {
+		t.Errorf("got %v, want the entry size error", err)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestEnvOrDefault(t *testing.T) {
+	t.Setenv("PORT", "")
+	if got := envOrDefault("PORT", "8080"); got != "8080" {
+		t.Errorf("empty PORT resolved to %q, want the default", got)
+	}
+
+	t.Setenv("PORT", "9090")
+	if got := envOrDefault("PORT", "8080"); got != "9090" {
+		t.Errorf("PORT=9090 resolved to %q", got)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memFS is an ExtractFS held in memory
+type memFS struct {
+	files map[string]*memFile
+}
+
+type memFile struct {
+	name    string
+	data    bytes.Buffer
+	mode    os.FileMode
+	modTime time.Time
+	link    string
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{"/": {name: "/", mode: os.ModeDir | 0755}}}
+}
+
+func (m *memFS) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	if file, ok := m.files[path]; ok {
+		if !file.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrExist}
+		}
+		return nil
+	}
+	if err := m.MkdirAll(filepath.Dir(path), perm); err != nil {
+		return err
+	}
+	m.files[path] = &memFile{name: filepath.Base(path), mode: os.ModeDir | perm}
+	return nil
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	name = filepath.Clean(name)
+	if parent, ok := m.files[filepath.Dir(name)]; !ok || !parent.mode.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	file, ok := m.files[name]
+	if ok && flag&os.O_EXCL != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if !ok || flag&os.O_TRUNC != 0 {
+		file = &memFile{name: filepath.Base(name), mode: perm, modTime: time.Now()}
+		m.files[name] = file
+	}
+	return memWriter{file}, nil
+}
+
+type memWriter struct{ file *memFile }
+
+func (w memWriter) Write(p []byte) (int, error) { return w.file.data.Write(p) }
+func (w memWriter) Close() error                { return nil }
+
+func (m *memFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	for name := range m.files {
+		if name == path || strings.HasPrefix(name, path+string(os.PathSeparator)) {
+			delete(m.files, name)
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Lstat(name string) (os.FileInfo, error) {
+	file, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memInfo{file}, nil
+}
+
+func (m *memFS) Symlink(oldname, newname string) error {
+	newname = filepath.Clean(newname)
+	if _, ok := m.files[newname]; ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	m.files[newname] = &memFile{name: filepath.Base(newname), mode: os.ModeSymlink | 0777, link: oldname}
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, atime, mtime time.Time) error {
+	file, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	file.modTime = mtime
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode os.FileMode) error {
+	file, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	file.mode = file.mode&os.ModeType | mode&^os.ModeType
+	return nil
+}
+
+type memInfo struct{ file *memFile }
+
+func (i memInfo) Name() string       { return i.file.name }
+func (i memInfo) Size() int64        { return int64(i.file.data.Len()) }
+func (i memInfo) Mode() os.FileMode  { return i.file.mode }
+func (i memInfo) ModTime() time.Time { return i.file.modTime }
+func (i memInfo) IsDir() bool        { return i.file.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+func TestExtractIntoMemoryFS(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "mem.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "docs/"},
+		testEntry{name: "docs/a.txt", body: "alpha"},
+		testEntry{name: "docs/sub/b.txt", body: "bravo"},
+		testEntry{name: "link", link: "docs/a.txt"},
+	)
+
+	memory := newMemFS()
+	output := filepath.Join(string(os.PathSeparator), "mem", "out")
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, FS: memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FileCount != 2 {
+		t.Errorf("extracted %d files, want 2", result.FileCount)
+	}
+
+	var names []string
+	for name := range memory.files {
+		if strings.HasPrefix(name, output+string(os.PathSeparator)) {
+			rel, _ := filepath.Rel(output, name)
+			names = append(names, filepath.ToSlash(rel))
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"docs", "docs/a.txt", "docs/sub", "docs/sub/b.txt", "link"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("memory FS holds %v, want %v", names, want)
+	}
+	if got := memory.files[filepath.Join(output, "docs", "sub", "b.txt")].data.String(); got != "bravo" {
+		t.Errorf("docs/sub/b.txt holds %q", got)
+	}
+	if link := memory.files[filepath.Join(output, "link")]; link.link != filepath.Join("docs", "a.txt") {
+		t.Errorf("link points to %q", link.link)
+	}
+
+	// Nothing was written to disk
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("extraction wrote %d entries to disk", len(entries)-1)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("%s exists on disk", output)
+	}
+}
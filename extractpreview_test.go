@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractPreviewTotals(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "known.tar.zst")
+	entries := []testEntry{{name: "docs/"}, {name: "docs/img/"}, {name: "link", link: "docs"}}
+	var total int64
+	for i := range 12 {
+		body := strings.Repeat("x", (i+1)*100)
+		entries = append(entries, testEntry{name: fmt.Sprintf("docs/%02d.txt", i), body: body})
+		total += int64(len(body))
+	}
+	writeTestArchive(t, archive, entries...)
+
+	rec := httptest.NewRecorder()
+	handleExtractPreview(rec, httptest.NewRequest(http.MethodGet, "/api/extract-preview?archive="+url.QueryEscape(archive), nil))
+	var resp struct {
+		Success bool
+		Message string
+		Data    ArchiveSummary
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Fatalf("preview failed: %s", resp.Message)
+	}
+
+	summary := resp.Data
+	if summary.TotalSize != total || summary.FileCount != 12 || summary.DirectoryCount != 2 {
+		t.Errorf("summary reports %d bytes in %d files and %d directories, want %d, 12 and 2", summary.TotalSize, summary.FileCount, summary.DirectoryCount, total)
+	}
+	var largest []string
+	for _, entry := range summary.LargestEntries {
+		largest = append(largest, entry.Name)
+	}
+	want := []string{"docs/11.txt", "docs/10.txt", "docs/09.txt", "docs/08.txt", "docs/07.txt", "docs/06.txt", "docs/05.txt", "docs/04.txt", "docs/03.txt", "docs/02.txt"}
+	if !reflect.DeepEqual(largest, want) {
+		t.Errorf("largest entries are %v, want %v", largest, want)
+	}
+
+	// Only the archive itself is left in dir: nothing was extracted
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("preview wrote %d files next to the archive", len(files)-1)
+	}
+}
@@ -0,0 +1,77 @@
+//go:build unix
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressToFIFO(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+	fifo := filepath.Join(dir, "out.tar.zst")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skipf("can't create a FIFO: %v", err)
+	}
+
+	type result struct {
+		stats *CompressionStats
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		inputs := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")}
+		stats, err := compressFiles(CompressRequest{Files: inputs, Output: fifo, Level: 3})
+		done <- result{stats, err}
+	}()
+
+	reader, err := os.Open(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.stats.CompressedSize != int64(len(data)) {
+		t.Errorf("compressed size is %d, but %d bytes came through the FIFO", res.stats.CompressedSize, len(data))
+	}
+
+	decoder, err := zstd.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	entries := map[string]string{}
+	archive := tar.NewReader(decoder)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(archive)
+		entries[header.Name] = string(body)
+	}
+	if entries["a.txt"] != "alpha" || entries["b.txt"] != "bravo" {
+		t.Errorf("read %v from the FIFO", entries)
+	}
+	if info, err := os.Lstat(fifo); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("the FIFO was replaced: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// mimeGroups are the top-level MIME types, which ExtractTypes accepts on
+// their own to select a whole group
+var mimeGroups = map[string]bool{
+	"application": true, "audio": true, "font": true, "image": true,
+	"model": true, "text": true, "video": true,
+}
+
+// typeFilter selects entries by type for DecompressRequest.ExtractTypes
+type typeFilter struct {
+	exts   map[string]bool // ".txt"
+	types  map[string]bool // "application/pdf"
+	groups map[string]bool // "text"
+}
+
+// parseTypeFilter parses ExtractTypes. An item with a slash is a MIME type,
+// or a group when it ends in "/*"; a top-level type like "image" is a group
+// too, and anything else is an extension, with or without its dot.
+func parseTypeFilter(items []string) (*typeFilter, error) {
+	f := &typeFilter{exts: make(map[string]bool), types: make(map[string]bool), groups: make(map[string]bool)}
+	for _, item := range items {
+		item = strings.ToLower(strings.TrimSpace(item))
+		switch {
+		case item == "" || item == "." || item == "/*":
+			return nil, fmt.Errorf("Invalid extract type %q", item)
+		case strings.HasSuffix(item, "/*"):
+			f.groups[strings.TrimSuffix(item, "/*")] = true
+		case strings.Contains(item, "/"):
+			f.types[item] = true
+		case mimeGroups[item]:
+			f.groups[item] = true
+		default:
+			f.exts["."+strings.TrimPrefix(item, ".")] = true
+		}
+	}
+	return f, nil
+}
+
+func (f *typeFilter) matchesMIME(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	group, _, _ := strings.Cut(mediaType, "/")
+	return f.types[mediaType] || f.groups[group]
+}
+
+// match reports whether the entry called name is selected. The extension
+// decides when it's listed or has a known MIME type; otherwise the start of
+// the contents is sniffed, and the returned reader still reads them whole.
+// A nil body (links, which have no contents) can only match by name.
+func (f *typeFilter) match(name string, body io.Reader) (bool, io.Reader) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != "" {
+		if f.exts[ext] {
+			return true, body
+		}
+		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+			return f.matchesMIME(mimeType), body
+		}
+	}
+
+	if body == nil {
+		return false, body
+	}
+
+	buffered := bufio.NewReaderSize(body, 512)
+	head, _ := buffered.Peek(512)
+	return f.matchesMIME(http.DetectContentType(head)), buffered
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// extractedFiles returns the files under dir, relative to it
+func extractedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+func TestExtractTypes(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "mixed.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "docs/"},
+		testEntry{name: "docs/readme.txt", body: "read me"},
+		testEntry{name: "docs/NOTES.TXT", body: "upper case extension"},
+		testEntry{name: "docs/page.html", body: "<html></html>"},
+		testEntry{name: "image.png", body: "\x89PNG\r\n\x1a\n"},
+		testEntry{name: "LICENSE", body: "plain text without an extension"},
+		testEntry{name: "blob", body: "\x00\x01\x02\x03binary"},
+	)
+
+	for _, test := range []struct {
+		types []string
+		want  []string
+	}{
+		{[]string{".txt"}, []string{"docs/NOTES.TXT", "docs/readme.txt"}},
+		{[]string{"txt", "png"}, []string{"docs/NOTES.TXT", "docs/readme.txt", "image.png"}},
+		// The text group takes in extensionless files sniffed as text
+		{[]string{"text"}, []string{"LICENSE", "docs/NOTES.TXT", "docs/page.html", "docs/readme.txt"}},
+		{[]string{"image/*"}, []string{"image.png"}},
+	} {
+		t.Run(strings.Join(test.types, ","), func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "out")
+			result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, ExtractTypes: test.types})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := extractedFiles(t, output); !slices.Equal(got, test.want) {
+				t.Errorf("extracted %v, want %v", got, test.want)
+			}
+			if result.TypeMatched != len(test.want) || result.TypeSkipped != 6-len(test.want) {
+				t.Errorf("matched %d, skipped %d, want %d and %d", result.TypeMatched, result.TypeSkipped, len(test.want), 6-len(test.want))
+			}
+		})
+	}
+
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: filepath.Join(dir, "bad"), ExtractTypes: []string{"/*"}}); err == nil {
+		t.Error("invalid type accepted")
+	}
+}
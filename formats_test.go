@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func TestExtractGzipAndXz(t *testing.T) {
+	entries := []testEntry{{name: "dir/"}, {name: "dir/a.txt", body: "alpha"}, {name: "b.txt", body: "bravo"}}
+
+	for _, test := range []struct {
+		name       string
+		newEncoder func(w io.Writer) (io.WriteCloser, error)
+	}{
+		{"fixture.tar.gz", func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }},
+		{"fixture.tar.xz", func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var compressed bytes.Buffer
+			encoder, err := test.newEncoder(&compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestTar(t, encoder, entries...)
+			if err := encoder.Close(); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, test.name)
+			if err := os.WriteFile(archive, compressed.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			output := filepath.Join(dir, "out")
+			result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.FileCount != 2 {
+				t.Errorf("extracted %d files, want 2", result.FileCount)
+			}
+			if readTestFile(t, filepath.Join(output, "dir", "a.txt")) != "alpha" || readTestFile(t, filepath.Join(output, "b.txt")) != "bravo" {
+				t.Error("extracted files don't match the archive")
+			}
+		})
+	}
+}
+
+func TestGzipAndZstdRoundTrip(t *testing.T) {
+	files := map[string]string{"in/a.txt": "alpha", "in/sub/b.txt": "bravo"}
+
+	for _, test := range []struct {
+		format, ext string
+		magic       []byte
+		level, want int
+	}{
+		{"zstd", ".zst", zstdMagic, 19, 19},
+		{"gzip", ".tar.gz", gzipMagic, 19, 9},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+			writeTestFiles(t, dir, files)
+
+			resp := postCompress(t, CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: "out", Level: test.level, Format: test.format})
+			if !resp.Success {
+				t.Fatalf("compression failed: %s", resp.Message)
+			}
+			stats := resp.Data
+			if filepath.Base(stats.OutputFile) != "out"+test.ext {
+				t.Errorf("output is %s, want a %s extension", stats.OutputFile, test.ext)
+			}
+			if stats.Level != test.want {
+				t.Errorf("level %d reported as %d, want %d", test.level, stats.Level, test.want)
+			}
+			if archive := readTestFile(t, stats.OutputFile); !bytes.HasPrefix([]byte(archive), test.magic) {
+				t.Errorf("%s output doesn't start with the %s magic", test.format, test.format)
+			}
+
+			// The format is sniffed from the contents, whatever the extension
+			renamed := filepath.Join(dir, "renamed.bin")
+			if err := os.Rename(stats.OutputFile, renamed); err != nil {
+				t.Fatal(err)
+			}
+			output := filepath.Join(dir, "output")
+			if _, err := decompressFile(DecompressRequest{Archive: renamed, OutputDir: output}); err != nil {
+				t.Fatal(err)
+			}
+			for name, body := range files {
+				if got := readTestFile(t, filepath.Join(output, filepath.FromSlash(name))); got != body {
+					t.Errorf("%s holds %q, want %q", name, got, body)
+				}
+			}
+		})
+	}
+}
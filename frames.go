@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A framed archive stores each top-level input as its own zstd frame holding
+// the input's tar entries without the end-of-archive marker, so the frames
+// concatenate into one tar stream. A skippable frame at the end indexes the
+// frames; decoders ignore it, and appending replaces it.
+const (
+	frameIndexMagic  = 0x184D2A5E // one of the zstd skippable frame magics
+	frameIndexMarker = "ZFIX"     // ends the index payload
+)
+
+type frameIndex struct {
+	Frames []frameInfo `json:"frames"`
+}
+
+type frameInfo struct {
+	Input   string `json:"input"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"`
+	Entries int    `json:"entries"`
+}
+
+// frameWriter is an archiveWriter that starts a new zstd frame for every
+// input and writes the frame index when closed.
+type frameWriter struct {
+	req        *CompressRequest
+	newEncoder func(w io.Writer) (io.WriteCloser, error)
+
+	file      *os.File
+	offset    int64 // where the current frame starts
+	counter   *countingWriter
+	encoder   io.WriteCloser
+	tarWriter *tar.Writer
+
+	index  frameIndex
+	closed bool
+
+	// Where the frame index of the archive appended to started, and the
+	// index frame itself, to put back if the append fails
+	appendOffset  int64
+	previousIndex []byte
+}
+
+// newFrameWriter creates the output, or with req.Append reopens an existing
+// framed archive and drops its index so new frames follow the old ones.
+func newFrameWriter(req *CompressRequest, newEncoder func(w io.Writer) (io.WriteCloser, error)) (*frameWriter, error) {
+	f := &frameWriter{req: req, newEncoder: newEncoder}
+
+	if req.Append {
+		file, err := os.OpenFile(req.Output, os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive to append to: %v", err)
+		}
+
+		index, indexOffset, err := readFrameIndex(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		f.appendOffset = indexOffset
+		f.previousIndex = make([]byte, info.Size()-indexOffset)
+		if _, err := file.ReadAt(f.previousIndex, indexOffset); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read frame index: %v", err)
+		}
+		if err := file.Truncate(indexOffset); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to remove frame index: %v", err)
+		}
+		if _, err := file.Seek(indexOffset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		f.file, f.index, f.offset = file, *index, indexOffset
+		return f, nil
+	}
+
+	file, err := os.Create(req.Output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %v", err)
+	}
+	f.file = file
+	return f, nil
+}
+
+// nextFrame finishes the current frame and starts one for input. Headers
+// written before the first input (archive metadata) share its frame.
+func (f *frameWriter) nextFrame(input string) error {
+	if f.encoder != nil && f.index.Frames[len(f.index.Frames)-1].Entries == 0 {
+		f.index.Frames[len(f.index.Frames)-1].Input = input
+		return nil
+	}
+
+	if err := f.finishFrame(); err != nil {
+		return err
+	}
+
+	f.counter = &countingWriter{w: f.file}
+	encoder, err := f.newEncoder(f.counter)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+
+	f.encoder, f.tarWriter = encoder, tar.NewWriter(encoder)
+	f.index.Frames = append(f.index.Frames, frameInfo{Input: input, Offset: f.offset})
+	return nil
+}
+
+// finishFrame ends the current frame, if any. The tar writer is flushed but
+// not closed, so no end-of-archive marker separates it from the next frame.
+func (f *frameWriter) finishFrame() error {
+	if f.encoder == nil {
+		return nil
+	}
+
+	if err := f.tarWriter.Flush(); err != nil {
+		return fmt.Errorf("failed to finalize frame: %v", err)
+	}
+	if err := f.encoder.Close(); err != nil {
+		return fmt.Errorf("failed to finalize zstd stream: %v", err)
+	}
+
+	f.index.Frames[len(f.index.Frames)-1].Size = f.counter.n
+	f.offset += f.counter.n
+	f.encoder, f.tarWriter, f.counter = nil, nil, nil
+	return nil
+}
+
+func (f *frameWriter) WriteHeader(header *tar.Header) error {
+	if f.encoder == nil {
+		if err := f.nextFrame(""); err != nil {
+			return err
+		}
+	}
+
+	if header.Typeflag != tar.TypeXGlobalHeader {
+		f.index.Frames[len(f.index.Frames)-1].Entries++
+	}
+	return f.tarWriter.WriteHeader(header)
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	if f.tarWriter == nil {
+		return 0, fmt.Errorf("write before header")
+	}
+	return f.tarWriter.Write(p)
+}
+
+// Close finishes the last frame and writes the index. It's safe to call more
+// than once.
+func (f *frameWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer f.file.Close()
+
+	if err := f.finishFrame(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(f.index)
+	if err != nil {
+		return err
+	}
+
+	var frame bytes.Buffer
+	binary.Write(&frame, binary.LittleEndian, uint32(frameIndexMagic))
+	binary.Write(&frame, binary.LittleEndian, uint32(len(payload)+8))
+	frame.Write(payload)
+	binary.Write(&frame, binary.LittleEndian, uint32(len(payload)))
+	frame.WriteString(frameIndexMarker)
+
+	if _, err := f.file.Write(frame.Bytes()); err != nil {
+		return fmt.Errorf("failed to write frame index: %v", err)
+	}
+
+	if f.req.Sync {
+		if err := fsync(f.file); err != nil {
+			return fmt.Errorf("failed to sync output file: %v", err)
+		}
+	}
+	return nil
+}
+
+// restore puts the archive appended to back as it was: its old frames
+// followed by its old index, dropping whatever this append wrote. It's for
+// appends that fail part way, and leaves the writer closed.
+func (f *frameWriter) restore() error {
+	if !f.closed {
+		f.closed = true
+		f.file.Close()
+	}
+
+	file, err := os.OpenFile(f.req.Output, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to restore archive: %v", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(f.appendOffset); err != nil {
+		return fmt.Errorf("failed to restore archive: %v", err)
+	}
+	if _, err := file.WriteAt(f.previousIndex, f.appendOffset); err != nil {
+		return fmt.Errorf("failed to restore archive: %v", err)
+	}
+	return nil
+}
+
+// readFrameIndex reads the index at the end of a framed archive and returns
+// it with the offset its skippable frame starts at.
+func readFrameIndex(file *os.File) (*frameIndex, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	notFramed := fmt.Errorf("%s is not a framed archive", file.Name())
+
+	footer := make([]byte, 8)
+	if info.Size() < 16 {
+		return nil, 0, notFramed
+	}
+	if _, err := file.ReadAt(footer, info.Size()-8); err != nil {
+		return nil, 0, err
+	}
+	if string(footer[4:]) != frameIndexMarker {
+		return nil, 0, notFramed
+	}
+
+	payloadSize := int64(binary.LittleEndian.Uint32(footer))
+	start := info.Size() - 8 - payloadSize - 8
+	if start < 0 {
+		return nil, 0, notFramed
+	}
+
+	frame := make([]byte, 8+payloadSize)
+	if _, err := file.ReadAt(frame, start); err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(frame) != frameIndexMagic || int64(binary.LittleEndian.Uint32(frame[4:])) != payloadSize+8 {
+		return nil, 0, notFramed
+	}
+
+	var index frameIndex
+	if err := json.Unmarshal(frame[8:], &index); err != nil {
+		return nil, 0, fmt.Errorf("failed to read frame index: %v", err)
+	}
+	return &index, start, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFramedAppend(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "first", "b.txt": "second"})
+	archive := filepath.Join(dir, "framed.tar.zst")
+
+	req := CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: archive, Level: 3, Frames: true}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+	req.Files, req.Append = []string{filepath.Join(dir, "b.txt")}, true
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a.txt": "first", "b.txt": "second"}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	index, _, err := readFrameIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Frames) != 2 {
+		t.Errorf("index has %d frames, want 2", len(index.Frames))
+	}
+}
+
+func TestFailedFramedAppendRestoresArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "first"})
+	archive := filepath.Join(dir, "framed.tar.zst")
+
+	req := CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: archive, Level: 3, Frames: true}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server hangs up a quarter of the way through the body
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1048576")
+		io.WriteString(w, strings.Repeat("x", 256<<10))
+	}))
+	defer server.Close()
+
+	req.Files, req.Append = []string{server.URL + "/broken.bin"}, true
+	if _, err := compressFiles(req); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+		t.Fatalf("append returned %v, want the read error", err)
+	}
+
+	after, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("failed append changed the archive from %d to %d bytes", len(before), len(after))
+	}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, map[string]string{"a.txt": "first"}) {
+		t.Errorf("archive holds %v after a failed append", got)
+	}
+}
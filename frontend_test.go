@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestFrontendFromDisk(t *testing.T) {
+	get := func(handler http.Handler, path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	embedded, err := frontendHandler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeddedIndex := get(embedded, "/")
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"index.html": "<p>from disk</p>"})
+	disk, err := frontendHandler(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(disk, "/"); got != "<p>from disk</p>" || got == embeddedIndex {
+		t.Errorf("frontend directory served %q", got)
+	}
+
+	if _, err := frontendHandler(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing frontend directory was accepted")
+	}
+}
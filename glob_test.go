@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompressGlob(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"tree/top.txt":        "top",
+		"tree/a/one.txt":      "one",
+		"tree/a/b/two.txt":    "two",
+		"tree/a/skip.log":     "log",
+		"tree/notes.md":       "md",
+		"elsewhere/other.txt": "other",
+	})
+
+	output := filepath.Join(dir, "txt.tar.zst")
+	resp := postCompress(t, CompressRequest{Glob: "tree/**/*.txt", Output: output, Level: 3})
+	if !resp.Success {
+		t.Fatalf("compression failed: %s", resp.Message)
+	}
+	if resp.Data.GlobMatches != 3 {
+		t.Errorf("glob matched %d files, want 3", resp.Data.GlobMatches)
+	}
+
+	want := map[string]string{"tree/top.txt": "top", "tree/a/one.txt": "one", "tree/a/b/two.txt": "two"}
+	if got := archiveNames(t, output); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
+
+func TestCompressGlobWithoutMatches(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	resp := postCompress(t, CompressRequest{Glob: "**/*.nothing", Output: "out.tar.zst", Level: 3})
+	if resp.Success || !strings.Contains(resp.Message, "No files matched") {
+		t.Errorf("a glob without matches got %v %q", resp.Success, resp.Message)
+	}
+}
+
+func TestCompressExcludeNested(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "project")
+	writeTestFiles(t, root, map[string]string{
+		"main.go":                   "main",
+		"scratch.tmp":               "top-level tmp",
+		"src/lib.go":                "lib",
+		"src/cache.tmp":             "nested tmp",
+		"src/deep/er/util.go":       "util",
+		"src/deep/er/old.tmp":       "deeply nested tmp",
+		"node_modules/pkg/index.js": "top-level modules",
+		"src/web/node_modules/x.js": "nested modules",
+	})
+
+	archive := filepath.Join(dir, "excluded.tar.zst")
+	req := CompressRequest{Files: []string{root}, Output: archive, Level: 3, Exclude: []string{"*.tmp", "node_modules/"}}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"project":                     "",
+		"project/main.go":             "main",
+		"project/src":                 "",
+		"project/src/lib.go":          "lib",
+		"project/src/deep":            "",
+		"project/src/deep/er":         "",
+		"project/src/deep/er/util.go": "util",
+		"project/src/web":             "",
+	}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v\nwant %v", got, want)
+	}
+}
+
+// The files inside an excluded directory match none of the rules, so they
+// only stay out of the archive if the walk never enters the directory. The
+// entry cap proves it: walking them would blow through it.
+func TestCompressExcludePrunesDirectories(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "project")
+	files := map[string]string{"main.go": "main"}
+	for i := range 50 {
+		files[fmt.Sprintf("sub/node_modules/pkg%d/index.js", i)] = "module"
+	}
+	writeTestFiles(t, root, files)
+
+	archive := filepath.Join(dir, "pruned.tar.zst")
+	req := CompressRequest{Files: []string{root}, Output: archive, Level: 3, Exclude: []string{"node_modules/"}, MaxEntries: 10}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatalf("walked into the excluded directory: %v", err)
+	}
+
+	want := map[string]string{"project": "", "project/main.go": "main", "project/sub": ""}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
+
+func TestCompressGlobExclude(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"src/main.go":                 "main",
+		"src/gen/api.go":              "generated",
+		"src/pkg/lib.go":              "lib",
+		"src/pkg/node_modules/dep.go": "vendored",
+	})
+
+	req := CompressRequest{Glob: "src/**/*.go", Exclude: []string{"gen/", "node_modules/"}, Output: filepath.Join(dir, "go.tar.zst"), Level: 3}
+	if _, err := prepareCompressRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"src/main.go": "main", "src/pkg/lib.go": "lib"}
+	if got := archiveNames(t, req.Output); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
+
+func TestCompressGlobStaysInsideWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	writeTestFiles(t, dir, map[string]string{"secret.txt": "secret", "work/a.txt": "a"})
+	t.Chdir(work)
+
+	for _, pattern := range []string{"../*.txt", "../**/*.txt", "a/../../*.txt", filepath.Join(dir, "*.txt"), "/etc/*"} {
+		resp := postCompress(t, CompressRequest{Glob: pattern, Output: "out.tar.zst", Level: 3})
+		if resp.Success || !strings.Contains(resp.Message, "Invalid glob pattern") {
+			t.Errorf("glob %s got %v %q", pattern, resp.Success, resp.Message)
+		}
+	}
+}
+
+func TestCompressGlobSkipsEscapingSymlinks(t *testing.T) {
+	skipWithoutSymlinks(t)
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	writeTestFiles(t, dir, map[string]string{"secret.txt": "secret", "work/src/a.txt": "a"})
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(work, "src", "leak.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(work, "src", "alias.txt")); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(work)
+
+	for _, pattern := range []string{"src/*.txt", "src/**/*.txt"} {
+		matches, err := expandGlob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{filepath.Join("src", "a.txt"), filepath.Join("src", "alias.txt")}
+		if !reflect.DeepEqual(matches, want) {
+			t.Errorf("%s matched %v, want %v", pattern, matches, want)
+		}
+	}
+}
+
+func TestCompressGlobSkipsUnreadableDirectories(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"src/a.txt": "a", "src/locked/b.txt": "b"})
+	if err := os.Chmod(filepath.Join(dir, "src", "locked"), 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(filepath.Join(dir, "src", "locked"), 0755) })
+
+	matches, err := expandGlob("src/**/*.txt")
+	if err != nil {
+		t.Fatalf("an unreadable directory failed the glob: %v", err)
+	}
+	if len(matches) == 0 || matches[0] != filepath.Join("src", "a.txt") {
+		t.Errorf("glob matched %v", matches)
+	}
+}
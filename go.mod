@@ -2,4 +2,14 @@ module go-zstd-compressor
 
 go 1.25.0
 
-require github.com/klauspost/compress v1.18.0
+require (
+	filippo.io/age v1.2.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.12
+)
+
+require (
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupSimilarRatioAndStability(t *testing.T) {
+	// Files of one extension share a random body, so they only compress
+	// against each other when they're close enough in the stream
+	rng := rand.New(rand.NewSource(1))
+	bodies := map[string][]byte{}
+	for _, ext := range []string{".log", ".csv", ".bin"} {
+		body := make([]byte, 400<<10)
+		rng.Read(body)
+		bodies[ext] = body
+	}
+	files := map[string]string{}
+	for i := range 3 {
+		for ext, body := range bodies {
+			files[fmt.Sprintf("%d%s", i, ext)] = string(body[:len(body)-i])
+		}
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFiles(t, input, files, testMtime)
+
+	compress := func(output string, grouped bool) (*CompressionStats, string) {
+		t.Helper()
+		output = filepath.Join(dir, output)
+		stats, err := compressFiles(CompressRequest{Files: []string{input}, Output: output, Level: 3, GroupSimilar: grouped})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats, readTestFile(t, output)
+	}
+
+	unordered, _ := compress("unordered.tar.zst", false)
+	grouped, first := compress("grouped.tar.zst", true)
+	t.Logf("unordered %d bytes, grouped %d bytes", unordered.CompressedSize, grouped.CompressedSize)
+	if grouped.CompressedSize > unordered.CompressedSize {
+		t.Errorf("grouped archive is %d bytes, larger than the unordered %d", grouped.CompressedSize, unordered.CompressedSize)
+	}
+	if _, second := compress("again.tar.zst", true); first != second {
+		t.Error("grouping the same input twice produced different archives")
+	}
+}
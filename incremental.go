@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// baseRecord is the global PAX record naming an incremental archive's base
+	baseRecord = provenancePrefix + "base"
+
+	// baseHashRecord marks an entry stored only as a reference to the
+	// identical file, with this SHA-256, in the base archive
+	baseHashRecord = provenancePrefix + "base-sha256"
+)
+
+// baseEntry describes a file stored in a base archive
+type baseEntry struct {
+	size int64
+	hash string
+}
+
+// loadBaseEntries hashes every file stored in baseArchive. References the
+// base itself makes to its own base are left out, since it doesn't hold them.
+func loadBaseEntries(baseArchive string) (map[string]baseEntry, error) {
+	entries := make(map[string]baseEntry)
+
+	err := walkArchive(baseArchive, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag != tar.TypeReg || header.PAXRecords[baseHashRecord] != "" {
+			return nil
+		}
+
+		hasher := sha256.New()
+		n, err := io.Copy(hasher, body)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", header.Name, err)
+		}
+
+		entries[header.Name] = baseEntry{size: n, hash: hex.EncodeToString(hasher.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base archive: %v", err)
+	}
+
+	return entries, nil
+}
+
+// writeBaseReference writes entry as a reference when the base archive holds
+// an identical file under the same name, and reports whether it did.
+func (b *tarBuilder) writeBaseReference(entry tarEntry) (bool, error) {
+	if b.base == nil || !entry.local {
+		return false, nil
+	}
+
+	base, ok := b.base[entry.header.Name]
+	if !ok || base.size != entry.header.Size {
+		return false, nil
+	}
+
+	// Any error here is reported when the file is stored normally instead
+	info, err := os.Stat(entry.path)
+	if err != nil {
+		return false, nil
+	}
+	hash, err := fileHash(entry.path, info)
+	if err != nil || hash != base.hash {
+		return false, nil
+	}
+
+	header := *entry.header
+	header.Size = 0
+	header.PAXRecords = map[string]string{baseHashRecord: hash}
+	if err := b.tarWriter.WriteHeader(&header); err != nil {
+		return true, err
+	}
+
+	b.baseReferences = append(b.baseReferences, header.Name)
+	b.logger.Printf("Referenced unchanged %s from the base archive", header.Name)
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIncrementalStoresOnlyChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFiles(t, input, map[string]string{"a.txt": "alpha", "b.txt": "bravo", "c.txt": "charlie"})
+
+	base := filepath.Join(dir, "base.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: base, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, input, map[string]string{"b.txt": "bravo, changed"})
+	archive := filepath.Join(dir, "incremental.tar.zst")
+	stats, err := compressFiles(CompressRequest{Files: []string{input}, Output: archive, Level: 3, BaseArchive: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(stats.BaseReferences)
+	if want := []string{"input/a.txt", "input/c.txt"}; !slices.Equal(stats.BaseReferences, want) {
+		t.Errorf("references are %v, want %v", stats.BaseReferences, want)
+	}
+
+	// Only the changed file's contents are stored
+	var stored []string
+	err = walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		data, err := io.ReadAll(body)
+		if header.Typeflag == tar.TypeReg && header.PAXRecords[baseHashRecord] == "" {
+			stored = append(stored, header.Name+"="+string(data))
+		} else if len(data) > 0 {
+			t.Errorf("reference %s carries %d bytes", header.Name, len(data))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"input/b.txt=bravo, changed"}; !slices.Equal(stored, want) {
+		t.Errorf("stored %v, want %v", stored, want)
+	}
+
+	// Extraction pulls the unchanged files from the base
+	output := filepath.Join(dir, "output")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"a.txt": "alpha", "b.txt": "bravo, changed", "c.txt": "charlie"} {
+		if got := readTestFile(t, filepath.Join(output, "input", name)); got != body {
+			t.Errorf("%s holds %q, want %q", name, got, body)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobLogSummarizesCompression(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "aaaa", "in/b.txt": "bb"})
+	logFile := "compress.log"
+
+	_, err := compressFiles(CompressRequest{
+		Files:   []string{filepath.Join(dir, "in")},
+		Output:  filepath.Join(dir, "in.tar.zst"),
+		Level:   3,
+		LogFile: logFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged := readTestFile(t, logFile)
+	for _, line := range []string{
+		"Compressing 1 input(s)",
+		"a.txt (4 bytes)",
+		"b.txt (2 bytes)",
+		"Compression completed: original size 6 bytes",
+	} {
+		if !strings.Contains(logged, line) {
+			t.Errorf("log lacks %q:\n%s", line, logged)
+		}
+	}
+}
+
+func TestJobLogSummarizesDecompression(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	archive := filepath.Join(dir, "in.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "a.txt", body: "aaaa"})
+	logFile := "decompress.log"
+
+	_, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: "out", LogFile: logFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged := readTestFile(t, logFile)
+	for _, line := range []string{"Decompressing " + archive, "Extracted a.txt (4 bytes)", "Decompression completed: extracted 1 files"} {
+		if !strings.Contains(logged, line) {
+			t.Errorf("log lacks %q:\n%s", line, logged)
+		}
+	}
+}
+
+func TestJobLogStaysInsideWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	writeTestFiles(t, work, map[string]string{"in/a.txt": "aaaa", "in.tar.zst": "not a log"})
+	t.Chdir(work)
+
+	for _, logFile := range []string{"../x.log", filepath.Join(dir, "abs.log"), "/etc/x", "in.tar.zst"} {
+		_, err := compressFiles(CompressRequest{Files: []string{"in"}, Output: "out.tar.zst", Level: 3, LogFile: logFile})
+		if err == nil {
+			t.Errorf("log file %s was accepted", logFile)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "x.log")); !os.IsNotExist(err) {
+		t.Errorf("log written outside the working directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abs.log")); !os.IsNotExist(err) {
+		t.Errorf("log written to an absolute path: %v", err)
+	}
+	if got := readTestFile(t, filepath.Join(work, "in.tar.zst")); got != "not a log" {
+		t.Errorf("existing file was overwritten: %q", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUltraLevelCompressesBetter(t *testing.T) {
+	// Text built from a small vocabulary compresses well, with more to gain
+	// from searching harder
+	rng := rand.New(rand.NewSource(1))
+	words := strings.Fields("the quick brown fox jumps over lazy dog archive frame level ratio stream")
+	var text strings.Builder
+	for text.Len() < 1<<20 {
+		text.WriteString(words[rng.Intn(len(words))])
+		text.WriteByte(' ')
+	}
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"payload.txt": text.String()})
+
+	compress := func(level int) CompressionStats {
+		t.Helper()
+		resp := postCompress(t, CompressRequest{Files: []string{filepath.Join(dir, "payload.txt")}, Output: filepath.Join(dir, "payload.tar.zst"), Level: level})
+		if !resp.Success {
+			t.Fatalf("level %d failed: %s", level, resp.Message)
+		}
+		return resp.Data
+	}
+
+	fast, ultra := compress(3), compress(22)
+	if ultra.Level != 22 {
+		t.Errorf("level 22 reported as %d", ultra.Level)
+	}
+	if ultra.CompressedSize >= fast.CompressedSize {
+		t.Errorf("level 22 gave %d bytes, no smaller than level 3's %d", ultra.CompressedSize, fast.CompressedSize)
+	}
+
+	// Out of range levels fall back to the default, and say so
+	if stats := compress(23); stats.Level != 3 {
+		t.Errorf("level 23 reported as %d, want the default 3", stats.Level)
+	}
+}
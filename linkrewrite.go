@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkRewrite returns the target to archive for a symlink. linkPath is
+// the link's absolute path on disk and root the absolute path of the input
+// it was found under.
+type SymlinkRewrite func(target, linkPath, root string) string
+
+// LinkRewrite records a symlink whose target was rewritten
+type LinkRewrite struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// parseSymlinkRewrite returns the built-in rewrite named by spec:
+// "relative", which makes absolute targets inside the input relative to the
+// link, or "prefix:OLD=NEW", which replaces the leading OLD path of a target
+// with NEW.
+func parseSymlinkRewrite(spec string) (SymlinkRewrite, error) {
+	switch {
+	case spec == "relative":
+		return func(target, linkPath, root string) string {
+			if !filepath.IsAbs(target) {
+				return target
+			}
+			target = filepath.Clean(target)
+			if target != root && !strings.HasPrefix(target, root+string(filepath.Separator)) {
+				return target
+			}
+			rel, err := filepath.Rel(filepath.Dir(linkPath), target)
+			if err != nil {
+				return target
+			}
+			return filepath.ToSlash(rel)
+		}, nil
+
+	case strings.HasPrefix(spec, "prefix:"):
+		from, to, ok := strings.Cut(strings.TrimPrefix(spec, "prefix:"), "=")
+		from = strings.TrimRight(from, "/")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("prefix rewrite needs OLD=NEW")
+		}
+		return func(target, linkPath, root string) string {
+			if target != from && !strings.HasPrefix(target, from+"/") {
+				return target
+			}
+			return to + strings.TrimPrefix(target, from)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown symlink rewrite %q", spec)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelativeSymlinkRewrite(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTestFiles(t, root, map[string]string{"data/file.txt": "linked"})
+	outside := filepath.Join(dir, "outside.txt")
+	writeTestFiles(t, dir, map[string]string{"outside.txt": "outside"})
+	if err := os.Mkdir(filepath.Join(root, "links"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "data", "file.txt"), filepath.Join(root, "links", "abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "links", "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "links.tar.zst")
+	req := CompressRequest{Files: []string{root}, Output: archive, Level: 3, SymlinkRewriteSpec: "relative"}
+	if _, err := prepareCompressRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{}
+	err = walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeSymlink {
+			links[header.Name] = header.Linkname
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := links["root/links/abs"]; got != "../data/file.txt" {
+		t.Errorf("link inside the root points to %q, want ../data/file.txt", got)
+	}
+	if got := links["root/links/out"]; got != outside {
+		t.Errorf("link outside the root points to %q, want it unchanged", got)
+	}
+
+	// The rewrite is recorded
+	if len(stats.RewrittenLinks) != 1 || stats.RewrittenLinks[0].Name != "root/links/abs" || stats.RewrittenLinks[0].To != "../data/file.txt" {
+		t.Errorf("rewrites recorded as %+v", stats.RewrittenLinks)
+	}
+
+	// The relative link resolves wherever the archive is extracted
+	output := filepath.Join(dir, "moved")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(output, "root", "links", "abs")); got != "linked" {
+		t.Errorf("extracted link reads %q", got)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListArchiveCSV(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), `my "files", listed.tar.zst`)
+	writeTestArchive(t, archive,
+		testEntry{name: "plain.txt", body: "abc"},
+		testEntry{name: "with, comma.txt", body: "a"},
+		testEntry{name: `with "quotes".txt`, body: ""},
+	)
+
+	for _, format := range []string{"csv", "tsv"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/list-archive?format="+format+"&archive="+url.QueryEscape(archive), nil)
+		rec := httptest.NewRecorder()
+		handleListArchive(rec, req)
+
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, map[string]string{"csv": "text/csv", "tsv": "text/tab-separated-values"}[format]) {
+			t.Errorf("%s: Content-Type is %s", format, got)
+		}
+		want := `attachment; filename="my \"files\", listed.` + format + `"`
+		if got := rec.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("%s: Content-Disposition is %s, want %s", format, got, want)
+		}
+
+		reader := csv.NewReader(rec.Body)
+		if format == "tsv" {
+			reader.Comma = '\t'
+		}
+		records, err := reader.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var rows [][]string
+		for _, record := range records {
+			rows = append(rows, []string{record[0], record[1], record[4]})
+		}
+		wantRows := [][]string{
+			{"name", "size", "type"},
+			{"plain.txt", "3", "file"},
+			{"with, comma.txt", "1", "file"},
+			{`with "quotes".txt`, "0", "file"},
+		}
+		if !reflect.DeepEqual(rows, wantRows) {
+			t.Errorf("%s: rows are %v, want %v", format, rows, wantRows)
+		}
+	}
+}
+
+func listArchiveHashes(t *testing.T, archive string) map[string]ArchiveListEntry {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handleListArchive(rec, httptest.NewRequest(http.MethodGet, "/api/list-archive?hashes=true&archive="+url.QueryEscape(archive), nil))
+	var resp struct {
+		Success bool               `json:"success"`
+		Message string             `json:"message"`
+		Data    []ArchiveListEntry `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Fatalf("listing failed: %s (%v)", rec.Body.String(), err)
+	}
+
+	entries := map[string]ArchiveListEntry{}
+	for _, entry := range resp.Data {
+		entries[entry.Name] = entry
+	}
+	return entries
+}
+
+func TestListArchiveHashes(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "hashed.tar.zst")
+	// Zeros compress to almost nothing, so the file over the cap is cheap
+	huge := int64(listHashMaxFileSize + 1)
+	entries := []ReaderEntry{
+		{Name: "small.txt", Size: 7, R: strings.NewReader("hash me")},
+		{Name: "huge.bin", Size: huge, R: io.LimitReader(zeroReader{}, huge)},
+	}
+	if _, err := compressReaders(entries, CompressRequest{Output: archive, Level: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := listArchiveHashes(t, archive)
+	// sha256 of "hash me"
+	if got := listed["small.txt"]; got.SHA256 != "eb201af5aaf0d60629d3d2a61e466cfc0fedb517add831ecac5235e1daa963d6" || got.HashSkipped {
+		t.Errorf("small.txt listed as %+v", got)
+	}
+	if got := listed["huge.bin"]; got.SHA256 != "" || !got.HashSkipped {
+		t.Errorf("file over the cap listed as %+v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	handleListArchive(rec, httptest.NewRequest(http.MethodGet, "/api/list-archive?archive="+url.QueryEscape(archive), nil))
+	if strings.Contains(rec.Body.String(), "sha256") || strings.Contains(rec.Body.String(), "hashSkipped") {
+		t.Errorf("listed with hashes without hashes=true: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleListArchive(rec, httptest.NewRequest(http.MethodGet, "/api/list-archive?hashes=true&recursive=1&archive="+url.QueryEscape(archive), nil))
+	if !strings.Contains(rec.Body.String(), "Hashes can't be combined") {
+		t.Errorf("recursive listing with hashes got %s", rec.Body.String())
+	}
+}
+
+// The references of an incremental archive have no contents to hash, but
+// record the hash of the file they stand for
+func TestListArchiveHashesOfReferences(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	writeTestFiles(t, input, map[string]string{"same.txt": "hash me"})
+	base := filepath.Join(dir, "base.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: base, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "incremental.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: archive, Level: 3, BaseArchive: base}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := listArchiveHashes(t, archive)["in/same.txt"]; got.SHA256 != "eb201af5aaf0d60629d3d2a61e466cfc0fedb517add831ecac5235e1daa963d6" {
+		t.Errorf("reference listed as %+v", got)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listFiles calls /api/list-files with query and decodes the response
+func listFiles(t *testing.T, query string) Response {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handleListFiles(rec, httptest.NewRequest(http.MethodGet, "/api/list-files?"+query, nil))
+
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+// makeListingDir creates files of different sizes and times to list
+func makeListingDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{"b.txt": "bb", "a.txt": "aaaa", "d.txt": "", "c.txt": "c"}
+	writeTestFiles(t, dir, files)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"c.txt", "a.txt", "d.txt", "b.txt", "sub"} {
+		when := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, name), when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// listedNames returns the names listed in resp, in order
+func listedNames(t *testing.T, resp Response) []string {
+	t.Helper()
+
+	if !resp.Success {
+		t.Fatalf("listing failed: %s", resp.Message)
+	}
+	var names []string
+	for _, file := range resp.Data.(map[string]interface{})["files"].([]interface{}) {
+		names = append(names, file.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestListFilesHashes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"small.txt": "hash me"})
+	// A sparse file over the cap, so the test doesn't write it out
+	huge, err := os.Create(filepath.Join(dir, "huge.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := huge.Truncate(listHashMaxFileSize + 1); err != nil {
+		t.Fatal(err)
+	}
+	huge.Close()
+
+	files := map[string]map[string]interface{}{}
+	for _, file := range listFiles(t, "hashes=true&path="+dir).Data.(map[string]interface{})["files"].([]interface{}) {
+		file := file.(map[string]interface{})
+		files[file["name"].(string)] = file
+	}
+
+	// sha256 of "hash me"
+	if got := files["small.txt"]["sha256"]; got != "eb201af5aaf0d60629d3d2a61e466cfc0fedb517add831ecac5235e1daa963d6" {
+		t.Errorf("small.txt hashed as %v", got)
+	}
+	if _, ok := files["huge.bin"]["sha256"]; ok || files["huge.bin"]["hashSkipped"] != true {
+		t.Errorf("file over the cap listed as %v", files["huge.bin"])
+	}
+
+	for _, file := range listFiles(t, "path="+dir).Data.(map[string]interface{})["files"].([]interface{}) {
+		if _, ok := file.(map[string]interface{})["sha256"]; ok {
+			t.Errorf("%v listed with a hash without hashes=true", file)
+		}
+	}
+}
+
+func TestListFilesIsCapped(t *testing.T) {
+	dir := makeListingDir(t)
+
+	old := maxListEntries
+	maxListEntries = 3
+	defer func() { maxListEntries = old }()
+
+	// Asking for more than the cap still gets the cap
+	resp := listFiles(t, "limit=100&path="+dir)
+	names := listedNames(t, resp)
+	data := resp.Data.(map[string]interface{})
+	if len(names) != 4 || data["truncated"] != true || data["limit"] != float64(3) || data["total"] != float64(5) {
+		t.Errorf("capped listing has %v, truncated %v, limit %v, total %v", names, data["truncated"], data["limit"], data["total"])
+	}
+
+	// The next page picks up where the cap stopped
+	resp = listFiles(t, "offset=3&path="+dir)
+	if names := listedNames(t, resp); len(names) != 3 || names[1] != "d.txt" || resp.Data.(map[string]interface{})["truncated"] != false {
+		t.Errorf("second page has %v, truncated %v", names, resp.Data.(map[string]interface{})["truncated"])
+	}
+}
+
+func TestListFilesStreamMatches(t *testing.T) {
+	dir := makeListingDir(t)
+
+	for _, query := range []string{
+		"sortBy=name",
+		"order=asc&hashes=true",
+		"sortBy=size&order=desc",
+		"sortBy=modTime",
+		"sortBy=name&offset=1&limit=2",
+		"sortBy=name&page=2&pageSize=2",
+		"sortBy=name&offset=10",
+	} {
+		query := "path=" + dir + "&" + query
+		listed := listFiles(t, query)
+		streamed := listFiles(t, query+"&stream=true")
+		if !listed.Success {
+			t.Fatalf("%s: %s", query, listed.Message)
+		}
+		if !reflect.DeepEqual(listed, streamed) {
+			t.Errorf("%s: streamed listing differs\nlisted:   %+v\nstreamed: %+v", query, listed, streamed)
+		}
+	}
+}
+
+func TestListFilesStreamIsCapped(t *testing.T) {
+	dir := makeListingDir(t)
+
+	old := maxListEntries
+	maxListEntries = 3
+	defer func() { maxListEntries = old }()
+
+	for _, query := range []string{"", "sortBy=name"} {
+		streamed := listFiles(t, query+"&stream=true&path="+dir)
+		data := streamed.Data.(map[string]interface{})
+		if files := data["files"].([]interface{}); len(files) != 4 || data["truncated"] != true || data["total"] != float64(5) {
+			t.Errorf("%s: capped stream has %d files (with ..), truncated %v, total %v", query, len(files), data["truncated"], data["total"])
+		}
+	}
+	if listed, streamed := listFiles(t, "path="+dir), listFiles(t, "sortBy=name&stream=true&path="+dir); !reflect.DeepEqual(listed, streamed) {
+		t.Errorf("capped listings differ")
+	}
+}
+
+// With no sort, a stream reads the directory in batches and pages through
+// it in directory order
+func TestListFilesStreamUnsorted(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{".."}
+	for i := range streamDirectoryBatch + 5 {
+		name := fmt.Sprintf("f%04d", i)
+		writeTestFiles(t, dir, map[string]string{name: ""})
+		want = append(want, name)
+	}
+
+	listed := listFiles(t, "path="+dir)
+	streamed := listFiles(t, "stream=true&path="+dir)
+	names := listedNames(t, streamed)
+	slices.Sort(names)
+	if !slices.Equal(names, want) {
+		t.Errorf("unsorted stream listed %d names, want %d", len(names), len(want))
+	}
+	if data := streamed.Data.(map[string]interface{}); data["total"] != listed.Data.(map[string]interface{})["total"] || data["truncated"] != false {
+		t.Errorf("unsorted stream has total %v, truncated %v", data["total"], data["truncated"])
+	}
+
+	// Pages don't overlap and together cover the directory
+	var paged []string
+	for page := 1; ; page++ {
+		resp := listFiles(t, fmt.Sprintf("stream=true&pageSize=400&page=%d&path=%s", page, dir))
+		paged = append(paged, listedNames(t, resp)[1:]...)
+		if resp.Data.(map[string]interface{})["truncated"] != true {
+			break
+		}
+	}
+	slices.Sort(paged)
+	if !slices.Equal(paged, want[1:]) {
+		t.Errorf("unsorted pages listed %d names, want %d", len(paged), len(want)-1)
+	}
+}
+
+func TestListFilesStreamError(t *testing.T) {
+	resp := listFiles(t, "stream=true&path="+filepath.Join(t.TempDir(), "missing"))
+	if resp.Success || !strings.Contains(resp.Message, "Failed to list directory") {
+		t.Errorf("got %+v for a missing directory", resp)
+	}
+}
+
+func TestListFilesSorts(t *testing.T) {
+	dir := makeListingDir(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"..", "a.txt", "b.txt", "c.txt", "d.txt", "sub"}},
+		{"sortBy=name&order=desc", []string{"..", "sub", "d.txt", "c.txt", "b.txt", "a.txt"}},
+		{"sortBy=modTime", []string{"..", "c.txt", "a.txt", "d.txt", "b.txt", "sub"}},
+		{"sortBy=modTime&order=desc", []string{"..", "sub", "b.txt", "d.txt", "a.txt", "c.txt"}},
+		// A directory's size depends on the filesystem, so it's left out
+		{"sortBy=size", []string{"..", "d.txt", "c.txt", "b.txt", "a.txt"}},
+		{"sortBy=size&order=desc", []string{"..", "a.txt", "b.txt", "c.txt", "d.txt"}},
+	}
+	for _, test := range tests {
+		names := listedNames(t, listFiles(t, "path="+dir+"&"+test.query))
+		if strings.Contains(test.query, "size") {
+			names = slices.DeleteFunc(names, func(name string) bool { return name == "sub" })
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("%q listed %v, want %v", test.query, names, test.want)
+		}
+	}
+}
+
+func TestListFilesLastPartialPage(t *testing.T) {
+	dir := makeListingDir(t)
+
+	resp := listFiles(t, "path="+dir+"&page=3&pageSize=2")
+	if names := listedNames(t, resp); !reflect.DeepEqual(names, []string{"..", "sub"}) {
+		t.Errorf("last page listed %v, want .. and sub", names)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["page"] != float64(3) || data["pageSize"] != float64(2) || data["total"] != float64(5) {
+		t.Errorf("last page reports page %v, pageSize %v, total %v", data["page"], data["pageSize"], data["total"])
+	}
+
+	if names := listedNames(t, listFiles(t, "path="+dir+"&page=4&pageSize=2")); !reflect.DeepEqual(names, []string{".."}) {
+		t.Errorf("page past the end listed %v", names)
+	}
+}
+
+func TestListFilesLimitAndPageSizeConflict(t *testing.T) {
+	dir := makeListingDir(t)
+
+	if resp := listFiles(t, "path="+dir+"&limit=2&pageSize=3"); resp.Success {
+		t.Error("limit and pageSize together were accepted")
+	}
+	for _, query := range []string{"limit=2", "pageSize=2"} {
+		if names := listedNames(t, listFiles(t, "path="+dir+"&"+query)); len(names) != 3 {
+			t.Errorf("%s listed %v", query, names)
+		}
+	}
+}
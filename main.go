@@ -3,35 +3,481 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
-	"io/ioutil"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"os/user"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"filippo.io/age"
 	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 //go:embed frontend/*
 var embeddedFrontend embed.FS
 
+// supportedFormats lists the archive formats compressFiles can produce:
+// "zstd" is a zstd-compressed tar, "gzip" a gzip-compressed tar for tools
+// without zstd support, and "zip" a zip with zstd-compressed entries
+var supportedFormats = []string{"zstd", "gzip", "zip"}
+
+// defaultFormat is used for compress requests that don't specify a format
+var defaultFormat = "zstd"
+
+// maxListEntries caps how many entries one /api/list-files page returns
+var maxListEntries = 10000
+
+// stripSetuid, set with -strip-setuid, clears the setuid and setgid bits of
+// everything /api/decompress extracts, whatever the request asks
+var stripSetuid bool
+
+// allowRestoreToRoot, set with -allow-restore-to-root, lets API callers use
+// the "restore-to-root" absolute path policy, which writes anywhere the
+// server can. It's off so uploaded archives stay inside their output.
+var allowRestoreToRoot bool
+
+// version identifies the build; set it with -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
 type CompressRequest struct {
-	Files  []string `json:"files"`
-	Output string   `json:"output"`
-	Level  int      `json:"level"`
+	Files   []string `json:"files"`
+	Output  string   `json:"output"`
+	Level   int      `json:"level"`
+	LogFile string   `json:"logFile"`
+
+	// Glob is expanded on the server and its matches are added to Files.
+	// A "**" segment matches any number of directories.
+	Glob string `json:"glob"`
+
+	// Directory archives a whole directory tree. Its entries are stored under
+	// RootName (the directory's base name by default), or directly at the
+	// archive root when FlattenRoot is set.
+	Directory   string `json:"directory"`
+	RootName    string `json:"rootName"`
+	FlattenRoot bool   `json:"flattenRoot"`
+
+	// Entries adds sources under explicit names inside the archive,
+	// bypassing the names derived from Files.
+	Entries []ArchiveEntry `json:"entries"`
+
+	// Contents adds files posted inline as name and content pairs, and
+	// Readers (library only) files read from an io.Reader each. Both are
+	// written after the other inputs.
+	Contents []ContentEntry `json:"contents"`
+	Readers  []ReaderEntry  `json:"-"`
+
+	// NamePattern builds the output name when Output is empty, using the
+	// tokens {basename}, {date:2006-01-02}, {level}, {format} and {count}.
+	// Output may contain the same tokens.
+	NamePattern string `json:"namePattern"`
+
+	// Format selects the archive format; empty uses the server default
+	Format string `json:"format"`
+
+	// Measure runs the full compression but discards the output, reporting
+	// accurate stats without creating an archive.
+	Measure bool `json:"measure"`
+
+	// DryRun only walks the inputs and responds with the entries the
+	// archive would hold, without compressing or writing anything.
+	DryRun bool `json:"dryRun"`
+
+	// MaxEntries aborts the compression once the inputs yield more than
+	// this many archive entries.
+	MaxEntries int `json:"maxEntries"`
+
+	// OnReadError decides what happens when a file can't be read in full:
+	// "abort" (default) fails the compression, "skip-pad" pads the entry
+	// with zeros to its recorded size, "truncate-entry" stores only the
+	// bytes that were read.
+	OnReadError string `json:"onReadError"`
+
+	// SpecialFiles decides what happens to device nodes: "skip" (default)
+	// leaves them out with a warning, "devices" archives them as character
+	// and block devices. Sockets and other unsupported file types are always
+	// skipped with a warning; named pipes are archived.
+	SpecialFiles string `json:"specialFiles"`
+
+	// TarFormat is "pax" (default), "ustar" for maximum compatibility with
+	// old tools at the cost of name length, or "gnu".
+	TarFormat string `json:"tarFormat"`
+
+	// OnDuplicate decides what happens when two inputs produce the same
+	// entry name: "overwrite" (default) keeps both and warns, leaving the
+	// later one to win on extraction, "rename" stores the later one as
+	// name-1.ext, name-2.ext, ..., and "error" aborts the compression.
+	OnDuplicate string `json:"onDuplicate"`
+
+	// MaxEntriesPerVolume splits the archive into volumes of at most this
+	// many entries each, named name.vol001.zst, name.vol002.zst, ..., plus a
+	// name.index.json listing the entries of every volume. Extracting the
+	// index extracts all volumes.
+	MaxEntriesPerVolume int `json:"maxEntriesPerVolume"`
+
+	// OnProgress, if set, receives progress updates while the archive is
+	// written: after every file and at least once a second.
+	OnProgress func(Progress) `json:"-"`
+
+	// TwoPass scans the inputs before compressing so progress updates carry
+	// the total file count and size.
+	TwoPass bool `json:"twoPass"`
+
+	// Context, if set, aborts the compression once it's canceled. The
+	// partially written archive is removed, unless appending to one.
+	Context context.Context `json:"-"`
+
+	// LowPriority compresses on a single encoder goroutine at a lowered
+	// scheduling priority (Linux only), so backups don't starve other work.
+	LowPriority bool `json:"lowPriority"`
+
+	// MaxBytesPerSec caps how fast inputs are read, and separately how fast
+	// the archive is written, so backups don't saturate the disks. 0 is
+	// unlimited.
+	MaxBytesPerSec int64 `json:"maxBytesPerSec"`
+
+	// Dedupe stores the contents of small files (up to 1 MB) once each,
+	// under .dedupe/ in the archive, with every file holding those contents
+	// as a reference to them. Unlike hard links this works on any platform
+	// and across files that are merely identical. This tool restores the
+	// files on extraction; other tar tools see empty files and .dedupe/.
+	Dedupe bool `json:"dedupe"`
+
+	// ReportMemory samples the heap while compressing and reports its peak
+	// in the stats, to help size memory limits. Sampling has a small cost.
+	ReportMemory bool `json:"reportMemory"`
+
+	// WebhookURL, when set, is sent the response as a signed POST once the
+	// compression finishes, whether it succeeded or not
+	WebhookURL string `json:"webhookUrl"`
+
+	// Concurrency is the number of goroutines the zstd encoder compresses
+	// with; 0 uses GOMAXPROCS. It's capped at twice the number of CPUs.
+	Concurrency int `json:"concurrency"`
+
+	// Deadline stops adding files once it passes and finalizes the archive
+	// with whatever was added so far, flagging the result as partial.
+	Deadline time.Time `json:"deadline"`
+
+	// MaxDuration is a deadline relative to the start of the compression,
+	// set by API callers as a duration string such as "30m" with
+	// MaxDurationSpec. The earlier of the two applies.
+	MaxDuration     time.Duration `json:"-"`
+	MaxDurationSpec string        `json:"maxDuration"`
+
+	// Sync fsyncs the archive and its directory before reporting success
+	Sync bool `json:"sync"`
+
+	// Checksum writes the archive's SHA-256 to a name.sha256 file next to
+	// it, which /api/verify checks the archive against later.
+	Checksum bool `json:"checksum"`
+
+	// Recipients encrypts the archive with age to these public keys
+	// (age1...), so only the holders of the matching identities can read
+	// it. The archive gets a .age extension, as with Password, which it
+	// can't be combined with. Not available for zip, framed or split
+	// archives.
+	Recipients []string `json:"recipients"`
+
+	// Dictionary is a zstd dictionary from /api/train-dict to compress
+	// with, which helps most with many small, similar files. Every zstd
+	// frame records the dictionary's ID, and extracting the archive needs
+	// the same dictionary. Only for zstd archives.
+	Dictionary string `json:"dictionary"`
+
+	// OutputSizeHint is the expected archive size, from a previous run or
+	// an estimate. On Linux that much space is reserved for the output up
+	// front to reduce fragmentation; unused space is released at the end.
+	OutputSizeHint int64 `json:"outputSizeHint"`
+
+	// ClampMtime caps every entry's modification time at this time, the
+	// SOURCE_DATE_EPOCH convention for reproducible builds: newer files get
+	// the clamp time, older files keep their own.
+	ClampMtime time.Time `json:"clampMtime"`
+
+	// Provenance records the creating user, time and tool version in a
+	// global PAX header of tar archives; ProvenanceHostname also records
+	// the machine's hostname. /api/info reads them back.
+	Provenance         bool `json:"provenance"`
+	ProvenanceHostname bool `json:"provenanceHostname"`
+
+	// BaseArchive makes the archive incremental: files identical (by SHA-256)
+	// to the same entry in the base archive are stored as references only,
+	// and extraction pulls them from the base. Needs the pax tar format.
+	BaseArchive string `json:"baseArchive"`
+
+	// NameTransform rewrites or drops entry names as they are added.
+	// API callers pick a built-in with NameTransformSpec ("lowercase" or
+	// "strip-prefix:PREFIX").
+	NameTransform     NameTransform `json:"-"`
+	NameTransformSpec string        `json:"nameTransform"`
+
+	// SymlinkRewrite rewrites the targets of symlinks found in the inputs,
+	// so archives of trees with absolute links can be moved. API callers
+	// pick a built-in with SymlinkRewriteSpec ("relative" or
+	// "prefix:OLD=NEW").
+	SymlinkRewrite     SymlinkRewrite `json:"-"`
+	SymlinkRewriteSpec string         `json:"symlinkRewrite"`
+
+	// EntryFilter drops entries found on disk based on their headers, just
+	// before they're added. API callers combine built-ins with
+	// EntryFilterSpecs ("max-size:N", "pattern:GLOB" or "no-hidden"); an
+	// entry is kept only if every filter keeps it.
+	EntryFilter      EntryFilter `json:"-"`
+	EntryFilterSpecs []string    `json:"entryFilters"`
+
+	// SlowestFiles reports this many files that took longest to read and
+	// compress, to find slow mounts or pathological files.
+	SlowestFiles int `json:"slowestFiles"`
+
+	// PerFile lists every file added with its size and its share of the
+	// original size, to find the files that dominate an archive. The
+	// archive is one compressed stream, so there are no per-file
+	// compressed sizes.
+	PerFile bool `json:"perFile"`
+
+	// SortEntries writes entries from all inputs in name order, so the same
+	// inputs produce the same archive regardless of their order in Files.
+	SortEntries bool `json:"sortEntries"`
+
+	// TarIgnore leaves out files matched by .tarignore files found in the
+	// input directories. Each applies gitignore-style patterns, including
+	// ! negation, to its own directory and everything below it.
+	TarIgnore bool `json:"tarIgnore"`
+
+	// Exclude leaves out what these patterns match, with .tarignore syntax
+	// ("*.tmp", "node_modules/", "src/**/gen"). Patterns with a slash are
+	// relative to each input, or to the working directory for glob matches.
+	// Excluded directories aren't walked at all.
+	Exclude []string `json:"exclude"`
+
+	// GroupSimilar writes directories and links first, then files grouped
+	// by extension (in name order within a group), so similar contents sit
+	// next to each other and compress better across files.
+	GroupSimilar bool `json:"groupSimilar"`
+
+	// StateFile records a fingerprint of the archived inputs (entry names,
+	// sizes and modification times) after each successful run. With
+	// SkipIfUnchanged, a run whose inputs match the recorded fingerprint is
+	// skipped and the existing archive is left in place.
+	StateFile       string `json:"stateFile"`
+	SkipIfUnchanged bool   `json:"skipIfUnchanged"`
+
+	// Frames compresses every input as its own zstd frame, followed by an
+	// index of the frames, so the archive still decodes as one tar. With
+	// Append, the inputs are added as new frames to an existing framed
+	// archive at Output instead of replacing it.
+	Frames bool `json:"frames"`
+
+	// Append adds the inputs to the existing archive at Output. A framed
+	// archive gets them as new frames; any other zstd, gzip or zip archive
+	// is rewritten, its entries copied unchanged ahead of the inputs, and
+	// keeps its format.
+	Append bool `json:"append"`
+
+	// Password encrypts the archive with age, as "age -p" would, adding
+	// .age to its name. API callers may send it in the X-Archive-Password
+	// header instead.
+	Password string `json:"password"`
+}
+
+// ArchiveEntry maps a source path on disk to its name inside the archive. A
+// directory source is stored with Target as its root.
+type ArchiveEntry struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
 }
 
 type DecompressRequest struct {
 	Archive   string `json:"archive"`
 	OutputDir string `json:"outputDir"`
+	LogFile   string `json:"logFile"`
+
+	// MaxEntrySize caps the size of any single extracted file. Entries over
+	// the limit are skipped, or abort the extraction when EntrySizePolicy is
+	// "abort".
+	MaxEntrySize    int64  `json:"maxEntrySize"`
+	EntrySizePolicy string `json:"entrySizePolicy"`
+
+	// MaxTotalSize aborts the extraction once the extracted files would
+	// exceed this many bytes in total.
+	MaxTotalSize int64 `json:"maxTotalSize"`
+
+	// Entries restricts extraction to the named entries (and everything
+	// below them when an entry is a directory). Empty extracts everything.
+	Entries []string `json:"entries"`
+
+	// ExtractTypes restricts extraction to files of these types: extensions
+	// (".txt" or "txt"), MIME types ("application/pdf") or MIME groups
+	// ("text" or "image/*"). Files without a known extension are matched by
+	// their contents. Directories aren't extracted on their own, only as the
+	// parents of matching files.
+	ExtractTypes []string `json:"extractTypes"`
+
+	// Unique extracts into name-1, name-2, ... when the output directory
+	// already exists, instead of replacing it.
+	Unique bool `json:"unique"`
+
+	// MaxExtractedFileSize splits files larger than this many bytes into
+	// name.part001, name.part002, ... plus a name.parts.json manifest, for
+	// target filesystems that can't hold them whole (FAT32's 4GB limit).
+	MaxExtractedFileSize int64 `json:"maxExtractedFileSize"`
+
+	// FS receives the extracted files; nil writes to the OS filesystem
+	FS ExtractFS `json:"-"`
+
+	// OnProgress, if set, receives progress updates during extraction:
+	// after every file and at least once a second.
+	OnProgress func(Progress) `json:"-"`
+
+	// NameTransform rewrites or drops entry names before they are
+	// extracted; NameTransformSpec selects a built-in as for compression.
+	NameTransform     NameTransform `json:"-"`
+	NameTransformSpec string        `json:"nameTransform"`
+
+	// BaseArchive is where the unchanged files of an incremental archive
+	// come from; empty uses the base named in the archive, next to it.
+	BaseArchive string `json:"baseArchive"`
+
+	// TwoPass scans the archive before extracting so progress updates carry
+	// the total file count and size. It reads the archive twice.
+	TwoPass bool `json:"twoPass"`
+
+	// Context, if set, aborts the extraction once it's canceled. The file
+	// being written at that point is removed.
+	Context context.Context `json:"-"`
+
+	// ExistingFiles extracts into an existing output directory instead of
+	// replacing it: "overwrite" replaces files that are already there,
+	// "skip" keeps them and skips their entries.
+	ExistingFiles string `json:"existingFiles"`
+
+	// Detailed reports every entry: where it went, its size and mode, and
+	// whether it was created, overwrote a file, or was skipped and why.
+	Detailed bool `json:"detailed"`
+
+	// Identity holds the age secret keys (AGE-SECRET-KEY-1...), one per
+	// line, to decrypt an archive encrypted to recipients.
+	Identity string `json:"identity"`
+
+	// Dictionaries are the zstd dictionaries an archive may have been
+	// compressed with; the one whose ID the archive records is used.
+	Dictionaries []string `json:"dictionaries"`
+
+	// NameCharset is a regular expression matching one allowed character of
+	// entry names, such as `[A-Za-z0-9._-]`. Entries with other characters
+	// are skipped (the default NameCharsetPolicy, "skip"), stored with each
+	// such character replaced by an underscore ("sanitize"), or abort the
+	// extraction ("abort").
+	NameCharset       string `json:"nameCharset"`
+	NameCharsetPolicy string `json:"nameCharsetPolicy"`
+
+	// Password decrypts an archive encrypted with a password; API callers
+	// may send it in the X-Archive-Password header instead.
+	Password string `json:"password"`
+
+	// AbsolutePathPolicy handles entries with absolute names, like
+	// /etc/hosts: "relocate" (the default) extracts them under the output
+	// directory, "reject" fails the extraction, and "restore-to-root" writes
+	// them to that absolute path; API requests may only use it when the
+	// server runs with -allow-restore-to-root. Symlinks are never restored
+	// outside the output directory.
+	AbsolutePathPolicy string `json:"absolutePathPolicy"`
+
+	// StripSetuid clears the setuid and setgid bits of extracted files and
+	// directories; the sticky bit is kept. The -strip-setuid flag sets it
+	// for every API extraction.
+	StripSetuid bool `json:"stripSetuid"`
+
+	// ExtractNested also extracts archives found inside the archive, each
+	// into a folder next to it named after it without its extension, down
+	// to NestedDepth levels (3 by default, at most 5). Nested archives count
+	// toward MaxTotalSize, or toward 1GB when that isn't set.
+	ExtractNested bool `json:"extractNested"`
+	NestedDepth   int  `json:"nestedDepth"`
+
+	// ReportMemory samples the heap while extracting and reports its peak
+	ReportMemory bool `json:"reportMemory"`
+
+	// WebhookURL, when set, is sent the response as a signed POST once the
+	// extraction finishes, whether it succeeded or not
+	WebhookURL string `json:"webhookUrl"`
+}
+
+// DecompressionStats sums up an extraction, the counterpart of
+// CompressionStats. ExtractedSize counts the bytes written to files, nested
+// archives included, and ExpansionRatio how many times larger than the
+// archive they are; it's 0 for an empty archive.
+type DecompressionStats struct {
+	ArchiveSize    int64   `json:"archiveSize"`
+	ExtractedSize  int64   `json:"extractedSize"`
+	ExpansionRatio float64 `json:"expansionRatio"`
+	FileCount      int     `json:"fileCount"`
+	Duration       string  `json:"duration"`
+	OutputDir      string  `json:"outputDir"`
+}
+
+type extractResult struct {
+	DecompressionStats
+	SkippedEntries []string
+	SplitFiles     []string
+	InvalidNames   []string // entries with characters outside NameCharset
+	TypeMatched    int      // files selected by ExtractTypes
+	TypeSkipped    int      // files ExtractTypes left out
+	Entries        []ExtractedEntry
+	NestedArchives []string // folders nested archives were extracted to
+	PeakHeapBytes  uint64   // with ReportMemory
+
+	extractedSize int64
+}
+
+// ExtractedEntry is one line of a detailed extraction report
+type ExtractedEntry struct {
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	Action string `json:"action"` // "created", "overwritten", "existing" (directories) or "skipped"
+	Reason string `json:"reason,omitempty"`
+}
+
+// splitManifest describes how to rejoin a file extracted in parts:
+// concatenate Parts in order to get Name back.
+type splitManifest struct {
+	Name  string   `json:"name"`
+	Size  int64    `json:"size"`
+	Parts []string `json:"parts"`
 }
 
 type Response struct {
@@ -41,11 +487,86 @@ type Response struct {
 }
 
 type CompressionStats struct {
-	OriginalSize     int64   `json:"originalSize"`
-	CompressedSize   int64   `json:"compressedSize"`
-	CompressionRatio float64 `json:"compressionRatio"`
-	Duration         string  `json:"duration"`
-	OutputFile       string  `json:"outputFile"`
+	Level          int   `json:"level"`
+	OriginalSize   int64 `json:"originalSize"`
+	CompressedSize int64 `json:"compressedSize"`
+
+	// CompressionRatio is the compressed size as a percentage of the
+	// original, so smaller is better; it's kept for existing clients.
+	// SpaceSavingPercent is the share of the original size saved, and
+	// RatioX how many times smaller the archive is. All three are 0 for
+	// an archive of empty files.
+	CompressionRatio   float64 `json:"compressionRatio"`
+	SpaceSavingPercent float64 `json:"spaceSavingPercent"`
+	RatioX             float64 `json:"ratioX"`
+
+	Duration    string   `json:"duration"`
+	OutputFile  string   `json:"outputFile"`
+	GlobMatches int      `json:"globMatches,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+
+	DereferencedInputs []string      `json:"dereferencedInputs,omitempty"`
+	RewrittenLinks     []LinkRewrite `json:"rewrittenLinks,omitempty"`
+
+	// Checksum is the SHA-256 of the archive, also written to the
+	// archive's .sha256 file, when the request asked for one.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Partial is set when the deadline stopped the compression early;
+	// IncludedFiles then lists the files that made it into the archive.
+	Partial       bool     `json:"partial,omitempty"`
+	IncludedFiles []string `json:"includedFiles,omitempty"`
+
+	// Unchanged is set when SkipIfUnchanged found nothing new to archive
+	Unchanged bool `json:"unchanged,omitempty"`
+
+	// BaseReferences lists the files stored as references to BaseArchive
+	BaseReferences []string `json:"baseReferences,omitempty"`
+
+	// UniqueContents counts the contents a Dedupe archive stores, and
+	// DedupedFiles the files that reused one instead of storing their own
+	UniqueContents int `json:"uniqueContents,omitempty"`
+	DedupedFiles   int `json:"dedupedFiles,omitempty"`
+
+	Slowest []FileTiming `json:"slowest,omitempty"`
+	PerFile []FileStat   `json:"perFile,omitempty"`
+
+	// PeakHeapBytes is the most heap in use while compressing, when the
+	// request set ReportMemory. It includes anything else running at the time.
+	PeakHeapBytes uint64 `json:"peakHeapBytes,omitempty"`
+
+	// CacheHit is set when an identical earlier compression's archive was
+	// copied to the output instead of compressing again
+	CacheHit bool `json:"cacheHit,omitempty"`
+}
+
+// setRatios fills in the ratios from the original and compressed sizes. An
+// archive of empty files has no ratio; NaN can't be sent as JSON.
+func (s *CompressionStats) setRatios() {
+	if s.OriginalSize > 0 {
+		s.CompressionRatio = float64(s.CompressedSize) / float64(s.OriginalSize) * 100
+		s.SpaceSavingPercent = (1 - float64(s.CompressedSize)/float64(s.OriginalSize)) * 100
+		if s.CompressedSize > 0 {
+			s.RatioX = float64(s.OriginalSize) / float64(s.CompressedSize)
+		}
+	}
+}
+
+// FileStat is one file's part of an archive's original size
+type FileStat struct {
+	Name    string  `json:"name"`
+	Size    int64   `json:"size"`
+	Percent float64 `json:"percent"`
+}
+
+// FileTiming is how long one file took to add to an archive
+type FileTiming struct {
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"`
+	Duration   string  `json:"duration"`
+	Throughput float64 `json:"throughput"` // bytes per second
+
+	elapsed time.Duration
 }
 
 type UploadResponse struct {
@@ -58,28 +579,149 @@ type UploadResponse struct {
 }
 
 func main() {
-	// Serve embedded frontend files
-	frontendFS, err := fs.Sub(embeddedFrontend, "frontend")
+	flag.StringVar(&defaultFormat, "default-format", defaultFormat, "archive format used when a request doesn't specify one ("+strings.Join(supportedFormats, ", ")+")")
+	flag.IntVar(&maxListEntries, "max-list-entries", maxListEntries, "maximum number of entries returned per /api/list-files page")
+	flag.BoolVar(&auditRequests, "audit-log", false, "log every request with its headers, secrets such as "+passwordHeader+" redacted")
+	flag.BoolVar(&stripSetuid, "strip-setuid", false, "clear the setuid and setgid bits of every file and directory extracted through the API")
+	flag.BoolVar(&allowRestoreToRoot, "allow-restore-to-root", false, "let API requests extract absolute entries to their absolute paths with the restore-to-root policy")
+	flag.StringVar(&webhookSecret, "webhook-secret", envOrDefault("WEBHOOK_SECRET", ""), "secret webhook bodies are signed with (env WEBHOOK_SECRET)")
+	flag.IntVar(&batchParallelism, "batch-parallelism", batchParallelism, "maximum number of archives of an /api/compress-batch request compressed at once")
+	flag.Int64Var(&archiveCacheMaxSize, "archive-cache-size", archiveCacheMaxSize, "total bytes of recent archives kept to answer identical compressions; the cache is off unless this is set")
+	flag.IntVar(&archiveCacheMaxEntries, "archive-cache-entries", archiveCacheMaxEntries, "maximum number of archives kept in the archive cache")
+	flag.Int64Var(&maxUploadBytes, "max-upload-size", maxUploadBytes, "maximum size in bytes of each uploaded file")
+	flag.Int64Var(&maxUploadTotalBytes, "max-upload-total", maxUploadTotalBytes, "maximum size in bytes of all the files of one upload request")
+	disable := flag.String("disable-features", envOrDefault("DISABLE_FEATURES", ""), "comma-separated features to turn off, such as encryption or remote-inputs (env DISABLE_FEATURES)")
+	flag.DurationVar(&uploadTTL, "upload-ttl", uploadTTL, "how long uploaded files are kept for compressions to use, 0 to keep them until shutdown")
+	frontendDir := flag.String("frontend-dir", "", "serve the frontend from this directory instead of the embedded copy (for development)")
+	addr := flag.String("addr", envOrDefault("ADDR", ""), "address to bind to, empty for all interfaces (env ADDR)")
+	port := flag.String("port", envOrDefault("PORT", "8080"), "port to listen on (env PORT)")
+	filesFrom := flag.String("files-from", "", "compress the files listed in this file (\"-\" for stdin) instead of starting the server")
+	null := flag.Bool("null", false, "with -files-from, names are NUL-separated, as find -print0 writes them, instead of one per line")
+	output := flag.String("output", "", "with -files-from, the archive to write")
+	level := flag.Int("level", 3, "with -files-from, the compression level (1-22)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long running requests get to finish on SIGINT or SIGTERM before they're canceled")
+	flag.Parse()
+
+	if !isSupportedFormat(defaultFormat) {
+		log.Fatalf("Unsupported default format %q, expected one of: %s", defaultFormat, strings.Join(supportedFormats, ", "))
+	}
+
+	disabled, err := parseDisabledFeatures(*disable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	disabledFeatures = disabled
+
+	if *filesFrom != "" {
+		if err := runCompressList(*filesFrom, *null, *output, *level); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	frontend, err := frontendHandler(*frontendDir)
 	if err != nil {
 		log.Fatal("Failed to create frontend filesystem:", err)
 	}
 
-	http.Handle("/", http.FileServer(http.FS(frontendFS)))
+	http.Handle("/", frontend)
 
 	// API endpoints
 	http.HandleFunc("/api/compress", handleCompress)
+	http.HandleFunc("/api/compress-batch", requireFeature("batch", handleCompressBatch))
+	http.HandleFunc("/api/compress-stream", requireFeature("compress-stream", handleCompressStream))
+	http.HandleFunc("/api/compress-ws", requireFeature("progress-websocket", handleCompressWS))
+	http.HandleFunc("/api/append", requireFeature("append", handleAppend))
 	http.HandleFunc("/api/decompress", handleDecompress)
+	http.HandleFunc("/api/decompress-stream", requireFeature("decompress-stream", handleDecompressStream))
 	http.HandleFunc("/api/list-files", handleListFiles)
 	http.HandleFunc("/api/upload", handleUpload)
 	http.HandleFunc("/api/upload-archive", handleUploadArchive)
 	http.HandleFunc("/api/download", handleDownload)
 	http.HandleFunc("/api/download-extracted", handleDownloadExtracted)
-
-	port := "8080"
-	fmt.Printf("Starting Zstd Compressor on http://localhost:%s\n", port)
+	http.HandleFunc("/api/download-bundle", handleDownloadBundle)
+	http.HandleFunc("/api/preview", handlePreview)
+	http.HandleFunc("/api/extract-preview", handleExtractPreview)
+	http.HandleFunc("/api/scan", requireFeature("scan", handleScan))
+	http.HandleFunc("/api/list-archive", handleListArchive)
+	http.HandleFunc("/api/info", handleInfo)
+	http.HandleFunc("/api/capabilities", handleCapabilities)
+	http.HandleFunc("/api/verify", handleVerify)
+	http.HandleFunc("/api/digest", handleDigest)
+	http.HandleFunc("/api/train-dict", handleTrainDict)
+
+	// Remove expired previews, download zips and uploads in the background
+	go janitor(time.Minute)
+
+	listenAddr := net.JoinHostPort(*addr, *port)
+	host := *addr
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	fmt.Printf("Starting Zstd Compressor on http://%s (listening on %s)\n", net.JoinHostPort(host, *port), listenAddr)
 	fmt.Println("Open your browser and navigate to the URL above")
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Requests derive their contexts from requestsCtx, so canceling it stops
+	// the compressions and extractions still running at shutdown
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	server := &http.Server{
+		Addr:        listenAddr,
+		Handler:     trackInFlight(auditLog(http.DefaultServeMux)),
+		BaseContext: func(net.Listener) context.Context { return requestsCtx },
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	// A second signal kills the process right away
+	stop()
+	log.Printf("Shutting down, waiting up to %s for running requests", *shutdownTimeout)
+	shutdownServer(server, cancelRequests, *shutdownTimeout)
+}
+
+// envOrDefault returns the environment variable key, or fallback when it's
+// unset or empty. Flags default to it, so a flag still overrides the env.
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// frontendHandler serves the frontend from dir on disk when set, so UI edits
+// show up without a rebuild, and from the embedded files otherwise.
+func frontendHandler(dir string) (http.Handler, error) {
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		return http.FileServer(http.Dir(dir)), nil
+	}
+
+	// Serve embedded frontend files
+	frontendFS, err := fs.Sub(embeddedFrontend, "frontend")
+	if err != nil {
+		return nil, err
+	}
+
+	return http.FileServer(http.FS(frontendFS)), nil
 }
 
 func handleCompress(w http.ResponseWriter, r *http.Request) {
@@ -93,275 +735,3401 @@ func handleCompress(w http.ResponseWriter, r *http.Request) {
 		sendResponse(w, false, "Invalid request format", nil)
 		return
 	}
+	if err := requestPassword(r, &req.Password); err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+	req.Context = r.Context()
 
-	if len(req.Files) == 0 {
-		sendResponse(w, false, "No files selected", nil)
+	globMatches, err := prepareCompressRequest(&req)
+	if err != nil {
+		sendResponse(w, false, err.Error(), nil)
 		return
 	}
 
-	// Generate output filename if not provided
-	if req.Output == "" {
-		if len(req.Files) == 1 {
-			baseName := filepath.Base(req.Files[0])
-			if strings.Contains(baseName, ".") {
-				baseName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
-			}
-			req.Output = baseName + ".zst"
-		} else {
-			req.Output = "archive.zst"
+	if req.DryRun {
+		entries, totalSize, err := dryRunEntries(req)
+		if err != nil {
+			sendJobResponse(w, req.WebhookURL, "compress", false, fmt.Sprintf("Dry run failed: %v", err), nil)
+			return
 		}
+		sendJobResponse(w, req.WebhookURL, "compress", true, fmt.Sprintf("Dry run: %d entries, %d bytes uncompressed", len(entries), totalSize), entries)
+		return
 	}
 
-	if !strings.HasSuffix(req.Output, ".zst") {
-		req.Output += ".zst"
+	stats, err := compressFiles(req)
+	if err != nil {
+		sendJobResponse(w, req.WebhookURL, "compress", false, fmt.Sprintf("Compression failed: %v", err), nil)
+		return
 	}
+	stats.GlobMatches = globMatches
 
-	// Set default compression level
-	if req.Level < 1 || req.Level > 19 {
-		req.Level = 3
+	sendJobResponse(w, req.WebhookURL, "compress", true, compressMessage(req, stats), stats)
+}
+
+// compressMessage summarizes a successful compression for the caller
+func compressMessage(req CompressRequest, stats *CompressionStats) string {
+	if stats.Unchanged {
+		return "No changes since the last run, compression skipped"
+	} else if stats.CacheHit {
+		return "Compression completed from the cache, inputs and options unchanged"
+	} else if stats.Partial {
+		return fmt.Sprintf("Deadline reached, archive is partial with %d files", len(stats.IncludedFiles))
+	} else if req.Measure {
+		return "Compression measured successfully, no output was written"
+	}
+	return "Compression completed successfully"
+}
+
+// prepareCompressRequest validates an API compress request and fills in
+// its defaults. It returns the number of files the glob matched; errors are
+// meant to be shown to the caller as they are.
+func prepareCompressRequest(req *CompressRequest) (int, error) {
+	if err := checkCompressFeatures(*req); err != nil {
+		return 0, err
 	}
 
-	stats, err := compressFiles(req.Files, req.Output, req.Level)
+	exclude, err := parseExcludeRules(req.Exclude)
 	if err != nil {
-		sendResponse(w, false, fmt.Sprintf("Compression failed: %v", err), nil)
-		return
+		return 0, err
 	}
 
-	sendResponse(w, true, "Compression completed successfully", stats)
-}
+	if req.WebhookURL != "" {
+		if err := checkWebhookURL(req.WebhookURL); err != nil {
+			return 0, err
+		}
+	}
 
-func handleDecompress(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	// Expand the glob pattern into the file list
+	var globMatches []string
+	if req.Glob != "" {
+		matches, err := expandGlob(req.Glob)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid glob pattern: %v", err)
+		}
+		if len(exclude) > 0 {
+			kept := matches[:0]
+			for _, match := range matches {
+				if !globExcluded(exclude, match) {
+					kept = append(kept, match)
+				}
+			}
+			matches = kept
+		}
+		if len(matches) == 0 {
+			return 0, errors.New("No files matched the glob pattern")
+		}
+		globMatches = matches
+
+		// Keep each match's relative path so the archive mirrors the tree
+		for _, match := range matches {
+			target := sanitizeTarPath(filepath.ToSlash(filepath.Clean(match)))
+			for strings.HasPrefix(target, "../") {
+				target = strings.TrimPrefix(target, "../")
+			}
+			req.Entries = append(req.Entries, ArchiveEntry{Source: match, Target: target})
+		}
 	}
 
-	var req DecompressRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sendResponse(w, false, "Invalid request format", nil)
-		return
+	if err := validateContents(req.Contents); err != nil {
+		return 0, err
 	}
 
-	if req.Archive == "" {
-		sendResponse(w, false, "No archive file specified", nil)
-		return
+	if len(req.Files) == 0 && len(req.Entries) == 0 && req.Directory == "" && len(req.Contents) == 0 {
+		return 0, errors.New("No files selected")
 	}
 
-	// Generate output directory if not provided
-	if req.OutputDir == "" {
-		baseName := filepath.Base(req.Archive)
-		if strings.HasSuffix(baseName, ".zst") {
-			baseName = strings.TrimSuffix(baseName, ".zst")
+	// An archive appended to keeps its format; framed ones are always zstd
+	rewrite := req.Append && !req.Frames
+	if rewrite {
+		format, err := appendFormat(req.Output)
+		if err != nil {
+			return 0, err
 		}
-		req.OutputDir = sanitizeDirectoryName(baseName) + "_extracted"
-	} else {
-		req.OutputDir = sanitizeDirectoryName(req.OutputDir)
+		req.Format = format
 	}
 
-	// Ensure we're using a simple directory name without path components
-	req.OutputDir = filepath.Base(req.OutputDir)
+	// Fall back to the server's default format
+	if req.Format == "" {
+		req.Format = defaultFormat
+	}
+	if !isSupportedFormat(req.Format) {
+		return 0, fmt.Errorf("Unsupported format: %s", req.Format)
+	}
 
-	fileCount, outputPath, err := decompressFile(req.Archive, req.OutputDir)
-	if err != nil {
-		sendResponse(w, false, fmt.Sprintf("Decompression failed: %v", err), nil)
-		return
+	if req.Concurrency < 0 {
+		return 0, errors.New("Invalid concurrency")
+	}
+	if req.MaxBytesPerSec < 0 {
+		return 0, errors.New("Invalid bandwidth cap")
+	}
+	if maxConcurrency := runtime.NumCPU() * 2; req.Concurrency > maxConcurrency {
+		req.Concurrency = maxConcurrency
 	}
 
-	data := map[string]interface{}{
-		"extractedFiles": fileCount,
-		"outputDir":      outputPath,
+	// Set default compression level; the effective level is reported back
+	if req.Level < 1 || req.Level > maxLevel {
+		req.Level = 3
 	}
 
-	sendResponse(w, true, fmt.Sprintf("Decompression completed. Extracted %d files to %s", fileCount, req.OutputDir), data)
-}
+	// Expand naming tokens in the pattern or in the output itself
+	if req.Output == "" && req.NamePattern != "" {
+		req.Output = expandNamePattern(req.NamePattern, *req, time.Now())
+	} else if strings.Contains(req.Output, "{") {
+		req.Output = expandNamePattern(req.Output, *req, time.Now())
+	}
 
-func compressFiles(files []string, outputFile string, level int) (*CompressionStats, error) {
-	startTime := time.Now()
+	ext := ".zst"
+	if req.Format == "zip" {
+		ext = ".zip"
+	} else if req.Format == "gzip" {
+		ext = ".tar.gz"
+	}
 
-	// Create output file
-	outFile, err := os.Create(outputFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create output file: %v", err)
+	// An encrypted archive's name ends in .age, after the format's extension
+	encrypted := len(req.Recipients) > 0 || req.Password != ""
+	if encrypted {
+		req.Output = strings.TrimSuffix(req.Output, ageExt)
 	}
-	defer outFile.Close()
 
-	// Create zstd encoder
-	encoder, err := zstd.NewWriter(outFile, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	// Generate output filename if not provided
+	if req.Output == "" {
+		req.Output = defaultBaseName(*req) + ext
+	}
+
+	if !strings.HasSuffix(req.Output, ext) && !rewrite {
+		req.Output += ext
+	}
+	if encrypted {
+		req.Output += ageExt
 	}
-	defer encoder.Close()
 
-	// Create tar writer
-	tarWriter := tar.NewWriter(encoder)
-	defer tarWriter.Close()
+	switch req.OnReadError {
+	case "", "abort", "skip-pad", "truncate-entry":
+	default:
+		return 0, errors.New("Invalid read error policy")
+	}
 
-	var totalSize int64
+	switch req.OnDuplicate {
+	case "", "overwrite", "rename", "error":
+	default:
+		return 0, errors.New("Invalid duplicate entry policy")
+	}
 
-	// Process each file
-	for _, file := range files {
-		if err := addToTar(tarWriter, file, &totalSize); err != nil {
-			return nil, fmt.Errorf("failed to add %s to archive: %v", file, err)
+	switch req.SpecialFiles {
+	case "", "skip", "devices":
+	default:
+		return 0, errors.New("Invalid special files policy")
+	}
+
+	if _, err := parseTarFormat(req.TarFormat); err != nil {
+		return 0, err
+	}
+
+	if req.Measure && req.MaxEntriesPerVolume > 0 {
+		return 0, errors.New("Measuring can't be combined with volumes")
+	}
+
+	if req.Frames && (req.Format != "zstd" || req.Measure || req.MaxEntriesPerVolume > 0 || req.SortEntries || req.GroupSimilar) {
+		return 0, errors.New("Framed archives can't be zip, measured, split into volumes or sorted")
+	}
+
+	if req.Checksum && (req.Measure || req.MaxEntriesPerVolume > 0 || req.Frames) {
+		return 0, errors.New("Checksums can't be combined with measuring, volumes or framed archives")
+	}
+
+	if rewrite && (req.Measure || req.MaxEntriesPerVolume > 0 || req.BaseArchive != "" || encrypted) {
+		return 0, errors.New("Appending can't be combined with measuring, volumes, incremental archives or encryption")
+	}
+
+	if req.Dictionary != "" {
+		if req.Format != "zstd" {
+			return 0, errors.New("Dictionaries are only supported for zstd archives")
+		}
+		if _, err := loadDictionary(req.Dictionary); err != nil {
+			return 0, fmt.Errorf("Invalid dictionary: %v", err)
 		}
 	}
 
-	// Get final file stats
-	stat, err := os.Stat(outputFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get output file stats: %v", err)
+	if encrypted {
+		if req.Format == "zip" || req.Frames || req.MaxEntriesPerVolume > 0 {
+			return 0, errors.New("Zip, framed and split archives can't be encrypted")
+		}
+		if req.Password != "" && len(req.Recipients) > 0 {
+			return 0, errors.New("An archive is encrypted to a password or to recipients, not both")
+		}
+		if _, err := parseRecipients(req.Recipients); err != nil {
+			return 0, err
+		}
 	}
 
-	stats := &CompressionStats{
-		OriginalSize:     totalSize,
-		CompressedSize:   stat.Size(),
-		CompressionRatio: float64(stat.Size()) / float64(totalSize) * 100,
-		Duration:         time.Since(startTime).String(),
-		OutputFile:       outputFile,
+	if req.BaseArchive != "" && (req.Format == "zip" || req.TarFormat != "" && req.TarFormat != "pax") {
+		return 0, errors.New("Incremental archives need the pax tar format")
 	}
 
-	return stats, nil
-}
+	if req.Dedupe {
+		if req.Format == "zip" || req.TarFormat != "" && req.TarFormat != "pax" {
+			return 0, errors.New("Deduplicated archives need the pax tar format")
+		}
+		if req.Frames || req.MaxEntriesPerVolume > 0 {
+			return 0, errors.New("Framed and split archives can't be deduplicated")
+		}
+	}
 
-func addToTar(tarWriter *tar.Writer, filePath string, totalSize *int64) error {
-	return filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
+	if req.NameTransformSpec != "" {
+		transform, err := parseNameTransform(req.NameTransformSpec)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		req.NameTransform = transform
+	}
 
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
+	if req.SymlinkRewriteSpec != "" {
+		rewrite, err := parseSymlinkRewrite(req.SymlinkRewriteSpec)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		req.SymlinkRewrite = rewrite
+	}
 
-		// Use relative path and sanitize it for cross-platform compatibility
-		header.Name = path
-		if filePath != path {
-			relPath, err := filepath.Rel(filepath.Dir(filePath), path)
+	if len(req.EntryFilterSpecs) > 0 {
+		filters := []EntryFilter{req.EntryFilter}
+		for _, spec := range req.EntryFilterSpecs {
+			filter, err := parseEntryFilter(spec)
 			if err != nil {
-				return err
+				return 0, err
 			}
-			header.Name = filepath.Join(filepath.Base(filePath), relPath)
+			filters = append(filters, filter)
 		}
+		req.EntryFilter = allEntryFilters(filters...)
+	}
 
-		// Convert to forward slashes for tar format and sanitize
-		header.Name = sanitizeTarPath(filepath.ToSlash(header.Name))
-
-		// Write header
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
+	if req.MaxDurationSpec != "" {
+		duration, err := time.ParseDuration(req.MaxDurationSpec)
+		if err != nil || duration <= 0 {
+			return 0, errors.New("Invalid maximum duration")
 		}
+		req.MaxDuration = duration
+	}
 
-		// If it's a file, write its contents
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
+	if req.SkipIfUnchanged && req.StateFile == "" {
+		return 0, errors.New("A state file is required to skip unchanged inputs")
+	}
 
-			_, err = io.Copy(tarWriter, file)
-			if err != nil {
-				return err
+	return len(globMatches), nil
+}
+
+// defaultBaseName names the archive after its single input, or "archive"
+func defaultBaseName(req CompressRequest) string {
+	if len(req.Files) == 1 && req.Directory == "" {
+		baseName := filepath.Base(req.Files[0])
+		if isRemoteURL(req.Files[0]) {
+			if u, err := url.Parse(req.Files[0]); err == nil {
+				baseName = path.Base(u.Path)
+				if baseName == "/" || baseName == "." {
+					baseName = u.Hostname()
+				}
 			}
+		}
+		// Drop the extension, but keep dotfiles like .bashrc whole
+		if trimmed := strings.TrimSuffix(baseName, filepath.Ext(baseName)); trimmed != "" {
+			baseName = trimmed
+		}
+		return baseName
+	}
+	if len(req.Files) == 0 && req.Directory != "" {
+		return filepath.Base(filepath.Clean(req.Directory))
+	}
+	return "archive"
+}
 
-			*totalSize += info.Size()
+var nameTokenPattern = regexp.MustCompile(`\{(\w+)(?::([^}]*))?\}`)
+
+// expandNamePattern replaces {basename}, {date:layout}, {level}, {format} and
+// {count} in pattern. Expanded values are sanitized so they can't introduce
+// path separators; unknown tokens are left untouched.
+func expandNamePattern(pattern string, req CompressRequest, now time.Time) string {
+	return nameTokenPattern.ReplaceAllStringFunc(pattern, func(token string) string {
+		match := nameTokenPattern.FindStringSubmatch(token)
+
+		var value string
+		switch match[1] {
+		case "basename":
+			value = defaultBaseName(req)
+		case "date":
+			layout := match[2]
+			if layout == "" {
+				layout = "2006-01-02"
+			}
+			value = now.Format(layout)
+		case "level":
+			value = strconv.Itoa(req.Level)
+		case "format":
+			value = req.Format
+		case "count":
+			count := len(req.Files) + len(req.Entries)
+			if req.Directory != "" {
+				count++
+			}
+			value = strconv.Itoa(count)
+		default:
+			return token
 		}
 
-		return nil
+		return sanitizeDirectoryName(value)
 	})
 }
 
-func decompressFile(archiveFile, outputDir string) (int, string, error) {
-	// Get the current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to get current directory: %v", err)
+func isSupportedFormat(format string) bool {
+	for _, supported := range supportedFormats {
+		if format == supported {
+			return true
+		}
 	}
+	return false
+}
 
-	// Create the full output path in the current directory
-	fullOutputDir := filepath.Join(cwd, outputDir)
+// checkAbsolutePathPolicy answers requests with an unknown absolute path
+// policy, or with "restore-to-root" while -allow-restore-to-root is off,
+// which gets 403 Forbidden. It reports whether the request can go on.
+func checkAbsolutePathPolicy(w http.ResponseWriter, policy string) bool {
+	switch policy {
+	case "", "relocate", "reject":
+		return true
+	case "restore-to-root":
+		if allowRestoreToRoot {
+			return true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		sendResponse(w, false, "Restoring absolute paths is disabled on this server", nil)
+		return false
+	default:
+		sendResponse(w, false, "Invalid absolute path policy", nil)
+		return false
+	}
+}
 
-	// Remove the directory if it already exists
-	if _, err := os.Stat(fullOutputDir); err == nil {
-		os.RemoveAll(fullOutputDir)
+func handleDecompress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Create the output directory
-	if err := os.MkdirAll(fullOutputDir, 0755); err != nil {
-		return 0, "", fmt.Errorf("failed to create output directory: %v", err)
+	var req DecompressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, false, "Invalid request format", nil)
+		return
+	}
+	if err := requestPassword(r, &req.Password); err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
 	}
+	req.Context = r.Context()
+	req.StripSetuid = req.StripSetuid || stripSetuid
 
-	// Open archive file
-	file, err := os.Open(archiveFile)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to open archive: %v", err)
+	if req.Archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
 	}
-	defer file.Close()
 
-	// Create zstd decoder
-	decoder, err := zstd.NewReader(file)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to create zstd decoder: %v", err)
+	// Generate output directory if not provided
+	if req.OutputDir == "" {
+		baseName := trimArchiveExt(filepath.Base(req.Archive))
+		req.OutputDir = sanitizeDirectoryName(baseName) + "_extracted"
+	} else {
+		req.OutputDir = sanitizeDirectoryName(req.OutputDir)
 	}
-	defer decoder.Close()
 
-	// Create tar reader
-	tarReader := tar.NewReader(decoder)
+	// Ensure we're using a simple directory name without path components
+	req.OutputDir = filepath.Base(req.OutputDir)
 
-	fileCount := 0
+	switch req.EntrySizePolicy {
+	case "", "skip", "abort":
+	default:
+		sendResponse(w, false, "Invalid entry size policy", nil)
+		return
+	}
 
-	// Extract files
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	if req.NameTransformSpec != "" {
+		transform, err := parseNameTransform(req.NameTransformSpec)
+		if err != nil {
+			sendResponse(w, false, err.Error(), nil)
+			return
+		}
+		req.NameTransform = transform
+	}
+
+	switch req.NameCharsetPolicy {
+	case "", "skip", "sanitize", "abort":
+	default:
+		sendResponse(w, false, "Invalid name charset policy", nil)
+		return
+	}
+
+	switch req.ExistingFiles {
+	case "", "overwrite", "skip":
+	default:
+		sendResponse(w, false, "Invalid existing files policy", nil)
+		return
+	}
+
+	if !checkAbsolutePathPolicy(w, req.AbsolutePathPolicy) {
+		return
+	}
+
+	if _, err := parseTypeFilter(req.ExtractTypes); err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+
+	if err := checkDecompressFeatures(req); err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+	if req.Identity != "" {
+		if _, err := parseIdentities(req.Identity); err != nil {
+			sendResponse(w, false, err.Error(), nil)
+			return
+		}
+	}
+
+	for _, dictionary := range req.Dictionaries {
+		if _, err := loadDictionary(dictionary); err != nil {
+			sendResponse(w, false, fmt.Sprintf("Invalid dictionary: %v", err), nil)
+			return
+		}
+	}
+	if req.NameCharset != "" {
+		if _, err := parseNameCharset(req.NameCharset); err != nil {
+			sendResponse(w, false, err.Error(), nil)
+			return
+		}
+	}
+	if req.WebhookURL != "" {
+		if err := checkWebhookURL(req.WebhookURL); err != nil {
+			sendResponse(w, false, err.Error(), nil)
+			return
+		}
+	}
+
+	result, err := decompressFile(req)
+	if err != nil {
+		sendJobResponse(w, req.WebhookURL, "decompress", false, fmt.Sprintf("Decompression failed: %v", err), nil)
+		return
+	}
+
+	data := map[string]interface{}{
+		"extractedFiles": result.FileCount,
+		"outputDir":      result.OutputDir,
+	}
+	if len(result.SkippedEntries) > 0 {
+		data["skippedEntries"] = result.SkippedEntries
+	}
+	if len(result.SplitFiles) > 0 {
+		data["splitFiles"] = result.SplitFiles
+	}
+	if len(result.InvalidNames) > 0 {
+		data["invalidNames"] = result.InvalidNames
+	}
+	if len(req.ExtractTypes) > 0 {
+		data["typeMatched"] = result.TypeMatched
+		data["typeSkipped"] = result.TypeSkipped
+	}
+	if len(result.NestedArchives) > 0 {
+		data["nestedArchives"] = result.NestedArchives
+	}
+	if req.ReportMemory {
+		data["peakHeapBytes"] = result.PeakHeapBytes
+	}
+	data["stats"] = result.DecompressionStats
+	if req.Detailed {
+		data["entries"] = result.Entries
+	}
+
+	sendJobResponse(w, req.WebhookURL, "decompress", true, fmt.Sprintf("Decompression completed. Extracted %d files to %s", result.FileCount, filepath.Base(result.OutputDir)), data)
+}
+
+// compressFiles archives the files, directories and URLs req names. It's a
+// thin adapter over compressReaders: every file is opened as a reader when
+// its entry is written.
+func compressFiles(req CompressRequest) (*CompressionStats, error) {
+	return compressReaders(nil, req)
+}
+
+// compressReaders archives the given readers, after any inputs req already
+// names, with req's options
+func compressReaders(entries []ReaderEntry, req CompressRequest) (*CompressionStats, error) {
+	req.Readers = append(append([]ReaderEntry(nil), req.Readers...), entries...)
+
+	logger, err := newJobLogger(req.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	defer logger.Close()
+
+	logger.Printf("Compressing %d input(s) to %s at level %d", len(req.Files), req.Output, req.Level)
+
+	if err := validateEntryTargets(req.Entries); err != nil {
+		logger.Printf("Compression failed: %v", err)
+		return nil, err
+	}
+
+	if err := validateDirectoryInput(req); err != nil {
+		logger.Printf("Compression failed: %v", err)
+		return nil, err
+	}
+
+	// Refuse to write over (or into) one of the inputs before anything is truncated
+	if !req.Measure {
+		var sources []string
+		for _, file := range req.Files {
+			if !isRemoteURL(file) {
+				sources = append(sources, file)
+			}
+		}
+		if req.Directory != "" {
+			sources = append(sources, req.Directory)
+		}
+		for _, entry := range req.Entries {
+			if !isRemoteURL(entry.Source) {
+				sources = append(sources, entry.Source)
+			}
+		}
+		if err := checkOutputOverlap(req.Output, sources); err != nil {
+			logger.Printf("Compression failed: %v", err)
+			return nil, err
+		}
+	}
+
+	var fingerprint string
+	if req.StateFile != "" && !req.Measure {
+		fingerprint, err = inputFingerprint(req)
+		if err != nil {
+			logger.Printf("Compression failed: %v", err)
+			return nil, err
+		}
+
+		if req.SkipIfUnchanged {
+			// Split archives leave their index where the output would be
+			output := req.Output
+			if req.MaxEntriesPerVolume > 0 {
+				output = strings.TrimSuffix(output, filepath.Ext(output)) + volumeIndexSuffix
+			}
+
+			previous, err := os.ReadFile(req.StateFile)
+			_, statErr := os.Stat(output)
+			if err == nil && statErr == nil && strings.TrimSpace(string(previous)) == fingerprint {
+				logger.Printf("Inputs unchanged since the last run, skipping compression")
+				return &CompressionStats{OutputFile: output, Unchanged: true}, nil
+			}
+		}
+	}
+
+	// Identical inputs and options give an identical archive, so reuse one
+	cacheKey := archiveCacheKey(req)
+	if cacheKey != "" {
+		startTime := time.Now()
+		if stats, ok := useCachedArchive(cacheKey, req.Output, req.Sync); ok {
+			if stats.Checksum != "" {
+				if err := writeChecksumFile(req.Output, stats.Checksum); err != nil {
+					logger.Printf("Compression failed: %v", err)
+					return nil, err
+				}
+			}
+			stats.Duration = time.Since(startTime).String()
+
+			// Nothing is read on a hit, so progress jumps straight to done
+			if req.OnProgress != nil {
+				req.OnProgress(Progress{Bytes: stats.OriginalSize, TotalBytes: stats.OriginalSize})
+			}
+
+			logger.Printf("Inputs and options unchanged, reused the cached archive")
+			return stats, nil
+		}
+	}
+
+	var sampler *memorySampler
+	if req.ReportMemory {
+		sampler = startMemorySampler()
+	}
+
+	var stats *CompressionStats
+	if req.LowPriority {
+		withLowPriority(logger, func() {
+			stats, err = writeArchive(req, logger)
+		})
+	} else {
+		stats, err = writeArchive(req, logger)
+	}
+	if sampler != nil {
+		peak := sampler.Stop()
+		if err == nil {
+			stats.PeakHeapBytes = peak
+			logger.Printf("Peak heap in use: %d bytes", peak)
+		}
+	}
+	if err != nil {
+		logger.Printf("Compression failed: %v", err)
+		return nil, err
+	}
+
+	// A partial archive doesn't cover the inputs, so don't record it
+	if fingerprint != "" && !stats.Partial {
+		if err := os.WriteFile(req.StateFile, []byte(fingerprint+"\n"), 0644); err != nil {
+			logger.Printf("Compression failed: %v", err)
+			return nil, fmt.Errorf("failed to write state file: %v", err)
+		}
+	}
+
+	if cacheKey != "" && !stats.Partial {
+		cacheArchive(cacheKey, stats)
+	}
+
+	logger.Printf("Compression completed: original size %d bytes, compressed size %d bytes, ratio %.2f%%, duration %s",
+		stats.OriginalSize, stats.CompressedSize, stats.CompressionRatio, stats.Duration)
+
+	return stats, nil
+}
+
+// checkOutputOverlap returns an error if output is one of the inputs or would
+// be created inside an input directory, where it would be read while written.
+func checkOutputOverlap(output string, files []string) error {
+	absOutput, err := filepath.Abs(output)
+	if err != nil {
+		return fmt.Errorf("failed to resolve output path: %v", err)
+	}
+
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("failed to resolve input path %s: %v", file, err)
+		}
+
+		if absOutput == absFile {
+			return fmt.Errorf("output file %s is also an input", output)
+		}
+		if strings.HasPrefix(absOutput, absFile+string(os.PathSeparator)) {
+			return fmt.Errorf("output file %s is inside input directory %s", output, file)
+		}
+	}
+
+	return nil
+}
+
+// validateEntryTargets ensures explicit entry names are unique and stay inside the archive
+func validateEntryTargets(entries []ArchiveEntry) error {
+	seen := make(map[string]bool)
+
+	for _, entry := range entries {
+		if entry.Source == "" {
+			return fmt.Errorf("entry for target %q has no source", entry.Target)
+		}
+
+		target := path.Clean(filepath.ToSlash(entry.Target))
+		if entry.Target == "" || target == "." || path.IsAbs(target) || target == ".." || strings.HasPrefix(target, "../") {
+			return fmt.Errorf("invalid target name %q for %s", entry.Target, entry.Source)
+		}
+
+		if seen[target] {
+			return fmt.Errorf("duplicate target name %q", entry.Target)
+		}
+		seen[target] = true
+	}
+
+	return nil
+}
+
+// validateDirectoryInput checks that Directory is a directory and that its
+// root name is safe to use inside the archive.
+func validateDirectoryInput(req CompressRequest) error {
+	if req.Directory == "" {
+		return nil
+	}
+
+	info, err := os.Stat(req.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to access directory: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", req.Directory)
+	}
+
+	if req.RootName != "" && !req.FlattenRoot {
+		return validateEntryTargets([]ArchiveEntry{{Source: req.Directory, Target: req.RootName}})
+	}
+
+	return nil
+}
+
+// archiveInputs lists every input of req with the name it gets in the
+// archive. Files are named from their paths; explicit entries carry their
+// own target.
+func archiveInputs(req CompressRequest) []ArchiveEntry {
+	inputs := make([]ArchiveEntry, 0, len(req.Files)+len(req.Entries)+1)
+	for _, file := range req.Files {
+		inputs = append(inputs, ArchiveEntry{Source: file})
+	}
+	if req.Directory != "" {
+		rootName := req.RootName
+		if req.FlattenRoot {
+			rootName = "."
+		} else if rootName == "" {
+			rootName = filepath.Base(filepath.Clean(req.Directory))
+		}
+		inputs = append(inputs, ArchiveEntry{Source: req.Directory, Target: rootName})
+	}
+	return append(inputs, req.Entries...)
+}
+
+// countInputs counts the files, and their total size, that compressing req
+// would archive. Remote inputs count with a size of 0, as it isn't known
+// before they are fetched.
+func countInputs(req CompressRequest) (int, int64, error) {
+	format, err := parseTarFormat(req.TarFormat)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	builder := &tarBuilder{req: &req, format: format}
+
+	var files int
+	var bytes int64
+	for _, input := range archiveInputs(req) {
+		entries, err := builder.collectEntries(input.Source, input.Target)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to scan %s: %v", input.Source, err)
+		}
+		for _, entry := range entries {
+			if entry.header.Typeflag == tar.TypeReg {
+				files++
+				bytes += entry.header.Size
+			}
+		}
+	}
+
+	readers, err := builder.readerEntries()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range readers {
+		files++
+		bytes += entry.header.Size
+	}
+
+	return files, bytes, nil
+}
+
+// DryRunEntry is one entry a dry run found the archive would hold
+type DryRunEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"isDir"`
+}
+
+// dryRunEntries walks the inputs of req as a compression would and lists
+// the entries, with their total size, without reading any file contents.
+// Remote inputs are listed with a size of 0.
+func dryRunEntries(req CompressRequest) ([]DryRunEntry, int64, error) {
+	if err := validateEntryTargets(req.Entries); err != nil {
+		return nil, 0, err
+	}
+	if err := validateDirectoryInput(req); err != nil {
+		return nil, 0, err
+	}
+
+	format, err := parseTarFormat(req.TarFormat)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	builder := &tarBuilder{req: &req, format: format}
+
+	entries := []DryRunEntry{}
+	var totalSize int64
+	for _, input := range archiveInputs(req) {
+		inputEntries, err := builder.collectEntries(input.Source, input.Target)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan %s: %v", input.Source, err)
+		}
+		for _, entry := range inputEntries {
+			header := entry.header
+			entries = append(entries, DryRunEntry{
+				Path:  header.Name,
+				Size:  header.Size,
+				IsDir: header.Typeflag == tar.TypeDir,
+			})
+			totalSize += header.Size
+		}
+	}
+
+	readers, err := builder.readerEntries()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, entry := range readers {
+		entries = append(entries, DryRunEntry{Path: entry.header.Name, Size: entry.header.Size})
+		totalSize += entry.header.Size
+	}
+
+	return entries, totalSize, nil
+}
+
+// inputFingerprint hashes the sorted names, sizes and modification times of
+// everything req would archive. Remote inputs are stamped with the current
+// time, so a request that fetches URLs never matches a previous run.
+func inputFingerprint(req CompressRequest) (string, error) {
+	format, err := parseTarFormat(req.TarFormat)
+	if err != nil {
+		return "", err
+	}
+
+	builder := &tarBuilder{req: &req, format: format}
+
+	var headers []*tar.Header
+	for _, input := range archiveInputs(req) {
+		entries, err := builder.collectEntries(input.Source, input.Target)
+		if err != nil {
+			return "", fmt.Errorf("failed to scan %s: %v", input.Source, err)
+		}
+		for _, entry := range entries {
+			headers = append(headers, entry.header)
+		}
+	}
+
+	readers, err := builder.readerEntries()
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range readers {
+		headers = append(headers, entry.header)
+	}
+
+	sort.Slice(headers, func(i, j int) bool {
+		return headers[i].Name < headers[j].Name
+	})
+
+	hash := sha256.New()
+	for _, header := range headers {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", header.Name, header.Size, header.ModTime.UnixNano())
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func writeArchive(req CompressRequest, logger *jobLogger) (*CompressionStats, error) {
+	startTime := time.Now()
+	outputFile := req.Output
+
+	if req.MaxDuration > 0 {
+		if limit := startTime.Add(req.MaxDuration); req.Deadline.IsZero() || limit.Before(req.Deadline) {
+			req.Deadline = limit
+		}
+	}
+
+	// The ultra levels 20-22 share the strongest encoder level with 10-19
+	level := zstd.EncoderLevelFromZstd(req.Level)
+	options := []zstd.EOption{zstd.WithEncoderLevel(level)}
+	if req.Dictionary != "" {
+		dictionary, err := loadDictionary(req.Dictionary)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, zstd.WithEncoderDict(dictionary))
+	}
+	newEncoder := func(w io.Writer) (io.WriteCloser, error) {
+		if req.Format == "gzip" {
+			return gzip.NewWriterLevel(w, gzipLevel(req.Level))
+		}
+		if req.LowPriority {
+			// A single-threaded encoder runs on the caller's low priority thread
+			return zstd.NewWriter(w, append(options, zstd.WithEncoderConcurrency(1))...)
+		}
+		if req.Concurrency > 0 {
+			// Pooled encoders use the default concurrency, GOMAXPROCS
+			return zstd.NewWriter(w, append(options, zstd.WithEncoderConcurrency(req.Concurrency))...)
+		}
+		if req.Dictionary != "" {
+			// Pooled encoders have no dictionary
+			return zstd.NewWriter(w, options...)
+		}
+		// Borrow a zstd encoder for this level from the pool
+		encoder, err := getEncoder(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledEncoder{Encoder: encoder, level: level}, nil
+	}
+	if len(req.Recipients) > 0 {
+		recipients, err := parseRecipients(req.Recipients)
+		if err != nil {
+			return nil, err
+		}
+		newEncoder = withEncryption(newEncoder, recipients)
+	}
+	var readLimiter *rateLimiter
+	if req.MaxBytesPerSec > 0 {
+		readLimiter = newRateLimiter(req.Context, req.MaxBytesPerSec)
+		if !req.Measure {
+			newEncoder = withThrottle(newEncoder, newRateLimiter(req.Context, req.MaxBytesPerSec))
+		}
+	}
+
+	if req.Password != "" {
+		recipient, err := passwordRecipient(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		newEncoder = withEncryption(newEncoder, []age.Recipient{recipient})
+	}
+
+	var archive archiveWriter
+	var encoder io.WriteCloser
+	var outFile *os.File
+	var counter *countingWriter
+	var volumes *volumeWriter
+	var hasher hash.Hash
+	var frames *frameWriter
+	if req.Frames {
+		var err error
+		frames, err = newFrameWriter(&req, newEncoder)
+		if err != nil {
+			return nil, err
+		}
+		archive = frames
+	} else if req.MaxEntriesPerVolume > 0 {
+		// Every volume gets its own file and compression stream
+		volumes = newVolumeWriter(&req, func(out io.Writer) (archiveWriter, io.WriteCloser, error) {
+			return newArchiveWriter(out, req.Format, newEncoder)
+		})
+		archive = volumes
+	} else {
+		var out io.Writer
+		if req.Measure {
+			// Only count the compressed bytes, nothing is written to disk
+			counter = &countingWriter{w: io.Discard}
+			out = counter
+		} else {
+			// Create output file, or open an existing pipe or device as is.
+			// A pipe opened write-only waits for its reader, so nothing
+			// written before the reader attaches is lost.
+			info, statErr := os.Stat(outputFile)
+			special := statErr == nil && !info.Mode().IsRegular() && !info.IsDir()
+
+			var err error
+			if special {
+				outFile, err = os.OpenFile(outputFile, os.O_WRONLY, 0)
+			} else if req.Append {
+				// The archive is read while its replacement is written
+				outFile, err = os.CreateTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".*")
+				if err == nil {
+					defer os.Remove(outFile.Name())
+				}
+			} else {
+				outFile, err = os.Create(outputFile)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to create output file: %v", err)
+			}
+			defer outFile.Close()
+			out = outFile
+
+			// A pipe or device has no size to stat afterwards, so count instead
+			if special {
+				counter = &countingWriter{w: outFile}
+				out = counter
+			}
+
+			if req.OutputSizeHint > 0 && !special {
+				if err := preallocate(outFile, req.OutputSizeHint); err != nil {
+					logger.Printf("Failed to preallocate %d bytes, continuing without: %v", req.OutputSizeHint, err)
+				}
+			}
+
+			// Hash the archive as it's written rather than reading it back
+			if req.Checksum && !special {
+				hasher = sha256.New()
+				out = io.MultiWriter(out, hasher)
+			}
+		}
+
+		var err error
+		archive, encoder, err = newArchiveWriter(out, req.Format, newEncoder)
+		if err != nil {
+			return nil, err
+		}
+		if encoder != nil {
+			defer encoder.Close()
+		}
+	}
+	defer archive.Close()
+
+	// A framed append that fails puts the archive back as it was, rather
+	// than indexing a frame cut short
+	finalized := false
+	if frames != nil && req.Append {
+		defer func() {
+			if finalized {
+				return
+			}
+			if err := frames.restore(); err != nil {
+				logger.Printf("%v", err)
+			}
+		}()
+	}
+
+	format, err := parseTarFormat(req.TarFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := startProgress(req.OnProgress)
+	defer progress.Stop()
+	if req.TwoPass && progress != nil {
+		files, bytes, err := countInputs(req)
+		if err != nil {
+			return nil, err
+		}
+		progress.setTotals(files, bytes)
+	}
+
+	builder := &tarBuilder{tarWriter: archive, req: &req, logger: logger, format: format, progress: progress, readLimiter: readLimiter}
+
+	if req.Provenance {
+		if err := builder.writeProvenance(); err != nil {
+			return nil, fmt.Errorf("failed to write archive metadata: %v", err)
+		}
+	}
+
+	if req.Append && !req.Frames {
+		if err := builder.copyArchive(outputFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.BaseArchive != "" {
+		builder.base, err = loadBaseEntries(req.BaseArchive)
+		if err != nil {
+			return nil, err
+		}
+
+		// Name the base so extraction can find it next to this archive
+		records := map[string]string{baseRecord: filepath.Base(req.BaseArchive)}
+		if err := archive.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: records}); err != nil {
+			return nil, fmt.Errorf("failed to write archive metadata: %v", err)
+		}
+	}
+
+	if err := builder.addInputs(archiveInputs(req)); err != nil {
+		if err != errDeadlineReached {
+			if !req.Append {
+				// Canceled or aborted part way, so don't leave a truncated
+				// archive behind
+				archive.Close()
+				if encoder != nil {
+					encoder.Close()
+				}
+				if volumes != nil {
+					volumes.remove()
+				} else if outFile != nil && counter == nil || req.Frames {
+					if outFile != nil {
+						outFile.Close()
+					}
+					os.Remove(outputFile)
+				}
+				if builder.contextErr() != nil {
+					logger.Printf("Compression canceled, removed the partial output")
+				} else {
+					logger.Printf("Compression failed, removed the partial output")
+				}
+			}
+			return nil, err
+		}
+		// Keep what made it in and finalize a valid, partial archive
+		builder.partial = true
+		logger.Printf("Deadline reached after %d files, finalizing a partial archive", len(builder.included))
+	}
+
+	// Flush the archive and zstd streams so the output file holds the final size
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize archive: %v", err)
+	}
+	finalized = true
+	if encoder != nil {
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("failed to finalize zstd stream: %v", err)
+		}
+	}
+
+	// Release whatever preallocated space the archive didn't use
+	if req.OutputSizeHint > 0 && outFile != nil && counter == nil {
+		size, err := outFile.Seek(0, io.SeekCurrent)
+		if err == nil {
+			err = outFile.Truncate(size)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to release preallocated space: %v", err)
+		}
+	}
+
+	// Make sure the archive is on stable storage before reporting success,
+	// and an appended archive's copy before it replaces the original
+	if req.Sync && outFile != nil && counter == nil {
+		if err := fsync(outFile); err != nil {
+			return nil, fmt.Errorf("failed to sync output file: %v", err)
+		}
+	}
+
+	// Replace the archive appended to only once its copy is complete
+	if req.Append && outFile != nil && counter == nil {
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(outputFile); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := outFile.Chmod(mode); err != nil {
+			return nil, fmt.Errorf("failed to replace archive: %v", err)
+		}
+		if err := os.Rename(outFile.Name(), outputFile); err != nil {
+			return nil, fmt.Errorf("failed to replace archive: %v", err)
+		}
+	}
+
+	var checksum string
+	if hasher != nil {
+		checksum = hex.EncodeToString(hasher.Sum(nil))
+		if err := writeChecksumFile(outputFile, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	// The archive's directory entry, new or renamed, needs syncing too
+	if req.Sync && outFile != nil && counter == nil {
+		if err := syncDir(filepath.Dir(outputFile)); err != nil {
+			return nil, fmt.Errorf("failed to sync output directory: %v", err)
+		}
+	}
+
+	var compressedSize int64
+	if volumes != nil {
+		compressedSize = volumes.size
+		outputFile = volumes.indexPath
+	} else if req.Measure {
+		compressedSize = counter.n
+		outputFile = ""
+	} else if counter != nil {
+		compressedSize = counter.n
+	} else {
+		// Get final file stats
+		stat, err := os.Stat(outputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get output file stats: %v", err)
+		}
+		compressedSize = stat.Size()
+	}
+
+	stats := &CompressionStats{
+		Level:          req.Level,
+		OriginalSize:   builder.totalSize,
+		CompressedSize: compressedSize,
+		Duration:       time.Since(startTime).String(),
+		OutputFile:     outputFile,
+		Warnings:       builder.warnings,
+
+		DereferencedInputs: builder.dereferenced,
+		RewrittenLinks:     builder.rewrittenLinks,
+		BaseReferences:     builder.baseReferences,
+		UniqueContents:     len(builder.dedupeStored),
+		DedupedFiles:       builder.dedupedFiles,
+		Checksum:           checksum,
+	}
+
+	if req.Format == "gzip" {
+		stats.Level = gzipLevel(req.Level)
+	}
+
+	stats.setRatios()
+
+	if req.SlowestFiles > 0 {
+		builder.trimSlowest()
+		stats.Slowest = builder.slowest
+	}
+
+	if req.PerFile {
+		for i := range builder.perFile {
+			if builder.totalSize > 0 {
+				builder.perFile[i].Percent = float64(builder.perFile[i].Size) / float64(builder.totalSize) * 100
+			}
+		}
+		stats.PerFile = builder.perFile
+	}
+
+	if builder.partial {
+		stats.Partial = true
+		stats.IncludedFiles = builder.included
+	}
+
+	return stats, nil
+}
+
+// gzipLevel maps a zstd level (1-22) onto gzip's 1-9, keeping 1-9 as they
+// are and using gzip's best compression above that
+func gzipLevel(level int) int {
+	if level > gzip.BestCompression {
+		return gzip.BestCompression
+	}
+	return level
+}
+
+// newArchiveWriter starts an archive in the given format on out. Tar archives
+// are compressed as one zstd (or gzip) stream, which is returned so the caller can close
+// it after the archive; zip archives compress each entry on its own.
+func newArchiveWriter(out io.Writer, format string, newEncoder func(w io.Writer) (io.WriteCloser, error)) (archiveWriter, io.WriteCloser, error) {
+	if format == "zip" {
+		return newZipEntryWriter(out, newEncoder), nil, nil
+	}
+
+	encoder, err := newEncoder(out)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create zstd encoder: %v", err)
+	}
+
+	return tar.NewWriter(encoder), encoder, nil
+}
+
+// fsync flushes a file to stable storage. Tests replace it to see what a
+// compression syncs.
+var fsync = (*os.File).Sync
+
+// syncDir fsyncs a directory so a newly created entry in it survives a crash.
+// Windows can't sync directories, so it's a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return fsync(d)
+}
+
+// withLowPriority runs fn on a dedicated OS thread with lowered scheduling
+// priority. An unprivileged process can't raise its priority back, so the
+// thread is discarded afterwards instead of being handed back to the runtime.
+func withLowPriority(logger *jobLogger, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// Returning while still locked terminates the thread
+		runtime.LockOSThread()
+		if err := lowerThreadPriority(); err != nil {
+			logger.Printf("Failed to lower priority, continuing at normal priority: %v", err)
+		}
+		fn()
+	}()
+	<-done
+}
+
+// maxLevel is the highest compression level accepted, zstd's ultra 22
+const maxLevel = 22
+
+// encoderPools keeps reusable zstd encoders per encoder level, so that many
+// small compressions don't reallocate the encoder's internal buffers each time.
+var encoderPools sync.Map // zstd.EncoderLevel -> *sync.Pool
+
+func getEncoder(w io.Writer, level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	pool, _ := encoderPools.LoadOrStore(level, &sync.Pool{})
+	if encoder, ok := pool.(*sync.Pool).Get().(*zstd.Encoder); ok {
+		encoder.Reset(w)
+		return encoder, nil
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+}
+
+// putEncoder closes the encoder if it's still open and returns it to the pool
+func putEncoder(encoder *zstd.Encoder, level zstd.EncoderLevel) {
+	encoder.Close()
+	encoder.Reset(nil)
+
+	pool, _ := encoderPools.LoadOrStore(level, &sync.Pool{})
+	pool.(*sync.Pool).Put(encoder)
+}
+
+// contextReader fails reads once its context is canceled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// parseTarFormat maps a tar format name to its archive/tar value, defaulting to PAX
+func parseTarFormat(name string) (tar.Format, error) {
+	switch strings.ToLower(name) {
+	case "", "pax":
+		return tar.FormatPAX, nil
+	case "ustar":
+		return tar.FormatUSTAR, nil
+	case "gnu":
+		return tar.FormatGNU, nil
+	}
+	return tar.FormatUnknown, fmt.Errorf("unsupported tar format: %s", name)
+}
+
+// fitsUSTAR reports whether name fits USTAR's 100 byte name field, optionally
+// split at a slash with up to 155 bytes going to the prefix field.
+func fitsUSTAR(name string) bool {
+	if len(name) <= 100 {
+		return true
+	}
+	for i := 0; i < len(name) && i <= 155; i++ {
+		if name[i] == '/' && len(name)-i-1 <= 100 && len(name)-i-1 > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tarEntry is an input waiting to be written to the archive. Regular files
+// are read through open, whether they are files on disk, URLs fetched at
+// write time or ReaderEntry contents.
+type tarEntry struct {
+	header *tar.Header
+	path   string
+	open   func() (io.ReadCloser, error)
+
+	// local is set when path is a file on disk
+	local bool
+}
+
+// tarBuilder walks inputs into a tar stream and keeps the running totals of
+// a single compression job.
+type tarBuilder struct {
+	tarWriter  archiveWriter
+	req        *CompressRequest
+	logger     *jobLogger
+	format     tar.Format
+	totalSize  int64
+	entryCount int
+	warnings   []string
+
+	// dereferenced lists the symlinked inputs whose targets were archived
+	dereferenced []string
+
+	// rewrittenLinks lists the symlinks whose targets SymlinkRewrite changed
+	rewrittenLinks []LinkRewrite
+
+	// included lists the names of the files written so far
+	included []string
+	partial  bool
+
+	// names holds every non-directory entry name written so far
+	names map[string]bool
+
+	progress *progressTracker
+
+	// readLimiter throttles reading inputs when MaxBytesPerSec is set
+	readLimiter *rateLimiter
+
+	// base holds the files of the base archive of an incremental archive,
+	// and baseReferences the names stored as references to them
+	base           map[string]baseEntry
+	baseReferences []string
+
+	// slowest keeps the slowest files when SlowestFiles is set
+	slowest []FileTiming
+
+	// perFile lists the files added when PerFile is set
+	perFile []FileStat
+
+	// dedupeStored holds the hashes of the contents stored with Dedupe, and
+	// dedupedFiles counts the files that reused one
+	dedupeStored map[string]bool
+	dedupedFiles int
+}
+
+// errDeadlineReached stops adding entries once the request's deadline passes
+var errDeadlineReached = errors.New("deadline reached")
+
+// addInputs writes every input to the archive, in global name order when
+// SortEntries is set or grouped by extension when GroupSimilar is set. It
+// returns errDeadlineReached unwrapped so the caller can still finalize the
+// archive.
+func (b *tarBuilder) addInputs(inputs []ArchiveEntry) error {
+	if !b.req.SortEntries && !b.req.GroupSimilar {
+		// Process each input, in its own frame for framed archives
+		frames, _ := b.tarWriter.(*frameWriter)
+		for _, input := range inputs {
+			if frames != nil {
+				if err := frames.nextFrame(input.Source); err != nil {
+					return err
+				}
+			}
+			if err := b.addToTar(input.Source, input.Target); err != nil {
+				if err == errDeadlineReached {
+					return err
+				}
+				return fmt.Errorf("failed to add %s to archive: %v", input.Source, err)
+			}
+		}
+
+		readers, err := b.readerEntries()
+		if err != nil {
+			return err
+		}
+		for _, entry := range readers {
+			if frames != nil {
+				if err := frames.nextFrame(entry.path); err != nil {
+					return err
+				}
+			}
+			if err := b.writeTarEntry(entry); err != nil {
+				if err == errDeadlineReached {
+					return err
+				}
+				return fmt.Errorf("failed to add %s to archive: %v", entry.path, err)
+			}
+		}
+		return nil
+	}
+
+	// Collect entries from every input first so they can be ordered globally
+	var entries []tarEntry
+	for _, input := range inputs {
+		inputEntries, err := b.collectEntries(input.Source, input.Target)
+		if err != nil {
+			if err == errDeadlineReached {
+				return err
+			}
+			return fmt.Errorf("failed to add %s to archive: %v", input.Source, err)
+		}
+		entries = append(entries, inputEntries...)
+	}
+	readers, err := b.readerEntries()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, readers...)
+
+	if b.req.GroupSimilar {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return similarityKey(entries[i].header) < similarityKey(entries[j].header)
+		})
+	} else {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].header.Name < entries[j].header.Name
+		})
+	}
+
+	for _, entry := range entries {
+		if err := b.writeTarEntry(entry); err != nil {
+			if err == errDeadlineReached {
+				return err
+			}
+			return fmt.Errorf("failed to add %s to archive: %v", entry.path, err)
+		}
+	}
+
+	return nil
+}
+
+// writeProvenance writes a global PAX header recording who created the
+// archive, when, and with which version of the tool.
+func (b *tarBuilder) writeProvenance() error {
+	records := map[string]string{
+		provenancePrefix + "created": time.Now().UTC().Format(time.RFC3339),
+		provenancePrefix + "version": version,
+	}
+
+	if current, err := user.Current(); err == nil {
+		records[provenancePrefix+"user"] = current.Username
+	}
+	if b.req.ProvenanceHostname {
+		if hostname, err := os.Hostname(); err == nil {
+			records[provenancePrefix+"hostname"] = hostname
+		}
+	}
+
+	return b.tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: records})
+}
+
+// similarityKey orders entries for GroupSimilar: everything that isn't a
+// regular file first, then files by lower-cased extension and name.
+func similarityKey(header *tar.Header) string {
+	if header.Typeflag != tar.TypeReg {
+		return "0\x00" + header.Name
+	}
+	return "1\x00" + strings.ToLower(path.Ext(header.Name)) + "\x00" + header.Name
+}
+
+// clampModTime applies ClampMtime to header
+func (b *tarBuilder) clampModTime(header *tar.Header) {
+	if !b.req.ClampMtime.IsZero() && header.ModTime.After(b.req.ClampMtime) {
+		header.ModTime = b.req.ClampMtime
+	}
+}
+
+// contextErr returns the request context's error once it's canceled
+func (b *tarBuilder) contextErr() error {
+	if b.req.Context == nil {
+		return nil
+	}
+	return b.req.Context.Err()
+}
+
+// pastDeadline reports whether the request's deadline, if any, has passed
+func (b *tarBuilder) pastDeadline() bool {
+	return !b.req.Deadline.IsZero() && time.Now().After(b.req.Deadline)
+}
+
+// addToTar adds the file or tree at filePath. Its files are written like
+// the entries of compressReaders, each opened as a reader in turn, as the
+// walk reaches them, so nothing is held for the whole tree.
+func (b *tarBuilder) addToTar(filePath, name string) error {
+	return b.walkEntries(filePath, name, b.writeTarEntry)
+}
+
+// collectEntries builds a tar header for everything under filePath, for
+// archives whose entries are ordered before any is written
+func (b *tarBuilder) collectEntries(filePath, name string) ([]tarEntry, error) {
+	var entries []tarEntry
+	err := b.walkEntries(filePath, name, func(entry tarEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// walkEntries walks filePath and passes a tar header for everything under
+// it to fn, in walk order. Entries are named after filePath's base name, or
+// after name when it is set; a name of "." stores the contents of a
+// directory without a root entry.
+func (b *tarBuilder) walkEntries(filePath, name string, fn func(tarEntry) error) error {
+	if isRemoteURL(filePath) {
+		if b.pastDeadline() {
+			return errDeadlineReached
+		}
+		b.entryCount++
+		if b.req.MaxEntries > 0 && b.entryCount > b.req.MaxEntries {
+			return fmt.Errorf("archive exceeds the maximum of %d entries (stopped at %s)", b.req.MaxEntries, filePath)
+		}
+
+		entries, err := b.remoteEntries(filePath, name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// A symlink given as an input means "archive what it points to", so walk
+	// its target while keeping the link's name. Only that one link is
+	// followed: a target that is itself a link is archived as one, as are
+	// nested links.
+	walkRoot := filePath
+	if info, err := os.Lstat(filePath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve symlink: %v", err)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(filePath), target)
+		}
+		if _, err := os.Lstat(target); err != nil {
+			return fmt.Errorf("failed to resolve symlink: %v", err)
+		}
+		walkRoot = target
+		b.dereferenced = append(b.dereferenced, filePath)
+		b.logger.Printf("Dereferenced input %s -> %s", filePath, target)
+	}
+
+	exclude, err := parseExcludeRules(b.req.Exclude)
+	if err != nil {
+		return err
+	}
+
+	var ignore *tarIgnore
+	if b.req.TarIgnore || len(exclude) > 0 {
+		ignore = newTarIgnore(walkRoot, exclude)
+	}
+
+	return filepath.Walk(walkRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if b.pastDeadline() {
+			return errDeadlineReached
+		}
+		if err := b.contextErr(); err != nil {
+			return err
+		}
+
+		// Leave out what Exclude and the .tarignore files above exclude, and
+		// pick up the rules of every directory that's kept
+		if ignore != nil {
+			if ignore.ignored(path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() && b.req.TarIgnore {
+				if err := ignore.load(path); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Sockets and the like can't be archived, and system directories
+		// are full of them
+		if b.skipSpecial(path, info) {
+			return nil
+		}
+
+		// Stop runaway trees before they exhaust memory or time
+		b.entryCount++
+		if b.req.MaxEntries > 0 && b.entryCount > b.req.MaxEntries {
+			return fmt.Errorf("archive exceeds the maximum of %d entries (stopped at %s)", b.req.MaxEntries, path)
+		}
+
+		// A flattened directory has no entry of its own
+		if name == "." && path == walkRoot {
+			return nil
+		}
+
+		// Nested symlinks are stored as links, with their target as is
+		// unless SymlinkRewrite changes it
+		var link, originalLink string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("failed to read symlink %s: %v", path, err)
+			}
+			originalLink = link
+			if b.req.SymlinkRewrite != nil {
+				linkPath, err := filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				root, err := filepath.Abs(walkRoot)
+				if err != nil {
+					return err
+				}
+				link = b.req.SymlinkRewrite(link, linkPath, root)
+			}
+		}
+
+		// Create tar header
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		// Use the path relative to the input, rooted at its name
+		relPath, err := filepath.Rel(walkRoot, path)
+		if err != nil {
+			return err
+		}
+		rootName := filepath.Base(filePath)
+		if name != "" {
+			rootName = filepath.FromSlash(name)
+		}
+		header.Name = filepath.ToSlash(filepath.Join(rootName, relPath))
+
+		// Let the caller rename or drop the entry; dropping a directory
+		// keeps whatever the transform lets through below it
+		if b.req.NameTransform != nil {
+			transformed, keep := b.req.NameTransform(header.Name)
+			if !keep {
+				return nil
+			}
+			header.Name = transformed
+		}
+
+		// Sanitize the name for the tar format, dropping entries left
+		// without one
+		header.Name = sanitizeTarPath(header.Name)
+		if header.Name == "" {
+			return nil
+		}
+		header.Format = b.format
+
+		// Access and change times vary every time a file is read or touched,
+		// so leave them out to keep archives of unchanged trees identical
+		header.AccessTime = time.Time{}
+		header.ChangeTime = time.Time{}
+
+		if originalLink != link {
+			b.rewrittenLinks = append(b.rewrittenLinks, LinkRewrite{Name: header.Name, From: originalLink, To: link})
+			b.logger.Printf("Rewrote symlink %s: %s -> %s", header.Name, originalLink, link)
+		}
+
+		b.clampModTime(header)
+
+		if b.format == tar.FormatUSTAR {
+			// USTAR can't store long names, so fail early with a clear message
+			if !fitsUSTAR(header.Name) {
+				return fmt.Errorf("entry name %s is too long for the USTAR tar format, use pax or gnu instead", header.Name)
+			}
+
+			// USTAR has no field for sub-second times
+			header.ModTime = header.ModTime.Truncate(time.Second)
+		}
+
+		if b.req.EntryFilter != nil && !b.req.EntryFilter(header) {
+			return nil
+		}
+
+		return fn(tarEntry{header: header, path: path, local: true, open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}})
+	})
+}
+
+func (b *tarBuilder) writeTarEntry(entry tarEntry) error {
+	header := entry.header
+
+	if b.pastDeadline() {
+		return errDeadlineReached
+	}
+	if err := b.contextErr(); err != nil {
+		return err
+	}
+
+	// Directories with the same name simply merge on extraction
+	if header.Typeflag != tar.TypeDir {
+		if err := b.resolveDuplicate(header); err != nil {
+			return err
+		}
+	}
+
+	// Only regular files carry contents
+	if header.Typeflag != tar.TypeReg {
+		return b.tarWriter.WriteHeader(header)
+	}
+
+	// Unchanged files of an incremental archive are only referenced
+	if referenced, err := b.writeBaseReference(entry); referenced || err != nil {
+		return err
+	}
+
+	// So are small files whose contents were already stored
+	if b.req.Dedupe {
+		if deduped, err := b.writeDeduped(entry); deduped || err != nil {
+			return err
+		}
+	}
+
+	started := time.Now()
+
+	file, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Only errors of the file itself are read errors the OnReadError policy
+	// applies to; errors of the wrappers, such as a canceled request, stop
+	// the compression
+	source := &readErrorRecorder{r: file}
+	contents := b.progress.track(header.Name, source)
+	if b.req.Context != nil {
+		// Stop part way through a large file too
+		contents = &contextReader{ctx: b.req.Context, r: contents}
+	}
+	if b.readLimiter != nil {
+		contents = &throttledReader{r: contents, limiter: b.readLimiter}
+	}
+
+	var written int64
+	if b.req.OnReadError == "truncate-entry" {
+		written, err = b.writeSpooledContents(header, contents, source)
+	} else {
+		written, err = b.writeContents(header, contents, source)
+	}
+	if err != nil {
+		return err
+	}
+	b.recordFile(header.Name, written, started)
+
+	return nil
+}
+
+// recordFile counts a file written to the archive in the totals and stats
+func (b *tarBuilder) recordFile(name string, written int64, started time.Time) {
+	b.progress.finishEntry()
+	b.recordTiming(name, written, time.Since(started))
+
+	b.totalSize += written
+	b.included = append(b.included, name)
+	if b.req.PerFile {
+		b.perFile = append(b.perFile, FileStat{Name: name, Size: written})
+	}
+	b.logger.Printf("Added %s (%d bytes)", name, written)
+}
+
+// writeContents writes header and streams the file after it, source being
+// the file under the wrappers of contents. A read error part way through
+// aborts, or with the skip-pad policy pads the entry with zeros up to its
+// declared size and carries on.
+func (b *tarBuilder) writeContents(header *tar.Header, contents io.Reader, source *readErrorRecorder) (int64, error) {
+	if err := b.tarWriter.WriteHeader(header); err != nil {
+		return 0, err
+	}
+
+	n, err := io.CopyN(b.tarWriter, contents, header.Size)
+	if err == nil {
+		return n, nil
+	}
+
+	readErr := b.readError(source, err)
+	if readErr == nil {
+		return n, err // the archive itself couldn't be written, or the request stopped
+	}
+	if b.req.OnReadError != "skip-pad" {
+		return n, readErr
+	}
+
+	// Pad the entry to its declared size so the tar stream stays valid
+	if _, err := io.CopyN(b.tarWriter, zeroReader{}, header.Size-n); err != nil {
+		return n, err
+	}
+	b.warnf("Padded %s with zeros after reading %d of %d bytes: %v", header.Name, n, header.Size, readErr)
+
+	return header.Size, nil
+}
+
+// writeSpooledContents copies the file to a temp spool before writing its
+// header, so a read error part way through can shrink the entry to the bytes
+// actually read. This doubles the I/O and is only used for truncate-entry.
+func (b *tarBuilder) writeSpooledContents(header *tar.Header, contents io.Reader, source *readErrorRecorder) (int64, error) {
+	spool, err := os.CreateTemp("", "zstd_spool")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	n, err := io.CopyN(spool, contents, header.Size)
+	if err != nil {
+		readErr := b.readError(source, err)
+		if readErr == nil {
+			if ctxErr := b.contextErr(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			return 0, fmt.Errorf("failed to write spool file: %v", err)
+		}
+		b.warnf("Truncated %s to %d of %d bytes: %v", header.Name, n, header.Size, readErr)
+		header.Size = n
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := b.tarWriter.WriteHeader(header); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(b.tarWriter, spool)
+}
+
+// recordTiming remembers how long a file took when SlowestFiles is set,
+// keeping only the slowest ones so memory stays bounded.
+func (b *tarBuilder) recordTiming(name string, size int64, elapsed time.Duration) {
+	if b.req.SlowestFiles <= 0 {
+		return
+	}
+
+	timing := FileTiming{Name: name, Size: size, Duration: elapsed.String(), elapsed: elapsed}
+	if elapsed > 0 {
+		timing.Throughput = float64(size) / elapsed.Seconds()
+	}
+	b.slowest = append(b.slowest, timing)
+
+	if len(b.slowest) > 2*b.req.SlowestFiles {
+		b.trimSlowest()
+	}
+}
+
+// trimSlowest sorts the timings slowest first and drops all but SlowestFiles
+func (b *tarBuilder) trimSlowest() {
+	sort.SliceStable(b.slowest, func(i, j int) bool {
+		return b.slowest[i].elapsed > b.slowest[j].elapsed
+	})
+	if len(b.slowest) > b.req.SlowestFiles {
+		b.slowest = b.slowest[:b.req.SlowestFiles]
+	}
+}
+
+// resolveDuplicate applies the OnDuplicate policy when header's name was
+// already written, renaming header in place for the "rename" policy.
+func (b *tarBuilder) resolveDuplicate(header *tar.Header) error {
+	if b.names == nil {
+		b.names = make(map[string]bool)
+	}
+
+	if b.names[header.Name] {
+		switch b.req.OnDuplicate {
+		case "error":
+			return fmt.Errorf("duplicate entry name %s", header.Name)
+
+		case "rename":
+			ext := path.Ext(header.Name)
+			if ext == path.Base(header.Name) {
+				ext = "" // dotfiles like .env have no extension
+			}
+			base := strings.TrimSuffix(header.Name, ext)
+
+			for i := 1; ; i++ {
+				candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+				if !b.names[candidate] {
+					b.warnf("Renamed duplicate entry %s to %s", header.Name, candidate)
+					header.Name = candidate
+					break
+				}
+			}
+
+		default:
+			b.warnf("Duplicate entry %s will overwrite an earlier entry on extraction", header.Name)
+		}
+	}
+
+	b.names[header.Name] = true
+	return nil
+}
+
+// skipSpecial reports whether path is a special file SpecialFiles leaves
+// out, warning about it if so
+func (b *tarBuilder) skipSpecial(path string, info os.FileInfo) bool {
+	mode := info.Mode()
+	switch {
+	case mode&os.ModeSocket != 0:
+		b.warnf("Skipped socket %s", path)
+	case mode&os.ModeIrregular != 0:
+		b.warnf("Skipped %s: unsupported file type", path)
+	case mode&os.ModeDevice != 0 && b.req.SpecialFiles != "devices":
+		b.warnf("Skipped device %s", path)
+	default:
+		return false
+	}
+	return true
+}
+
+func (b *tarBuilder) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	b.warnings = append(b.warnings, warning)
+	b.logger.Printf("Warning: %s", warning)
+}
+
+// readErrorRecorder remembers the last read error so a failed copy can tell
+// a bad source apart from a failed write.
+type readErrorRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// readError returns the read error behind copyErr, treating a source that
+// ended early as io.ErrUnexpectedEOF. It returns nil for write errors.
+func (r *readErrorRecorder) readError(copyErr error) error {
+	if r.err != nil {
+		return r.err
+	}
+	if copyErr == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// readError is source.readError, except that nothing counts as a read error
+// once the request is canceled, whatever made the read fail
+func (b *tarBuilder) readError(source *readErrorRecorder, copyErr error) error {
+	if b.contextErr() != nil {
+		return nil
+	}
+	return source.readError(copyErr)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// expandGlob returns the paths matching pattern inside the working
+// directory. Patterns without a "**" segment are handed to fs.Glob; otherwise
+// the static directory prefix is walked and every regular file matching the
+// full pattern is returned. Directories that can't be read are skipped, and
+// symlinks are only matched when they resolve inside the working directory.
+func expandGlob(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+	if !filepath.IsLocal(pattern) {
+		return nil, fmt.Errorf("%s isn't relative to the working directory", pattern)
+	}
+	pattern = filepath.ToSlash(pattern)
+	segments := strings.Split(pattern, "/")
+
+	root, err := os.OpenRoot(".")
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	fsys := root.FS()
+
+	recursive := false
+	for _, segment := range segments {
+		if segment == "**" {
+			recursive = true
+			break
+		}
+	}
+
+	// Validate the pattern up front so a malformed one is reported as an error
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []string
+	if !recursive {
+		matches, err = fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Walk from the longest prefix that contains no wildcards
+		walkRoot := "."
+		for i, segment := range segments {
+			if strings.ContainsAny(segment, "*?[\\") {
+				if i > 0 {
+					walkRoot = strings.Join(segments[:i], "/")
+				}
+				break
+			}
+		}
+
+		err = fs.WalkDir(fsys, walkRoot, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrPermission) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if matchGlobSegments(segments, strings.Split(p, "/")) {
+				matches = append(matches, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Drop symlinks that point outside the working directory
+	kept := matches[:0]
+	for _, match := range matches {
+		if _, err := root.Stat(match); err == nil {
+			kept = append(kept, filepath.FromSlash(match))
+		}
+	}
+
+	return kept, nil
+}
+
+func matchGlobSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" and try every possible split point
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchGlobSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+func decompressFile(req DecompressRequest) (*extractResult, error) {
+	logger, err := newJobLogger(req.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	defer logger.Close()
+
+	logger.Printf("Decompressing %s to %s", req.Archive, req.OutputDir)
+	startTime := time.Now()
+
+	var sampler *memorySampler
+	if req.ReportMemory {
+		sampler = startMemorySampler()
+	}
+
+	result, err := extractArchive(req, logger)
+	if sampler != nil {
+		peak := sampler.Stop()
+		if err == nil {
+			result.PeakHeapBytes = peak
+			logger.Printf("Peak heap in use: %d bytes", peak)
+		}
+	}
+	if err != nil {
+		logger.Printf("Decompression failed: %v", err)
+		return nil, err
+	}
+
+	result.Duration = time.Since(startTime).String()
+	if info, err := os.Stat(req.Archive); err == nil {
+		result.ArchiveSize = info.Size()
+	}
+	if result.ArchiveSize > 0 {
+		result.ExpansionRatio = float64(result.ExtractedSize) / float64(result.ArchiveSize)
+	}
+
+	logger.Printf("Decompression completed: extracted %d files (%d bytes) to %s in %s, skipped %d oversized entries",
+		result.FileCount, result.ExtractedSize, result.OutputDir, result.Duration, len(result.SkippedEntries))
+
+	return result, nil
+}
+
+func extractArchive(req DecompressRequest, logger *jobLogger) (*extractResult, error) {
+	fsys := req.FS
+	if fsys == nil {
+		fsys = osFS{}
+	}
+
+	if req.ExtractNested {
+		// Nested archives are read back from where they were extracted
+		if req.FS != nil {
+			return nil, fmt.Errorf("nested archives can only be extracted to the OS filesystem")
+		}
+		req.NestedDepth = nestedDepth(req.NestedDepth)
+	}
+
+	var nameCharset *regexp.Regexp
+	if req.NameCharset != "" {
+		var err error
+		if nameCharset, err = parseNameCharset(req.NameCharset); err != nil {
+			return nil, err
+		}
+	}
+
+	var types *typeFilter
+	if len(req.ExtractTypes) > 0 {
+		var err error
+		if types, err = parseTypeFilter(req.ExtractTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	read, err := decompressReadOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the current working directory
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %v", err)
+	}
+
+	// Create the full output path in the current directory
+	fullOutputDir := req.OutputDir
+	if !filepath.IsAbs(fullOutputDir) {
+		fullOutputDir = filepath.Join(cwd, fullOutputDir)
+	}
+
+	if req.Unique {
+		// Pick a name that doesn't collide with an existing directory
+		fullOutputDir = uniquePath(fsys, fullOutputDir)
+	} else if req.ExistingFiles != "" {
+		// Extract into the directory as it is
+	} else if _, err := fsys.Lstat(fullOutputDir); err == nil {
+		// Remove the directory if it already exists
+		fsys.RemoveAll(fullOutputDir)
+	}
+
+	// Create the output directory
+	if err := fsys.MkdirAll(fullOutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	result := &extractResult{DecompressionStats: DecompressionStats{OutputDir: fullOutputDir}}
+	var extractedSize int64
+
+	// Scan first so progress can report totals from the start
+	var totalFiles int
+	var totalBytes int64
+	if req.TwoPass && req.OnProgress != nil {
+		totalFiles, totalBytes, err = countExtractable(req, read, nameCharset, types, fullOutputDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	progress := startProgress(req.OnProgress)
+	defer progress.Stop()
+	if req.TwoPass {
+		progress.setTotals(totalFiles, totalBytes)
+	}
+
+	// Files an incremental archive only references, by name, with their hash
+	references := make(map[string]string)
+	var recordedBase string
+
+	// Places entries, and keeps anything from being written through a
+	// symlink, whether extracted now or left by an earlier run
+	guard := newEntryGuard(fullOutputDir, fsys)
+
+	// Directory times are set last, as extracting into a directory changes
+	// them, and so are the modes of created directories, which may not let
+	// their entries be written
+	type dirTime struct {
+		path    string
+		mtime   time.Time
+		mode    os.FileMode
+		created bool
+	}
+	var dirTimes []dirTime
+
+	// Extracted archives, to extract in turn with ExtractNested
+	var nested []string
+
+	// report records what happened to an entry when a detailed report is on
+	report := func(header *tar.Header, targetPath, action, reason string) {
+		if !req.Detailed {
+			return
+		}
+		result.Entries = append(result.Entries, ExtractedEntry{
+			Name:   header.Name,
+			Path:   targetPath,
+			Size:   header.Size,
+			Mode:   header.FileInfo().Mode().String(),
+			Action: action,
+			Reason: reason,
+		})
+	}
+
+	extractEntry := func(header *tar.Header, body io.Reader) error {
+		if req.Context != nil {
+			if err := req.Context.Err(); err != nil {
+				return err
+			}
+			body = &contextReader{ctx: req.Context, r: body}
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			if base := header.PAXRecords[baseRecord]; base != "" {
+				recordedBase = base
+			}
+			return nil
+		}
+
+		// Let the caller rename or drop the entry before it's sanitized
+		name := header.Name
+		if req.NameTransform != nil {
+			transformed, keep := req.NameTransform(name)
+			if !keep {
+				return nil
+			}
+			name = transformed
+		}
+
+		// Enforce the allowed name characters, on every platform
+		if nameCharset != nil {
+			if sanitized, replaced := applyNameCharset(nameCharset, name); replaced {
+				result.InvalidNames = append(result.InvalidNames, header.Name)
+				switch req.NameCharsetPolicy {
+				case "sanitize":
+					logger.Printf("Renamed %s to %s: name has characters outside the allowed set", header.Name, sanitized)
+					name = sanitized
+				case "abort":
+					return fmt.Errorf("entry %s has characters outside the allowed set", header.Name)
+				default:
+					logger.Printf("Skipped %s: name has characters outside the allowed set", header.Name)
+					report(header, "", "skipped", "name has characters outside the allowed set")
+					return nil
+				}
+			}
+		}
+
+		// Sanitize the header name to prevent path traversal and invalid paths
+		place, risk := guard.place(name, req.AbsolutePathPolicy)
+		if risk != "" {
+			return nil // Skip invalid paths and paths that try to escape
+		}
+		if place.absolute && req.AbsolutePathPolicy == "reject" {
+			return fmt.Errorf("entry %s has an absolute path", header.Name)
+		}
+		cleanName, targetPath := place.cleanName, place.targetPath
+
+		// Skip entries outside the requested subset
+		if len(req.Entries) > 0 && !matchesEntry(filepath.ToSlash(cleanName), req.Entries) {
+			return nil
+		}
+
+		// Skip entries below a symlink, which could point anywhere once
+		// other links are followed
+		if dir, ok := guard.belowSymlink(place); ok {
+			logger.Printf("Skipped %s: its parent directory %s is a symlink", header.Name, filepath.ToSlash(dir))
+			report(header, targetPath, "skipped", "parent directory is a symlink")
+			return nil
+		}
+
+		// Skip entries of other types; referenced files are checked once
+		// their contents are read from the base
+		if types != nil && header.PAXRecords[baseHashRecord] == "" {
+			var matched bool
+			switch header.Typeflag {
+			case tar.TypeDir:
+				return nil
+			case tar.TypeReg:
+				matched, body = types.match(cleanName, body)
+			default:
+				matched, _ = types.match(cleanName, nil)
+			}
+			if !matched {
+				result.TypeSkipped++
+				report(header, targetPath, "skipped", "type not selected")
+				return nil
+			}
+			result.TypeMatched++
+		}
+
+		// Skip (or abort on) files larger than the per-entry limit
+		if req.MaxEntrySize > 0 && header.Typeflag == tar.TypeReg && header.Size > req.MaxEntrySize {
+			if req.EntrySizePolicy == "abort" {
+				return fmt.Errorf("entry %s is %d bytes, exceeding the maximum entry size of %d bytes", header.Name, header.Size, req.MaxEntrySize)
+			}
+			result.SkippedEntries = append(result.SkippedEntries, header.Name)
+			logger.Printf("Skipped %s: %d bytes exceeds the maximum entry size of %d bytes", header.Name, header.Size, req.MaxEntrySize)
+			report(header, targetPath, "skipped", "exceeds the maximum entry size")
+			return nil
+		}
+
+		// Guard against archives that expand far beyond what the caller allows
+		if header.Typeflag == tar.TypeReg {
+			extractedSize += header.Size
+			if req.MaxTotalSize > 0 && extractedSize > req.MaxTotalSize {
+				return fmt.Errorf("archive exceeds the maximum total extraction size of %d bytes", req.MaxTotalSize)
+			}
+		}
+
+		// Ensure target directory exists
+		if err := fsys.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+
+		// Whether an earlier run or entry already put something at the path
+		existing, statErr := fsys.Lstat(targetPath)
+		exists := statErr == nil
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			// Replace a symlink rather than set times and modes through it
+			if exists && existing.Mode()&os.ModeSymlink != 0 {
+				if err := fsys.Remove(targetPath); err != nil {
+					return fmt.Errorf("failed to replace %s: %v", targetPath, err)
+				}
+				exists = false
+			}
+			if err := fsys.MkdirAll(targetPath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", targetPath, err)
+			}
+			dirTimes = append(dirTimes, dirTime{path: targetPath, mtime: header.ModTime, mode: entryMode(header, req.StripSetuid), created: !exists})
+			if exists {
+				report(header, targetPath, "existing", "")
+			} else {
+				report(header, targetPath, "created", "")
+			}
+
+		case tar.TypeReg:
+			// Referenced files are extracted from the base afterwards
+			if hash := header.PAXRecords[baseHashRecord]; hash != "" {
+				references[header.Name] = hash
+				return nil
+			}
+
+			if exists && req.ExistingFiles == "skip" {
+				logger.Printf("Skipped %s: %s already exists", header.Name, targetPath)
+				report(header, targetPath, "skipped", "already exists")
+				return nil
+			}
+			action := "created"
+			if exists {
+				action = "overwritten"
+
+				// Replace a symlink rather than write through it
+				if existing.Mode()&os.ModeSymlink != 0 {
+					if err := fsys.Remove(targetPath); err != nil {
+						return fmt.Errorf("failed to replace %s: %v", targetPath, err)
+					}
+				}
+			}
+
+			// Never copy more than the per-entry limit, whatever the header claims
+			var reader io.Reader = body
+			if req.MaxEntrySize > 0 {
+				reader = &io.LimitedReader{R: body, N: req.MaxEntrySize}
+			}
+			reader = progress.track(cleanName, reader)
+
+			if req.MaxExtractedFileSize > 0 && header.Size > req.MaxExtractedFileSize {
+				parts, written, err := extractSplitFile(fsys, targetPath, reader, header, req.MaxExtractedFileSize)
+				if err != nil {
+					return err
+				}
+
+				result.FileCount++
+				result.ExtractedSize += written
+				progress.finishEntry()
+				result.SplitFiles = append(result.SplitFiles, header.Name)
+				logger.Printf("Extracted %s (%d bytes) in %d parts", cleanName, header.Size, parts)
+				report(header, targetPath, action, "split into parts")
+				return nil
+			}
+
+			mode := entryMode(header, req.StripSetuid)
+			outFile, err := fsys.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return fmt.Errorf("failed to create file %s: %v", targetPath, err)
+			}
+
+			written, err := io.Copy(outFile, reader)
+			outFile.Close()
+			result.ExtractedSize += written
+			if err != nil && req.Context != nil && req.Context.Err() != nil {
+				// Canceled part way, so don't leave a truncated file behind
+				fsys.Remove(targetPath)
+				return err
+			}
+			if err != nil {
+				return fmt.Errorf("failed to extract file %s: %v", targetPath, err)
+			}
+			// The umask applied on creation, and an overwritten file keeps its
+			// old mode, so set the recorded one explicitly
+			if err := fsys.Chmod(targetPath, mode); err != nil {
+				logger.Printf("Failed to set the mode of %s: %v", targetPath, err)
+			}
+			// Keep the recorded times; archives usually have no access time
+			if !header.ModTime.IsZero() {
+				atime := header.AccessTime
+				if atime.IsZero() {
+					atime = header.ModTime
+				}
+				if err := fsys.Chtimes(targetPath, atime, header.ModTime); err != nil {
+					logger.Printf("Failed to set the modification time of %s: %v", targetPath, err)
+				}
+			}
+
+			result.FileCount++
+			progress.finishEntry()
+			logger.Printf("Extracted %s (%d bytes)", cleanName, header.Size)
+			report(header, targetPath, action, "")
+
+			if req.ExtractNested && isArchiveName(cleanName) {
+				nested = append(nested, targetPath)
+			}
+
+		case tar.TypeSymlink:
+			// Only links that resolve inside the output directory, without
+			// passing through other links, are allowed
+			linkTarget, reason := guard.checkLink(place, header.Linkname)
+			if reason != "" {
+				logger.Printf("Skipped symlink %s -> %s: %s", header.Name, header.Linkname, reason)
+				report(header, targetPath, "skipped", reason)
+				return nil
+			}
+
+			if exists && req.ExistingFiles == "skip" {
+				logger.Printf("Skipped %s: %s already exists", header.Name, targetPath)
+				report(header, targetPath, "skipped", "already exists")
+				return nil
+			}
+
+			// Replace whatever an earlier entry left at the path
+			if err := fsys.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to replace %s: %v", targetPath, err)
+			}
+			if err := fsys.Symlink(linkTarget, targetPath); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %v", targetPath, err)
+			}
+
+			guard.addLink(place)
+			logger.Printf("Extracted symlink %s -> %s", cleanName, filepath.ToSlash(linkTarget))
+			if exists {
+				report(header, targetPath, "overwritten", "")
+			} else {
+				report(header, targetPath, "created", "")
+			}
+		}
+
+		return nil
+	}
+
+	// Extract files
+	if err := walkArchiveWith(req.Archive, read, extractEntry); err != nil {
+		return nil, err
+	}
+
+	// Then the unchanged files of an incremental archive, from its base
+	if len(references) > 0 {
+		baseArchive := req.BaseArchive
+		if baseArchive == "" {
+			if recordedBase == "" {
+				return nil, fmt.Errorf("archive references files in a base archive but doesn't name it")
+			}
+			baseArchive = filepath.Join(filepath.Dir(req.Archive), filepath.Base(recordedBase))
+		}
+
+		err := walkArchiveWith(baseArchive, read, func(header *tar.Header, body io.Reader) error {
+			hash, ok := references[header.Name]
+			if !ok || header.Typeflag != tar.TypeReg || header.PAXRecords[baseHashRecord] != "" {
+				return nil
+			}
+			delete(references, header.Name)
+
+			// Read the whole entry, even if it's skipped, to check the hash
+			hasher := sha256.New()
+			contents := io.TeeReader(body, hasher)
+			if err := extractEntry(header, contents); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, contents); err != nil {
+				return fmt.Errorf("failed to read %s from base archive: %v", header.Name, err)
+			}
+
+			if hex.EncodeToString(hasher.Sum(nil)) != hash {
+				return fmt.Errorf("%s in the base archive doesn't match the archived file", header.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("base archive %s: %v", filepath.Base(baseArchive), err)
+		}
+
+		if len(references) > 0 {
+			return nil, fmt.Errorf("%d unchanged files are missing from base archive %s", len(references), filepath.Base(baseArchive))
+		}
+	}
+
+	// Deepest first, so a read-only parent doesn't stop its children
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		dir := dirTimes[i]
+		if dir.created {
+			if err := fsys.Chmod(dir.path, dir.mode); err != nil {
+				logger.Printf("Failed to set the mode of %s: %v", dir.path, err)
+			}
+		}
+		if dir.mtime.IsZero() {
+			continue
+		}
+		if err := fsys.Chtimes(dir.path, dir.mtime, dir.mtime); err != nil {
+			logger.Printf("Failed to set the modification time of %s: %v", dir.path, err)
+		}
+	}
+
+	result.extractedSize = extractedSize
+	if len(nested) > 0 {
+		budget := int64(nestedMaxTotalSize)
+		if req.MaxTotalSize > 0 {
+			budget = req.MaxTotalSize - extractedSize
+		}
+		if err := extractNested(req, nested, budget, result, logger); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// countExtractable counts the files, and their total size, that extracting
+// req would write, without extracting anything.
+func countExtractable(req DecompressRequest, read readOptions, nameCharset *regexp.Regexp, types *typeFilter, outputDir string) (int, int64, error) {
+	var files int
+	var bytes int64
+
+	// Entries are left out as extractArchive leaves them out. Those that
+	// would abort it needn't be counted, the extraction fails anyway.
+	guard := newEntryGuard(outputDir, nil)
+	err := walkArchiveWith(req.Archive, read, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		name := header.Name
+		if req.NameTransform != nil {
+			transformed, keep := req.NameTransform(name)
+			if !keep {
+				return nil
+			}
+			name = transformed
+		}
+		if nameCharset != nil {
+			if sanitized, replaced := applyNameCharset(nameCharset, name); replaced {
+				if req.NameCharsetPolicy != "sanitize" {
+					return nil
+				}
+				name = sanitized
+			}
+		}
+
+		place, risk := guard.place(name, req.AbsolutePathPolicy)
+		if risk != "" || place.absolute && req.AbsolutePathPolicy == "reject" {
+			return nil
+		}
+		if len(req.Entries) > 0 && !matchesEntry(filepath.ToSlash(place.cleanName), req.Entries) {
+			return nil
+		}
+		if types != nil && header.PAXRecords[baseHashRecord] == "" {
+			if matched, _ := types.match(place.cleanName, body); !matched {
+				return nil
+			}
+		}
+		if req.MaxEntrySize > 0 && header.Size > req.MaxEntrySize {
+			return nil
+		}
+
+		files++
+		bytes += header.Size
+		return nil
+	})
+
+	return files, bytes, err
+}
+
+// extractSplitFile writes r to targetPath.part001, targetPath.part002, ...
+// with at most partSize bytes each, followed by a targetPath.parts.json
+// manifest. It returns the number of parts written and their total size.
+func extractSplitFile(fsys ExtractFS, targetPath string, r io.Reader, header *tar.Header, partSize int64) (int, int64, error) {
+	manifest := splitManifest{Name: filepath.Base(targetPath)}
+
+	for {
+		partName := fmt.Sprintf("%s.part%03d", filepath.Base(targetPath), len(manifest.Parts)+1)
+		partPath := filepath.Join(filepath.Dir(targetPath), partName)
+
+		partFile, err := fsys.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to create file %s: %v", partPath, err)
+		}
+
+		n, err := io.CopyN(partFile, r, partSize)
+		partFile.Close()
+		if err != nil && err != io.EOF {
+			return 0, 0, fmt.Errorf("failed to extract file %s: %v", partPath, err)
+		}
+
+		if n == 0 && len(manifest.Parts) > 0 {
+			// The previous part ended exactly at the end of the file
+			fsys.Remove(partPath)
+			break
+		}
+
+		manifest.Parts = append(manifest.Parts, partName)
+		manifest.Size += n
+		if err == io.EOF {
+			break
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to encode split manifest: %v", err)
+	}
+	manifestFile, err := fsys.OpenFile(targetPath+".parts.json", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err == nil {
+		_, err = manifestFile.Write(data)
+		if closeErr := manifestFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to write split manifest: %v", err)
+	}
+
+	return len(manifest.Parts), manifest.Size, nil
+}
+
+// jobLogger writes a timestamped progress and result log for a single job.
+// A nil *jobLogger discards everything, so callers never need to check.
+type jobLogger struct {
+	file   *os.File
+	logger *log.Logger
+}
+
+// newJobLogger opens the log file a request asked for. The name is resolved
+// inside the server's working directory, and an existing file is only
+// overwritten when it is a regular ".log" file, so a request can't write
+// anywhere else or clobber an archive.
+func newJobLogger(name string) (*jobLogger, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("log file %s must be a relative path inside the working directory", name)
+	}
+
+	root, err := os.OpenRoot(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file: %v", err)
+	}
+	defer root.Close()
+
+	if info, err := root.Lstat(name); err == nil && (!info.Mode().IsRegular() || filepath.Ext(name) != ".log") {
+		return nil, fmt.Errorf("log file %s already exists and isn't a log", name)
+	}
+
+	file, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file: %v", err)
+	}
+
+	return &jobLogger{file: file, logger: log.New(file, "", log.LstdFlags)}, nil
+}
+
+func (l *jobLogger) Printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.logger.Printf(format, args...)
+}
+
+func (l *jobLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// newArchiveDecoder detects whether r holds zstd, gzip or xz data by its
+// magic bytes and returns a reader for the decompressed stream.
+func newArchiveDecoder(r io.Reader, dictionaries ...[]byte) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(xzMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		// Name the dictionary the archive needs rather than failing mid-stream
+		frameHeader, _ := buffered.Peek(zstd.HeaderMaxSize)
+		if id := frameDictionaryID(frameHeader); id != 0 && !hasDictionary(dictionaries, id) {
+			return nil, fmt.Errorf("archive was compressed with zstd dictionary %d, which wasn't given", id)
+		}
+
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderDicts(dictionaries...))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %v", err)
+		}
+		return decoder.IOReadCloser(), nil
+
+	case bytes.HasPrefix(magic, gzipMagic):
+		decoder, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip decoder: %v", err)
+		}
+		return decoder, nil
+
+	case bytes.HasPrefix(magic, xzMagic):
+		decoder, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create xz decoder: %v", err)
+		}
+		return io.NopCloser(decoder), nil
+	}
+
+	return nil, fmt.Errorf("unrecognized archive format, expected zstd, gzip or xz")
+}
+
+// trimArchiveExt strips a known archive extension such as .zst or .tar.gz,
+// and the .age of an encrypted one
+func trimArchiveExt(name string) string {
+	name = strings.TrimSuffix(name, ageExt)
+	for _, ext := range []string{".tar.zst", ".zst", ".tar.gz", ".tgz", ".gz", ".tar.xz", ".txz", ".xz", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// uniquePath returns path, or the first of path-1, path-2, ... that doesn't exist
+func uniquePath(fsys ExtractFS, path string) string {
+	candidate := path
+	for i := 1; ; i++ {
+		if _, err := fsys.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", path, i)
+	}
+}
+
+// matchesEntry reports whether name is one of entries or lies below one of them
+func matchesEntry(name string, entries []string) bool {
+	for _, entry := range entries {
+		entry = strings.Trim(filepath.ToSlash(entry), "/")
+		if name == entry || strings.HasPrefix(name, entry+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+const summaryLargestEntries = 10
+
+// ArchiveSummary describes what extracting an archive would produce
+type ArchiveSummary struct {
+	TotalSize      int64              `json:"totalSize"`
+	FileCount      int                `json:"fileCount"`
+	DirectoryCount int                `json:"directoryCount"`
+	LargestEntries []ArchiveEntryInfo `json:"largestEntries"`
+}
+
+type ArchiveEntryInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// scanArchive calls fn for every header in the archive without reading the
+// entry bodies.
+func scanArchive(archiveFile string, fn func(header *tar.Header) error) error {
+	return walkArchive(archiveFile, func(header *tar.Header, _ io.Reader) error {
+		return fn(header)
+	})
+}
+
+// walkArchive calls fn for every entry of a compressed tar or zip archive, or
+// of all volumes of a split archive, along with a reader for the entry's
+// contents.
+func walkArchive(archiveFile string, fn func(header *tar.Header, body io.Reader) error) error {
+	return walkArchiveWith(archiveFile, readOptions{}, fn)
+}
+
+// readOptions holds what's needed to read archives encrypted to a
+// password or to age recipients, or compressed with zstd dictionaries
+type readOptions struct {
+	identities   []age.Identity
+	dictionaries [][]byte
+}
+
+// decompressReadOptions parses the password, identities and dictionaries
+// req gives to read its archive with
+func decompressReadOptions(req DecompressRequest) (readOptions, error) {
+	var read readOptions
+	var err error
+	if read.identities, err = passwordIdentities(req.Password); err != nil {
+		return read, err
+	}
+	if req.Identity != "" {
+		keys, err := parseIdentities(req.Identity)
+		if err != nil {
+			return read, err
+		}
+		read.identities = append(read.identities, keys...)
+	}
+	for _, path := range req.Dictionaries {
+		dictionary, err := loadDictionary(path)
+		if err != nil {
+			return read, err
+		}
+		read.dictionaries = append(read.dictionaries, dictionary)
+	}
+	return read, nil
+}
+
+// walkArchiveWith is walkArchive for archives that may be encrypted, to a
+// password or to recipients, or compressed with a dictionary
+func walkArchiveWith(archiveFile string, read readOptions, fn func(header *tar.Header, body io.Reader) error) error {
+	// A split archive is read through its index, volume by volume
+	if strings.HasSuffix(archiveFile, volumeIndexSuffix) {
+		return walkVolumes(archiveFile, read, fn)
+	}
+
+	file, err := os.Open(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	// Zip archives need random access, so they can't go through a decoder
+	magic := make([]byte, len(zipMagic))
+	if _, err := file.ReadAt(magic, 0); err == nil && bytes.Equal(magic, zipMagic) {
+		return walkZipArchive(file, fn)
+	}
+
+	contents, err := decryptArchive(file, read.identities)
+	if err != nil {
+		return err
+	}
+
+	// Pick the decoder from the archive's magic bytes
+	decoder, err := newArchiveDecoder(contents, read.dictionaries...)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	// A single file compressed by the zstd, gzip or xz tools has no tar
+	// inside; an empty stream is an archive with no entries
+	buffered := bufio.NewReaderSize(decoder, 64*1024)
+	block, _ := buffered.Peek(tarBlockSize)
+	if len(block) == 0 {
+		return nil
+	}
+	if len(block) < tarBlockSize || !isTarHeader(block) {
+		decoder.Close()
+		return walkRawStream(archiveFile, read, fn)
+	}
+
+	// Deduplicated files are handed on with their contents, as any other
+	var store dedupeStore
+	defer store.remove()
+
+	tarReader := tar.NewReader(buffered)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %v", err)
+		}
+
+		header, body, err := store.resolve(header, tarReader)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			continue
+		}
+		if err := fn(header, body); err != nil {
+			return err
+		}
+	}
+}
+
+// tarBlockSize is the size of a tar header block
+const tarBlockSize = 512
+
+// isTarHeader reports whether block holds a tar header with a valid
+// checksum, or is the all-zero block that ends (or makes up) an empty tar
+func isTarHeader(block []byte) bool {
+	recorded := strings.Trim(string(block[148:156]), " \x00")
+	if recorded == "" {
+		return bytes.Count(block, []byte{0}) == len(block)
+	}
+	want, err := strconv.ParseInt(recorded, 8, 64)
+	if err != nil {
+		return false
+	}
+
+	// The checksum is computed with its own field taken as spaces
+	var sum int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += int64(b)
+	}
+	return sum == want
+}
+
+// walkRawStream calls fn once for a compressed file that isn't a tar, as an
+// entry named after the archive without its compression extension. The
+// stream is decoded once to learn its size and again for fn.
+func walkRawStream(archiveFile string, read readOptions, fn func(header *tar.Header, body io.Reader) error) error {
+	open := func() (*os.File, io.ReadCloser, error) {
+		file, err := os.Open(archiveFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open archive: %v", err)
+		}
+		contents, err := decryptArchive(file, read.identities)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		decoder, err := newArchiveDecoder(contents, read.dictionaries...)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return file, decoder, nil
+	}
+
+	file, decoder, err := open()
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(io.Discard, decoder)
+	decoder.Close()
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decompress archive: %v", err)
+	}
+
+	info, err := os.Stat(archiveFile)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+
+	name := trimArchiveExt(filepath.Base(archiveFile))
+	if name == "" || name == filepath.Base(archiveFile) {
+		name += ".out"
+	}
+
+	file, decoder, err = open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	defer decoder.Close()
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  info.ModTime(),
+	}
+	return fn(header, decoder)
+}
+
+func summarizeArchive(archiveFile string) (*ArchiveSummary, error) {
+	summary := &ArchiveSummary{LargestEntries: []ArchiveEntryInfo{}}
+
+	err := scanArchive(archiveFile, func(header *tar.Header) error {
+		switch header.Typeflag {
+		case tar.TypeDir:
+			summary.DirectoryCount++
+		case tar.TypeReg:
+			summary.FileCount++
+			summary.TotalSize += header.Size
+			summary.LargestEntries = append(summary.LargestEntries, ArchiveEntryInfo{Name: header.Name, Size: header.Size})
+
+			// Keep only the largest entries so memory stays bounded
+			if len(summary.LargestEntries) > 2*summaryLargestEntries {
+				sortEntriesBySize(summary.LargestEntries)
+				summary.LargestEntries = summary.LargestEntries[:summaryLargestEntries]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortEntriesBySize(summary.LargestEntries)
+	if len(summary.LargestEntries) > summaryLargestEntries {
+		summary.LargestEntries = summary.LargestEntries[:summaryLargestEntries]
+	}
+
+	return summary, nil
+}
+
+func sortEntriesBySize(entries []ArchiveEntryInfo) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Size > entries[j].Size
+	})
+}
+
+func handleExtractPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	summary, err := summarizeArchive(archive)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to scan archive: %v", err), nil)
+		return
+	}
+
+	sendResponse(w, true, fmt.Sprintf("Extraction would produce %d files in %d directories", summary.FileCount, summary.DirectoryCount), summary)
+}
+
+type ArchiveListEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	Type    string    `json:"type"`
+
+	// Depth is how many archives deep a recursive listing found the entry
+	Depth int `json:"depth,omitempty"`
+
+	// SHA256 is the hash of a file's contents when listed with hashes;
+	// HashSkipped marks files over the size cap or past the time budget
+	SHA256      string `json:"sha256,omitempty"`
+	HashSkipped bool   `json:"hashSkipped,omitempty"`
+}
+
+func newArchiveListEntry(header *tar.Header) ArchiveListEntry {
+	entryType := "other"
+	switch header.Typeflag {
+	case tar.TypeReg:
+		entryType = "file"
+	case tar.TypeDir:
+		entryType = "directory"
+	case tar.TypeSymlink:
+		entryType = "symlink"
+	case tar.TypeLink:
+		entryType = "hardlink"
+	}
+
+	return ArchiveListEntry{
+		Name:    header.Name,
+		Size:    header.Size,
+		Mode:    header.FileInfo().Mode().String(),
+		ModTime: header.ModTime,
+		Type:    entryType,
+	}
+}
+
+// listArchive lists the entries of an archive, with the hashes of its files
+// when withHashes is set
+func listArchive(archiveFile string, withHashes bool) ([]ArchiveListEntry, error) {
+	entries := []ArchiveListEntry{}
+	hashDeadline := time.Now().Add(listHashTimeout)
+
+	err := walkArchive(archiveFile, func(header *tar.Header, body io.Reader) error {
+		// Archive metadata isn't an entry
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+
+		entry := newArchiveListEntry(header)
+		if withHashes && header.Typeflag == tar.TypeReg {
+			sum, err := archiveEntryHash(header, body, hashDeadline)
+			if err != nil {
+				return err
+			}
+			entry.SHA256, entry.HashSkipped = sum, sum == ""
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// archiveEntryHash returns the hex SHA-256 of a file in an archive. An
+// incremental archive's references record the hash of the file they stand
+// for; archives keep no hash for any other file (the .sha256 sidecar covers
+// the whole archive), so those are hashed as they are read, unless they are
+// over the size cap or the time budget has run out, which gives an empty
+// hash.
+func archiveEntryHash(header *tar.Header, body io.Reader, deadline time.Time) (string, error) {
+	if sum := header.PAXRecords[baseHashRecord]; sum != "" {
+		return sum, nil
+	}
+	if header.Size > listHashMaxFileSize || time.Now().After(deadline) {
+		return "", nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, body); err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", header.Name, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// handleListArchive lists the entries of an archive as JSON, or as a
+// downloadable CSV or TSV file with format=csv or format=tsv. recursive=1
+// also lists the entries of archives inside it, down to depth=N levels, and
+// hashes=true adds the SHA-256 of every file.
+func handleListArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json", "csv", "tsv":
+	default:
+		sendResponse(w, false, "Invalid listing format", nil)
+		return
+	}
+
+	withHashes := r.URL.Query().Get("hashes") == "true"
+	recursive := r.URL.Query().Get("recursive") == "1"
+	if withHashes && recursive {
+		sendResponse(w, false, "Hashes can't be combined with a recursive listing", nil)
+		return
+	}
+
+	var entries []ArchiveListEntry
+	var err error
+	if recursive {
+		depth, _ := strconv.Atoi(r.URL.Query().Get("depth"))
+		entries, err = listArchiveRecursive(archive, nestedDepth(depth))
+	} else {
+		entries, err = listArchive(archive, withHashes)
+	}
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to read archive: %v", err), nil)
+		return
+	}
+
+	if format == "" || format == "json" {
+		sendResponse(w, true, fmt.Sprintf("Archive contains %d entries", len(entries)), entries)
+		return
+	}
+
+	writer := csv.NewWriter(w)
+	contentType := "text/csv"
+	if format == "tsv" {
+		writer.Comma = '\t'
+		contentType = "text/tab-separated-values"
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": trimArchiveExt(filepath.Base(archive)) + "." + format}))
+
+	columns := []string{"name", "size", "mode", "mtime", "type"}
+	if withHashes {
+		columns = append(columns, "sha256")
+	}
+	writer.Write(columns)
+	for _, entry := range entries {
+		row := []string{
+			entry.Name,
+			strconv.FormatInt(entry.Size, 10),
+			entry.Mode,
+			entry.ModTime.UTC().Format(time.RFC3339),
+			entry.Type,
 		}
-		if err != nil {
-			return 0, "", fmt.Errorf("failed to read tar header: %v", err)
+		if withHashes {
+			row = append(row, entry.SHA256)
 		}
+		writer.Write(row)
+	}
+	writer.Flush()
+}
 
-		// Sanitize the header name to prevent path traversal and invalid paths
-		cleanName := sanitizeExtractPath(header.Name)
-		if cleanName == "" {
-			continue // Skip invalid paths
-		}
+// provenancePrefix namespaces the PAX records written by writeProvenance
+const provenancePrefix = "ZSTDCOMPRESSOR."
 
-		targetPath := filepath.Join(fullOutputDir, cleanName)
+// errMetadataRead stops scanning once the leading global headers are read
+var errMetadataRead = errors.New("metadata read")
 
-		// Ensure the target path is within the output directory (prevent path traversal)
-		if !strings.HasPrefix(targetPath, filepath.Clean(fullOutputDir)+string(os.PathSeparator)) {
-			continue // Skip paths that try to escape the output directory
-		}
+// readArchiveMetadata returns the provenance recorded in an archive's global
+// PAX headers, without the key prefix. Archives without any return an empty map.
+func readArchiveMetadata(archiveFile string) (map[string]string, error) {
+	metadata := map[string]string{}
 
-		// Ensure target directory exists
-		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-			return 0, "", fmt.Errorf("failed to create directory: %v", err)
+	err := scanArchive(archiveFile, func(header *tar.Header) error {
+		if header.Typeflag != tar.TypeXGlobalHeader {
+			return errMetadataRead
 		}
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return 0, "", fmt.Errorf("failed to create directory %s: %v", targetPath, err)
+		for key, value := range header.PAXRecords {
+			if strings.HasPrefix(key, provenancePrefix) {
+				metadata[strings.TrimPrefix(key, provenancePrefix)] = value
 			}
+		}
+		return nil
+	})
+	if err != nil && err != errMetadataRead {
+		return nil, err
+	}
 
-		case tar.TypeReg:
-			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return 0, "", fmt.Errorf("failed to create file %s: %v", targetPath, err)
-			}
+	return metadata, nil
+}
 
-			_, err = io.Copy(outFile, tarReader)
-			outFile.Close()
-			if err != nil {
-				return 0, "", fmt.Errorf("failed to extract file %s: %v", targetPath, err)
-			}
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-			fileCount++
-		}
+	archive := r.URL.Query().Get("archive")
+	if archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	metadata, err := readArchiveMetadata(archive)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to read archive: %v", err), nil)
+		return
 	}
 
-	return fileCount, fullOutputDir, nil
+	sendResponse(w, true, "Archive metadata read successfully", map[string]interface{}{"metadata": metadata})
 }
 
 func handleUpload(w http.ResponseWriter, r *http.Request) {
@@ -371,11 +4139,11 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse multipart form
-	err := r.ParseMultipartForm(32 << 20) // 32 MB max memory
-	if err != nil {
-		sendUploadResponse(w, false, "Failed to parse form", nil)
+	if err := parseUploadForm(w, r); err != nil {
+		sendUploadResponse(w, false, err.Error(), nil)
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
 
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
@@ -386,33 +4154,22 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 	var filePaths []string
 
 	// Create a temporary directory for uploaded files
-	tempDir, err := ioutil.TempDir("", "zstd_upload")
+	tempDir, err := newUploadDir()
 	if err != nil {
 		sendUploadResponse(w, false, "Failed to create temp directory", nil)
 		return
 	}
 
-	// Save each file
+	// Save each file; a failure discards the files saved before it
 	for _, fileHeader := range files {
-		file, err := fileHeader.Open()
-		if err != nil {
-			sendUploadResponse(w, false, "Failed to open uploaded file", nil)
+		destPath, err := saveUpload(fileHeader, tempDir)
+		if err == errUploadTooLarge {
+			os.RemoveAll(tempDir)
+			sendUploadResponse(w, false, fmt.Sprintf("File %s too large: the limit is %d bytes", fileHeader.Filename, maxUploadBytes), nil)
 			return
 		}
-		defer file.Close()
-
-		// Create destination file
-		destPath := filepath.Join(tempDir, fileHeader.Filename)
-		destFile, err := os.Create(destPath)
-		if err != nil {
-			sendUploadResponse(w, false, "Failed to create destination file", nil)
-			return
-		}
-		defer destFile.Close()
-
-		// Copy file content
-		_, err = io.Copy(destFile, file)
 		if err != nil {
+			os.RemoveAll(tempDir)
 			sendUploadResponse(w, false, "Failed to save file", nil)
 			return
 		}
@@ -434,38 +4191,33 @@ func handleUploadArchive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse multipart form
-	err := r.ParseMultipartForm(32 << 20) // 32 MB max memory
-	if err != nil {
-		sendUploadResponse(w, false, "Failed to parse form", nil)
+	if err := parseUploadForm(w, r); err != nil {
+		sendUploadResponse(w, false, err.Error(), nil)
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
 
-	file, fileHeader, err := r.FormFile("archive")
-	if err != nil {
+	files := r.MultipartForm.File["archive"]
+	if len(files) == 0 {
 		sendUploadResponse(w, false, "Failed to get uploaded file", nil)
 		return
 	}
-	defer file.Close()
 
 	// Create a temporary directory for uploaded files
-	tempDir, err := ioutil.TempDir("", "zstd_upload")
+	tempDir, err := newUploadDir()
 	if err != nil {
 		sendUploadResponse(w, false, "Failed to create temp directory", nil)
 		return
 	}
 
-	// Create destination file
-	destPath := filepath.Join(tempDir, fileHeader.Filename)
-	destFile, err := os.Create(destPath)
-	if err != nil {
-		sendUploadResponse(w, false, "Failed to create destination file", nil)
+	destPath, err := saveUpload(files[0], tempDir)
+	if err == errUploadTooLarge {
+		os.RemoveAll(tempDir)
+		sendUploadResponse(w, false, fmt.Sprintf("Archive too large: the limit is %d bytes", maxUploadBytes), nil)
 		return
 	}
-	defer destFile.Close()
-
-	// Copy file content
-	_, err = io.Copy(destFile, file)
 	if err != nil {
+		os.RemoveAll(tempDir)
 		sendUploadResponse(w, false, "Failed to save file", nil)
 		return
 	}
@@ -490,12 +4242,30 @@ func handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set headers for file download
-	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(filePath))
-	w.Header().Set("Content-Type", "application/octet-stream")
+	// Downloads are attachments unless the caller asks to display them
+	disposition := r.URL.Query().Get("disposition")
+	switch disposition {
+	case "":
+		disposition = "attachment"
+	case "attachment", "inline":
+	default:
+		http.Error(w, "Invalid disposition", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filepath.Base(filePath)}))
+
+	// Name the type by extension; ServeFile sniffs the contents otherwise
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if disposition == "inline" {
+		// Inline HTML or SVG must not run scripts on this origin
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
 
 	// Serve the file
-	http.ServeFile(w, r, filePath)
+	serveFile(w, r, filePath)
 }
 
 func handleDownloadExtracted(w http.ResponseWriter, r *http.Request) {
@@ -511,32 +4281,60 @@ func handleDownloadExtracted(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a zip file of the extracted directory
-	zipPath := dirPath + ".zip"
-	err := zipDirectory(dirPath, zipPath)
+	// deterministic=1 builds the same zip bytes for the same directory
+	// contents, whenever and wherever it's extracted
+	deterministic := r.URL.Query().Get("deterministic") == "1"
+
+	// Zip the extracted directory, or reuse the zip of its current state so
+	// a resumed download gets the same bytes
+	zipPath, stateKey, err := cachedDirectoryZip(dirPath, deterministic)
 	if err != nil {
 		http.Error(w, "Failed to create download package", http.StatusInternalServerError)
 		return
 	}
-	defer os.Remove(zipPath) // Clean up after download
 
-	// Set headers for file download
-	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(zipPath))
+	// Set headers for file download; the ETag lets If-Range resume safely
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(filepath.Clean(dirPath)) + ".zip"}))
 	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("ETag", `"`+stateKey+`"`)
 
 	// Serve the zip file
-	http.ServeFile(w, r, zipPath)
+	serveFile(w, r, zipPath)
 }
 
-func zipDirectory(source, target string) error {
+// deterministicZipTime is the modification time of every entry of a
+// deterministic zip, the earliest time the zip format can store
+var deterministicZipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// zipDirectory zips source into target. Entries are in lexical order, as
+// filepath.Walk visits them, and symlinks are stored as links rather than
+// followed. A deterministic zip also gets fixed modification times and a
+// fixed deflate level, so its bytes only depend on the names, modes and
+// contents of the files.
+func zipDirectory(source, target string, deterministic bool) (err error) {
 	zipfile, err := os.Create(target)
 	if err != nil {
 		return err
 	}
-	defer zipfile.Close()
+	defer func() {
+		if closeErr := zipfile.Close(); err == nil {
+			err = closeErr
+		}
+	}()
 
+	// Closing writes the central directory, without which the zip is unreadable
 	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
+	defer func() {
+		if closeErr := archive.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if deterministic {
+		archive.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		})
+	}
 
 	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -552,10 +4350,16 @@ func zipDirectory(source, target string) error {
 		if err != nil {
 			return err
 		}
+		header.Name = filepath.ToSlash(header.Name)
+
+		if deterministic {
+			header.Modified = deterministicZipTime
+		}
 
+		isLink := info.Mode()&os.ModeSymlink != 0
 		if info.IsDir() {
 			header.Name += "/"
-		} else {
+		} else if !isLink {
 			header.Method = zip.Deflate
 		}
 
@@ -568,6 +4372,16 @@ func zipDirectory(source, target string) error {
 			return nil
 		}
 
+		// A symlink is stored as its target, the way zip and unzip do
+		if isLink {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(writer, filepath.ToSlash(target))
+			return err
+		}
+
 		file, err := os.Open(path)
 		if err != nil {
 			return err
@@ -579,43 +4393,363 @@ func zipDirectory(source, target string) error {
 	})
 }
 
-func listDirectory(dirPath string) ([]map[string]interface{}, error) {
-	entries, err := os.ReadDir(dirPath)
+const (
+	listHashMaxFileSize = 64 << 20 // files larger than this are not hashed in listings
+	listHashTimeout     = 10 * time.Second
+	hashCacheMaxEntries = 10000
+)
+
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// hashCache remembers file hashes until the file's size or mtime changes
+var (
+	hashCacheMu sync.Mutex
+	hashCache   = make(map[string]cachedHash)
+)
+
+// fileHash returns the hex SHA-256 of the file at path, using the cache when
+// the file hasn't changed since it was last hashed.
+func fileHash(path string, info os.FileInfo) (string, error) {
+	hashCacheMu.Lock()
+	cached, ok := hashCache[path]
+	hashCacheMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
 	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
 
-	var files []map[string]interface{}
+	hashCacheMu.Lock()
+	if len(hashCache) >= hashCacheMaxEntries {
+		hashCache = make(map[string]cachedHash)
+	}
+	hashCache[path] = cachedHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	hashCacheMu.Unlock()
+
+	return sum, nil
+}
+
+// listDirectory describes up to limit entries of dirPath starting at offset,
+// plus the ".." entry, and returns the total number of entries. Entries are
+// ordered by sortBy ("name", the default, "size" or "modTime"), descending
+// when desc is set; ".." always comes first.
+func listDirectory(dirPath string, withHashes bool, offset, limit int, sortBy string, desc bool) ([]map[string]interface{}, int, error) {
+	entries, total, err := readDirWindow(dirPath, offset, limit, sortBy, desc)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	files := []map[string]interface{}{}
+	hashDeadline := time.Now().Add(listHashTimeout)
 
 	// Add parent directory entry if not root
-	if dirPath != "/" && dirPath != "." {
-		parent := filepath.Dir(dirPath)
-		files = append(files, map[string]interface{}{
-			"name":    "..",
-			"path":    parent,
-			"isDir":   true,
-			"size":    0,
-			"modTime": "",
-		})
+	if parent := parentEntry(dirPath); parent != nil {
+		files = append(files, parent)
+	}
+
+	for _, entry := range entries {
+		if file := describeEntry(dirPath, entry, withHashes, hashDeadline); file != nil {
+			files = append(files, file)
+		}
+	}
+
+	return files, total, nil
+}
+
+// readDirWindow reads the entries of dirPath, orders them as listDirectory
+// does, and returns up to limit of them starting at offset, with the total
+// number of entries
+func readDirWindow(dirPath string, offset, limit int, sortBy string, desc bool) ([]os.DirEntry, int, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	sortDirEntries(entries, sortBy, desc)
+
+	total := len(entries)
+	if offset > total {
+		offset = total
+	}
+	if offset+limit < total {
+		entries = entries[offset : offset+limit]
+	} else {
+		entries = entries[offset:]
+	}
+	return entries, total, nil
+}
+
+// sortDirEntries orders entries, which os.ReadDir returns by name, by
+// sortBy. Entries with the same size or time stay in name order.
+func sortDirEntries(entries []os.DirEntry, sortBy string, desc bool) {
+	if sortBy == "" || sortBy == "name" {
+		if desc {
+			slices.Reverse(entries)
+		}
+		return
+	}
+
+	// Stat everything once up front; entries gone since read sort as empty
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			infos[entry.Name()] = info
+		}
+	}
+	key := func(entry os.DirEntry) int64 {
+		info := infos[entry.Name()]
+		if info == nil {
+			return 0
+		}
+		if sortBy == "size" {
+			return info.Size()
+		}
+		return info.ModTime().UnixNano()
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if desc {
+			return key(entries[i]) > key(entries[j])
+		}
+		return key(entries[i]) < key(entries[j])
+	})
+}
+
+// streamDirectoryBatch is how many entries are read, and described,
+// between flushes when streaming a listing.
+const streamDirectoryBatch = 1000
+
+// streamDirectory writes the response listDirectory's entries would get
+// from handleListFiles, with data holding everything but the files, but
+// describes and flushes them in batches so the client can start on the
+// first entries early. Sorting needs every name up front, so a sorted
+// stream still reads the whole directory before writing; with sorted unset
+// the entries come in directory order, read a batch at a time, and memory
+// doesn't grow with the directory.
+func streamDirectory(w http.ResponseWriter, dirPath string, withHashes bool, offset, limit int, sorted bool, sortBy string, desc bool, data map[string]interface{}) {
+	if !sorted {
+		streamDirectoryUnsorted(w, dirPath, withHashes, offset, limit, data)
+		return
+	}
+
+	entries, total, err := readDirWindow(dirPath, offset, limit, sortBy, desc)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to list directory: %v", err), nil)
+		return
 	}
+	data["total"] = total
+	data["truncated"] = offset+limit < total
 
+	stream := startListingStream(w, dirPath, withHashes, data)
+	if stream == nil {
+		return
+	}
 	for _, entry := range entries {
-		info, err := entry.Info()
+		if err := stream.writeEntry(entry); err != nil {
+			log.Printf("Failed to stream the listing of %s: %v", dirPath, err)
+			return
+		}
+	}
+	stream.finish(nil)
+}
+
+// streamDirectoryUnsorted streams the entries of dirPath in the order the
+// directory returns them, reading streamDirectoryBatch at a time. The total
+// is only known once the whole directory has been read, so it and
+// truncated follow the files.
+func streamDirectoryUnsorted(w http.ResponseWriter, dirPath string, withHashes bool, offset, limit int, data map[string]interface{}) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to list directory: %v", err), nil)
+		return
+	}
+	defer dir.Close()
+
+	// Read the first batch before answering, so a bad path still gets an error response
+	batch, err := dir.ReadDir(streamDirectoryBatch)
+	if err != nil && err != io.EOF {
+		sendResponse(w, false, fmt.Sprintf("Failed to list directory: %v", err), nil)
+		return
+	}
+
+	stream := startListingStream(w, dirPath, withHashes, data)
+	if stream == nil {
+		return
+	}
+	total := 0
+	for {
+		for _, entry := range batch {
+			if total >= offset && total < offset+limit {
+				if err := stream.writeEntry(entry); err != nil {
+					log.Printf("Failed to stream the listing of %s: %v", dirPath, err)
+					return
+				}
+			}
+			total++
+		}
+		if err == io.EOF || len(batch) == 0 {
+			break
+		}
+
+		batch, err = dir.ReadDir(streamDirectoryBatch)
+		if err != nil && err != io.EOF {
+			log.Printf("Failed to stream the listing of %s: %v", dirPath, err)
+			return
+		}
+	}
+	stream.finish(map[string]interface{}{"total": total, "truncated": offset+limit < total})
+}
+
+// listingStream writes a listing response one entry at a time
+type listingStream struct {
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	encoder      *json.Encoder
+	dirPath      string
+	withHashes   bool
+	hashDeadline time.Time
+	written      int
+}
+
+// startListingStream writes the start of a successful listing response,
+// with data holding everything but the files, and the ".." entry. It
+// returns nil if the client is gone.
+func startListingStream(w http.ResponseWriter, dirPath string, withHashes bool, data map[string]interface{}) *listingStream {
+	// The data object is written without its closing brace, so the files
+	// and anything finish adds can follow
+	meta, err := json.Marshal(data)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to list directory: %v", err), nil)
+		return nil
+	}
+	message, _ := json.Marshal("Directory listed successfully")
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := fmt.Fprintf(w, `{"success":true,"message":%s,"data":%s,"files":[`, message, meta[:len(meta)-1]); err != nil {
+		return nil
+	}
+
+	stream := &listingStream{
+		w:            w,
+		flusher:      flusher,
+		encoder:      json.NewEncoder(w),
+		dirPath:      dirPath,
+		withHashes:   withHashes,
+		hashDeadline: time.Now().Add(listHashTimeout),
+	}
+	if parent := parentEntry(dirPath); parent != nil {
+		if err := stream.write(parent); err != nil {
+			log.Printf("Failed to stream the listing of %s: %v", dirPath, err)
+			return nil
+		}
+	}
+	return stream
+}
+
+// writeEntry describes entry and writes it, flushing every
+// streamDirectoryBatch entries. Entries gone since they were read are skipped.
+func (s *listingStream) writeEntry(entry os.DirEntry) error {
+	file := describeEntry(s.dirPath, entry, s.withHashes, s.hashDeadline)
+	if file == nil {
+		return nil
+	}
+	if err := s.write(file); err != nil {
+		return err
+	}
+	if s.flusher != nil && s.written%streamDirectoryBatch == 0 {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+func (s *listingStream) write(file map[string]interface{}) error {
+	if s.written > 0 {
+		if _, err := io.WriteString(s.w, ","); err != nil {
+			return err
+		}
+	}
+	s.written++
+	return s.encoder.Encode(file)
+}
+
+// finish closes the files and the response, adding the fields of tail to
+// the data object after the files
+func (s *listingStream) finish(tail map[string]interface{}) {
+	closing := "]"
+	if len(tail) > 0 {
+		fields, err := json.Marshal(tail)
 		if err != nil {
-			continue
+			log.Printf("Failed to stream the listing of %s: %v", s.dirPath, err)
+			return
 		}
+		closing += "," + string(fields[1:len(fields)-1])
+	}
+	if _, err := io.WriteString(s.w, closing+"}}\n"); err != nil {
+		log.Printf("Failed to stream the listing of %s: %v", s.dirPath, err)
+	}
+}
 
-		fullPath := filepath.Join(dirPath, entry.Name())
-		files = append(files, map[string]interface{}{
-			"name":    entry.Name(),
-			"path":    fullPath,
-			"isDir":   entry.IsDir(),
-			"size":    info.Size(),
-			"modTime": info.ModTime().Format("2006-01-02 15:04:05"),
-		})
+// parentEntry returns the ".." entry for dirPath, or nil at the root
+func parentEntry(dirPath string) map[string]interface{} {
+	if dirPath == "/" || dirPath == "." {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"name":    "..",
+		"path":    filepath.Dir(dirPath),
+		"isDir":   true,
+		"size":    0,
+		"modTime": "",
+	}
+}
+
+// describeEntry returns the listing entry for a directory entry, or nil if
+// it can no longer be stat'ed.
+func describeEntry(dirPath string, entry os.DirEntry, withHashes bool, hashDeadline time.Time) map[string]interface{} {
+	info, err := entry.Info()
+	if err != nil {
+		return nil
+	}
+
+	fullPath := filepath.Join(dirPath, entry.Name())
+	file := map[string]interface{}{
+		"name":    entry.Name(),
+		"path":    fullPath,
+		"isDir":   entry.IsDir(),
+		"size":    info.Size(),
+		"modTime": info.ModTime().Format("2006-01-02 15:04:05"),
 	}
 
-	return files, nil
+	// Hash regular files under the size cap until the time budget runs out
+	if withHashes && info.Mode().IsRegular() {
+		hashed := false
+		if info.Size() <= listHashMaxFileSize && time.Now().Before(hashDeadline) {
+			if sum, err := fileHash(fullPath, info); err == nil {
+				file["sha256"] = sum
+				hashed = true
+			}
+		}
+		if !hashed {
+			file["hashSkipped"] = true
+		}
+	}
+
+	return file
 }
 
 func handleListFiles(w http.ResponseWriter, r *http.Request) {
@@ -629,16 +4763,88 @@ func handleListFiles(w http.ResponseWriter, r *http.Request) {
 		dirPath, _ = os.Getwd()
 	}
 
-	files, err := listDirectory(dirPath)
-	if err != nil {
-		sendResponse(w, false, fmt.Sprintf("Failed to list directory: %v", err), nil)
+	withHashes := r.URL.Query().Get("hashes") == "true"
+
+	sortBy := r.URL.Query().Get("sortBy")
+	switch sortBy {
+	case "", "name", "size", "modTime":
+	default:
+		sendResponse(w, false, "Invalid sort key, expected name, size or modTime", nil)
 		return
 	}
+	order := r.URL.Query().Get("order")
+	switch order {
+	case "", "asc", "desc":
+	default:
+		sendResponse(w, false, "Invalid sort order, expected asc or desc", nil)
+		return
+	}
+
+	// Page through large directories, never returning more than the cap.
+	// page and pageSize are the 1-based alternative to offset and limit.
+	offset, limit := 0, maxListEntries
+	if value := r.URL.Query().Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			sendResponse(w, false, "Invalid offset", nil)
+			return
+		}
+	}
+	limitParam := "limit"
+	if r.URL.Query().Get("pageSize") != "" {
+		if r.URL.Query().Get("limit") != "" {
+			sendResponse(w, false, "Give either pageSize or limit, not both", nil)
+			return
+		}
+		limitParam = "pageSize"
+	}
+	if value := r.URL.Query().Get(limitParam); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			sendResponse(w, false, "Invalid "+limitParam, nil)
+			return
+		}
+	}
+	if limit > maxListEntries {
+		limit = maxListEntries
+	}
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 || page > math.MaxInt32 {
+			sendResponse(w, false, "Invalid page", nil)
+			return
+		}
+		if r.URL.Query().Get("offset") != "" {
+			sendResponse(w, false, "Give either page or offset, not both", nil)
+			return
+		}
+		offset = (page - 1) * limit
+	}
 
 	data := map[string]interface{}{
 		"currentPath": dirPath,
-		"files":       files,
+		"offset":      offset,
+		"limit":       limit,
+		"page":        offset/limit + 1,
+		"pageSize":    limit,
+	}
+
+	// Streamed listings are the same, written as the entries are described,
+	// except that with no sortBy or order they come in directory order
+	if r.URL.Query().Get("stream") == "true" {
+		sorted := sortBy != "" || order != ""
+		streamDirectory(w, dirPath, withHashes, offset, limit, sorted, sortBy, order == "desc", data)
+		return
+	}
+
+	files, total, err := listDirectory(dirPath, withHashes, offset, limit, sortBy, order == "desc")
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to list directory: %v", err), nil)
+		return
 	}
+	data["files"] = files
+	data["total"] = total
+	data["truncated"] = offset+limit < total
 
 	sendResponse(w, true, "Directory listed successfully", data)
 }
@@ -706,6 +4912,26 @@ func sanitizeTarPath(path string) string {
 	// Replace backslashes with forward slashes
 	path = strings.ReplaceAll(path, "\\", "/")
 
+	// Resolve . and .. elements so the name can't climb out of the directory
+	// it's extracted into, whatever a name transform returned
+	dir := strings.HasSuffix(path, "/")
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	path = strings.Join(parts, "/")
+	if dir && path != "" {
+		path += "/"
+	}
+
 	// Remove any remaining invalid characters
 	invalidChars := regexp.MustCompile(`[<>:"|?*]`)
 	path = invalidChars.ReplaceAllString(path, "_")
@@ -713,17 +4939,38 @@ func sanitizeTarPath(path string) string {
 	return path
 }
 
+// entryMode returns the permissions to extract an entry with: the 12 mode
+// bits the archive recorded, less setuid and setgid when strip is set
+func entryMode(header *tar.Header, strip bool) os.FileMode {
+	mode := header.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if strip {
+		mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+	return mode
+}
+
+// isAbsoluteEntry reports whether an archive entry names an absolute path,
+// on Unix or Windows
+func isAbsoluteEntry(name string) bool {
+	return strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") ||
+		len(name) >= 2 && name[1] == ':'
+}
+
 func sanitizeExtractPath(path string) string {
 	// Remove drive letters and leading slashes/backslashes
 	if len(path) >= 2 && path[1] == ':' {
 		path = path[2:]
 	}
 
-	// Remove leading slashes and backslashes
-	path = strings.TrimLeft(path, "/\\")
+	// Treat backslashes as separators too, since archives authored on Windows
+	// by naive tools may use them instead of forward slashes
+	path = strings.ReplaceAll(path, "\\", "/")
+
+	// Remove leading slashes
+	path = strings.TrimLeft(path, "/")
 
 	// Skip empty paths or paths with only dots
-	if path == "" || strings.Trim(path, ".") == "" {
+	if path == "" || strings.Trim(path, "./") == "" {
 		return ""
 	}
 
@@ -732,8 +4979,8 @@ func sanitizeExtractPath(path string) string {
 		return ""
 	}
 
-	// Replace forward slashes with OS-appropriate separators
-	path = filepath.FromSlash(path)
+	// Rebuild the path from its segments using OS-appropriate separators
+	path = filepath.Join(strings.Split(path, "/")...)
 
 	// Remove any remaining invalid characters for the current OS
 	if filepath.Separator == '\\' { // Windows
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testEntry is an entry of an archive built by writeTestArchive. Entries
+// with a link are symlinks, names ending in a slash are directories, and
+// the rest are files holding body.
+type testEntry struct {
+	name string
+	body string
+	link string
+}
+
+// writeTestArchive writes a zstd-compressed tar of entries to path
+func writeTestArchive(t *testing.T, path string, entries ...testEntry) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	encoder, err := zstd.NewWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestTar(t, encoder, entries...)
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestTar writes an uncompressed tar of entries to w
+func writeTestTar(t *testing.T, w io.Writer, entries ...testEntry) {
+	t.Helper()
+
+	tarWriter := tar.NewWriter(w)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		switch {
+		case entry.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.link, 0
+		case entry.name[len(entry.name)-1] == '/':
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMtime is a fixed modification time for writeTestFiles
+var testMtime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// writeTestFiles creates files under dir, named by slash-separated paths.
+// Given an mtime, the files are dated to it, so archives of them compare
+// byte for byte.
+func writeTestFiles(t testing.TB, dir string, files map[string]string, mtime ...time.Time) {
+	t.Helper()
+
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, when := range mtime {
+			if err := os.Chtimes(path, when, when); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// slowText returns size bytes of random text, which takes a while to
+// compress at a high level
+func slowText(size int) string {
+	random := rand.New(rand.NewSource(int64(size)))
+	text := make([]byte, size)
+	for i := range text {
+		text[i] = 'a' + byte(random.Intn(16))
+	}
+	return string(text)
+}
+
+// readTestFile returns the contents of a file the test expects to exist
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// walkTestArchive calls fn for each entry of a zstd-compressed tar
+func walkTestArchive(t *testing.T, archive string, fn func(header *tar.Header, body io.Reader)) {
+	t.Helper()
+
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	tarReader := tar.NewReader(decoder)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn(header, tarReader)
+	}
+}
+
+// archiveNames lists the entries of archive, with the contents of files
+func archiveNames(t *testing.T, archive string) map[string]string {
+	t.Helper()
+
+	entries := map[string]string{}
+	err := walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		data, err := io.ReadAll(body)
+		entries[header.Name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// compressResponse is a /api/compress response with its stats decoded
+type compressResponse struct {
+	Success bool
+	Message string
+	Data    CompressionStats
+}
+
+// postCompress sends req to /api/compress and decodes the response
+func postCompress(t *testing.T, req CompressRequest) compressResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	handleCompress(rec, httptest.NewRequest(http.MethodPost, "/api/compress", bytes.NewReader(body)))
+
+	var resp compressResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+// skipWithoutSymlinks skips tests that create symlinks where that isn't
+// generally allowed
+func skipWithoutSymlinks(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+}
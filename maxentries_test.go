@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaxEntriesAborts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for i := range 10 {
+		files[fmt.Sprintf("in/%02d.txt", i)] = "x"
+	}
+	writeTestFiles(t, dir, files)
+
+	output := filepath.Join(dir, "capped.tar.zst")
+	_, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: output, Level: 3, MaxEntries: 5})
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum of 5 entries") {
+		t.Fatalf("got %v, want the entry cap error", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("aborted compression left %s behind", output)
+	}
+
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: output, Level: 3, MaxEntries: 11}); err != nil {
+		t.Errorf("compression within the cap failed: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMeasureMatchesRealCompression(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": strings.Repeat("measure me ", 5000), "in/b.txt": "short"}, testMtime)
+	input := filepath.Join(dir, "in")
+
+	measuredOutput := filepath.Join(dir, "measured.tar.zst")
+	measured, err := compressFiles(CompressRequest{Files: []string{input}, Output: measuredOutput, Level: 3, Measure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(measuredOutput); !os.IsNotExist(err) {
+		t.Errorf("measuring created %s: %v", measuredOutput, err)
+	}
+
+	real, err := compressFiles(CompressRequest{Files: []string{input}, Output: filepath.Join(dir, "real.tar.zst"), Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(real.OutputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if measured.CompressedSize != info.Size() || measured.OriginalSize != real.OriginalSize {
+		t.Errorf("measured %d of %d bytes, the archive is %d of %d", measured.CompressedSize, measured.OriginalSize, info.Size(), real.OriginalSize)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// memorySampleInterval is how often a memorySampler reads the heap size.
+// ReadMemStats stops the world briefly, so sampling is only done on request.
+const memorySampleInterval = 10 * time.Millisecond
+
+// memorySampler records the peak heap in use while a job runs. The heap is
+// shared by the whole process, so jobs running alongside are counted too.
+type memorySampler struct {
+	peak    uint64
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func startMemorySampler() *memorySampler {
+	s := &memorySampler{done: make(chan struct{}), stopped: make(chan struct{})}
+	s.sample()
+	go s.run()
+	return s
+}
+
+func (s *memorySampler) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(memorySampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.sample()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *memorySampler) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > s.peak {
+		s.peak = stats.HeapAlloc
+	}
+}
+
+// Stop ends sampling and returns the peak heap in use, in bytes
+func (s *memorySampler) Stop() uint64 {
+	close(s.done)
+	<-s.stopped
+	s.sample()
+	return s.peak
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemorySamplerSeesAllocation(t *testing.T) {
+	sampler := startMemorySampler()
+	held := make([]byte, 32<<20)
+	for i := range held {
+		held[i] = byte(i)
+	}
+	peak := sampler.Stop()
+	if peak < uint64(len(held)) {
+		t.Errorf("peak heap %d while holding %d bytes", peak, len(held))
+	}
+	_ = held[len(held)-1]
+}
+
+func TestReportMemory(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeTestFiles(t, dir, map[string]string{"in/random.bin": string(data)})
+	archive := filepath.Join(dir, "out.tar.zst")
+
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3, ReportMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The encoder's buffers alone take more than a megabyte
+	if stats.PeakHeapBytes < 1<<20 {
+		t.Errorf("compression peak heap is %d bytes", stats.PeakHeapBytes)
+	}
+
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: filepath.Join(dir, "out"), ReportMemory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PeakHeapBytes < 1<<20 {
+		t.Errorf("extraction peak heap is %d bytes", result.PeakHeapBytes)
+	}
+}
+
+func TestReportMemoryOffByDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+	archive := filepath.Join(dir, "out.tar.zst")
+
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PeakHeapBytes != 0 {
+		t.Errorf("peak heap reported unasked: %d", stats.PeakHeapBytes)
+	}
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: filepath.Join(dir, "out")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.PeakHeapBytes != 0 {
+		t.Errorf("peak heap reported unasked: %d", result.PeakHeapBytes)
+	}
+}
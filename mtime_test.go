@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModTimesSurviveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFiles(t, input, map[string]string{"sub/a.txt": "alpha", "b.txt": "bravo"})
+
+	// Directories are set last, since writing their files would change them
+	fileTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	dirTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, when := range map[string]time.Time{"sub/a.txt": fileTime, "b.txt": fileTime, "sub": dirTime, ".": dirTime} {
+		if err := os.Chtimes(filepath.Join(input, name), when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(dir, "times.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]time.Time{"input/sub/a.txt": fileTime, "input/b.txt": fileTime, "input/sub": dirTime, "input": dirTime} {
+		info, err := os.Stat(filepath.Join(output, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := info.ModTime().Sub(want); diff < -time.Second || diff > time.Second {
+			t.Errorf("%s has mtime %v, want %v", name, info.ModTime().UTC(), want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNameCharsetPolicies(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "names.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "good.txt", body: "good"},
+		testEntry{name: "with space.txt", body: "space"},
+		testEntry{name: "café.txt", body: "unicode"},
+	)
+	const charset = `[A-Za-z0-9._-]`
+	wantInvalid := []string{"café.txt", "with space.txt"}
+
+	extract := func(policy string) (string, *extractResult, error) {
+		output := filepath.Join(dir, "out-"+policy)
+		result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, NameCharset: charset, NameCharsetPolicy: policy})
+		return output, result, err
+	}
+	extracted := func(output string) []string {
+		entries, err := os.ReadDir(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// Skipped by default, and reported
+	output, result, err := extract("skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := extracted(output); !slices.Equal(got, []string{"good.txt"}) {
+		t.Errorf("skip extracted %v", got)
+	}
+	slices.Sort(result.InvalidNames)
+	if !slices.Equal(result.InvalidNames, wantInvalid) {
+		t.Errorf("skip reported %v, want %v", result.InvalidNames, wantInvalid)
+	}
+
+	// Sanitized: one underscore per disallowed character, multibyte or not
+	output, _, err = extract("sanitize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := extracted(output); !slices.Equal(got, []string{"caf_.txt", "good.txt", "with_space.txt"}) {
+		t.Errorf("sanitize extracted %v", got)
+	}
+	if got := readTestFile(t, filepath.Join(output, "caf_.txt")); got != "unicode" {
+		t.Errorf("caf_.txt holds %q", got)
+	}
+
+	if _, _, err = extract("abort"); err == nil || !strings.Contains(err.Error(), "outside the allowed set") {
+		t.Errorf("abort got %v", err)
+	}
+}
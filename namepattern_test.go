@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandNamePattern(t *testing.T) {
+	now := time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC)
+	req := CompressRequest{Files: []string{"/data/photos.d/report.pdf"}, Level: 9, Format: "zstd"}
+
+	tests := []struct {
+		pattern, want string
+	}{
+		{"{basename}-{date}-L{level}.{format}", "report-2024-03-09-L9.zstd"},
+		{"backup_{date:20060102_1504}_{count}", "backup_20240309_1430_1"},
+		// Expanded values can't add path separators or other unsafe characters
+		{"{date:2006/01/02}", "2024_03_09"},
+		{"{date:15:04}", "14_30"},
+		{"{unknown}-{level}", "{unknown}-9"},
+	}
+	for _, test := range tests {
+		if got := expandNamePattern(test.pattern, req, now); got != test.want {
+			t.Errorf("%q expanded to %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestNamePatternNamesOutput(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"notes.txt": "notes"})
+
+	resp := postCompress(t, CompressRequest{Files: []string{"notes.txt"}, NamePattern: "{basename}-L{level}-{date:2006/01/02}", Level: 5})
+	if !resp.Success {
+		t.Fatalf("compression failed: %s", resp.Message)
+	}
+	want := "notes-L5-" + time.Now().Format("2006_01_02") + ".zst"
+	if filepath.Base(resp.Data.OutputFile) != want {
+		t.Errorf("archive written to %s, want %s", resp.Data.OutputFile, want)
+	}
+	if strings.ContainsAny(filepath.Base(resp.Data.OutputFile), `\:`) {
+		t.Errorf("output name %q holds unsafe characters", resp.Data.OutputFile)
+	}
+}
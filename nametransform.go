@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NameTransform rewrites an entry name, or drops the entry by returning
+// false. Names use forward slashes and are sanitized after the transform.
+type NameTransform func(name string) (string, bool)
+
+// parseNameTransform returns the built-in transform named by spec:
+// "lowercase", or "strip-prefix:PREFIX", which removes PREFIX from names
+// that start with it and drops the entry that is the prefix itself.
+func parseNameTransform(spec string) (NameTransform, error) {
+	switch {
+	case spec == "lowercase":
+		return func(name string) (string, bool) {
+			return strings.ToLower(name), true
+		}, nil
+
+	case strings.HasPrefix(spec, "strip-prefix:"):
+		prefix := strings.TrimPrefix(spec, "strip-prefix:")
+		if prefix == "" {
+			return nil, fmt.Errorf("strip-prefix needs a prefix")
+		}
+		return func(name string) (string, bool) {
+			if !strings.HasPrefix(name, prefix) {
+				return name, true
+			}
+			stripped := strings.TrimLeft(strings.TrimPrefix(name, prefix), "/")
+			return stripped, stripped != ""
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown name transform %q", spec)
+}
+
+// parseNameCharset compiles charset, a regular expression matching a single
+// allowed character such as `[A-Za-z0-9._-]`, into a matcher for one rune.
+func parseNameCharset(charset string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(`^(?:` + charset + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name charset: %v", err)
+	}
+	return re, nil
+}
+
+// applyNameCharset replaces every character of name outside allowed with an
+// underscore, leaving the path separators alone. It reports whether any
+// character was replaced.
+func applyNameCharset(allowed *regexp.Regexp, name string) (string, bool) {
+	var b strings.Builder
+	replaced := false
+	for _, r := range name {
+		if r == '/' || allowed.MatchString(string(r)) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteByte('_')
+		replaced = true
+	}
+	return b.String(), replaced
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLowercaseNameTransform(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"Docs/README.TXT": "read me", "Docs/Sub/Notes.md": "notes"})
+
+	archive := filepath.Join(dir, "lower.tar.zst")
+	resp := postCompress(t, CompressRequest{Files: []string{filepath.Join(dir, "Docs")}, Output: archive, Level: 3, NameTransformSpec: "lowercase"})
+	if !resp.Success {
+		t.Fatalf("compression failed: %s", resp.Message)
+	}
+
+	entries := archiveNames(t, archive)
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if want := []string{"docs", "docs/readme.txt", "docs/sub", "docs/sub/notes.md"}; !slices.Equal(names, want) {
+		t.Errorf("entries are %v, want %v", names, want)
+	}
+	if entries["docs/readme.txt"] != "read me" {
+		t.Errorf("docs/readme.txt holds %q", entries["docs/readme.txt"])
+	}
+}
+
+func TestNameTransformDropsAndIsSanitized(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/keep.txt": "keep", "in/drop.log": "drop", "in/escape.txt": "escape"})
+
+	archive := filepath.Join(dir, "custom.tar.zst")
+	transform := func(name string) (string, bool) {
+		if strings.HasSuffix(name, ".log") {
+			return "", false
+		}
+		if strings.HasSuffix(name, "escape.txt") {
+			return "../../escape.txt", true
+		}
+		return name, true
+	}
+	req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3, NameTransform: transform}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := archiveNames(t, archive)
+	if _, ok := entries["in/drop.log"]; ok {
+		t.Error("dropped entry was archived")
+	}
+	if entries["in/keep.txt"] != "keep" {
+		t.Errorf("in/keep.txt holds %q", entries["in/keep.txt"])
+	}
+	// Sanitizing after the transform keeps the name inside the archive root
+	if entries["escape.txt"] != "escape" {
+		t.Errorf("escaping name wasn't sanitized, entries are %v", entries)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// nestedDefaultDepth is how many levels of archives within archives are
+	// opened unless the request says otherwise, and nestedMaxDepth the most
+	// it may ask for
+	nestedDefaultDepth = 3
+	nestedMaxDepth     = 5
+
+	// nestedMaxTotalSize bounds the bytes of nested archives read or
+	// extracted for one request, so a nested bomb can't fill the disk
+	nestedMaxTotalSize = 1 << 30
+)
+
+// isArchiveName reports whether name has the extension of an archive this
+// tool reads
+func isArchiveName(name string) bool {
+	return trimArchiveExt(name) != name
+}
+
+// nestedDepth clamps a requested nesting depth, 0 meaning the default
+func nestedDepth(depth int) int {
+	if depth <= 0 {
+		return nestedDefaultDepth
+	}
+	if depth > nestedMaxDepth {
+		return nestedMaxDepth
+	}
+	return depth
+}
+
+// listArchiveRecursive lists an archive like listArchive, and also the
+// entries of archives inside it, down to depth levels. Nested entries are
+// named under the archive entry holding them, like outer.zip/inner.txt.
+func listArchiveRecursive(archiveFile string, depth int) ([]ArchiveListEntry, error) {
+	entries := []ArchiveListEntry{}
+	budget := int64(nestedMaxTotalSize)
+	err := listNested(archiveFile, "", 0, depth, &budget, &entries)
+	return entries, err
+}
+
+func listNested(archiveFile, prefix string, level, depth int, budget *int64, entries *[]ArchiveListEntry) error {
+	return walkArchive(archiveFile, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+
+		entry := newArchiveListEntry(header)
+		entry.Name = prefix + entry.Name
+		entry.Depth = level
+		*entries = append(*entries, entry)
+
+		if header.Typeflag != tar.TypeReg || level+1 > depth || !isArchiveName(header.Name) {
+			return nil
+		}
+
+		// Archives are read from files, so spool the nested one to disk
+		spooled, err := spoolNested(header, body, budget)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spooled)
+
+		if err := listNested(spooled, entry.Name+"/", level+1, depth, budget, entries); err != nil {
+			return fmt.Errorf("nested archive %s: %v", entry.Name, err)
+		}
+		return nil
+	})
+}
+
+// spoolNested copies a nested archive to a temp file, charging its size
+// to budget
+func spoolNested(header *tar.Header, body io.Reader, budget *int64) (string, error) {
+	if header.Size > *budget {
+		return "", fmt.Errorf("nested archives exceed %d bytes", int64(nestedMaxTotalSize))
+	}
+	*budget -= header.Size
+
+	// Keep the extension, which names .gz and .xz single files
+	temp, err := os.CreateTemp("", "zstd_nested_*_"+path.Base(strings.TrimSuffix(header.Name, "/")))
+	if err != nil {
+		return "", err
+	}
+	defer temp.Close()
+
+	if _, err := io.Copy(temp, io.LimitReader(body, header.Size)); err != nil {
+		os.Remove(temp.Name())
+		return "", fmt.Errorf("failed to read nested archive %s: %v", header.Name, err)
+	}
+	return temp.Name(), nil
+}
+
+// extractNested extracts each archive written by req's extraction into a
+// folder next to it, named after it without its extension, and merges
+// what that extracted into result. Together they may extract at most budget
+// bytes.
+func extractNested(req DecompressRequest, archives []string, budget int64, result *extractResult, logger *jobLogger) error {
+	for _, archive := range archives {
+		if budget <= 0 {
+			return fmt.Errorf("nested archives exceed the maximum total extraction size")
+		}
+
+		sub := req
+		sub.Archive = archive
+		sub.OutputDir = trimArchiveExt(archive)
+		sub.Unique = true // never replace a folder the outer archive holds
+		sub.Entries = nil
+		sub.BaseArchive = ""
+		sub.OnProgress = nil
+		sub.NestedDepth = req.NestedDepth - 1
+		sub.ExtractNested = sub.NestedDepth > 0
+		sub.MaxTotalSize = budget
+
+		nested, err := extractArchive(sub, logger)
+		if err != nil {
+			return fmt.Errorf("nested archive %s: %v", archive, err)
+		}
+		logger.Printf("Extracted nested archive %s to %s", archive, nested.OutputDir)
+
+		budget -= nested.extractedSize
+		result.extractedSize += nested.extractedSize
+		result.FileCount += nested.FileCount
+		result.ExtractedSize += nested.ExtractedSize
+		result.SkippedEntries = append(result.SkippedEntries, nested.SkippedEntries...)
+		result.SplitFiles = append(result.SplitFiles, nested.SplitFiles...)
+		result.InvalidNames = append(result.InvalidNames, nested.InvalidNames...)
+		result.Entries = append(result.Entries, nested.Entries...)
+		result.TypeMatched += nested.TypeMatched
+		result.TypeSkipped += nested.TypeSkipped
+		result.NestedArchives = append(result.NestedArchives, nested.OutputDir)
+		result.NestedArchives = append(result.NestedArchives, nested.NestedArchives...)
+	}
+	return nil
+}
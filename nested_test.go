@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// zipBytes returns a zip archive holding files
+func zipBytes(t *testing.T, files ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(file.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestListNestedZip(t *testing.T) {
+	dir := t.TempDir()
+	inner := zipBytes(t, testEntry{name: "inner.txt", body: "inner"}, testEntry{name: "docs/deep.txt", body: "deep"})
+	archive := filepath.Join(dir, "outer.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "top.txt", body: "top"},
+		testEntry{name: "bundle/"},
+		testEntry{name: "bundle/inner.zip", body: string(inner)},
+	)
+
+	entries, err := listArchiveRecursive(archive, nestedDepth(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	depths := make(map[string]int)
+	for _, entry := range entries {
+		depths[entry.Name] = entry.Depth
+	}
+	want := map[string]int{
+		"top.txt":                        0,
+		"bundle/":                        0,
+		"bundle/inner.zip":               0,
+		"bundle/inner.zip/inner.txt":     1,
+		"bundle/inner.zip/docs/deep.txt": 1,
+	}
+	if !reflect.DeepEqual(depths, want) {
+		t.Errorf("listed %v\nwant %v", depths, want)
+	}
+
+	// Without recursion the zip is just an entry
+	entries, err = listArchive(archive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("plain listing has %d entries: %+v", len(entries), entries)
+	}
+}
+
+func TestListNestedDepthIsBounded(t *testing.T) {
+	dir := t.TempDir()
+
+	// A zip in a zip in a tar.zst, listed one level deep
+	innermost := zipBytes(t, testEntry{name: "bottom.txt", body: "bottom"})
+	middle := zipBytes(t, testEntry{name: "innermost.zip", body: string(innermost)})
+	archive := filepath.Join(dir, "outer.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "middle.zip", body: string(middle)})
+
+	entries, err := listArchiveRecursive(archive, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if want := []string{"middle.zip", "middle.zip/innermost.zip"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %v, want %v", names, want)
+	}
+
+	entries, err = listArchiveRecursive(archive, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := entries[len(entries)-1]; last.Name != "middle.zip/innermost.zip/bottom.txt" || last.Depth != 2 {
+		t.Errorf("two levels deep listed %+v", entries)
+	}
+}
+
+func TestExtractNestedZip(t *testing.T) {
+	dir := t.TempDir()
+	inner := zipBytes(t, testEntry{name: "inner.txt", body: "inner"})
+	archive := filepath.Join(dir, "outer.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "top.txt", body: "top"}, testEntry{name: "inner.zip", body: string(inner)})
+
+	out := filepath.Join(dir, "out")
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: out, ExtractNested: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(out, "inner", "inner.txt")); got != "inner" {
+		t.Errorf("nested file holds %q", got)
+	}
+	if want := []string{filepath.Join(out, "inner")}; !reflect.DeepEqual(result.NestedArchives, want) {
+		t.Errorf("nested archives extracted to %v, want %v", result.NestedArchives, want)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputCollidingWithInputIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTestFiles(t, dir, map[string]string{"in.txt": "precious", "tree/a.txt": "a"})
+
+	tests := []struct {
+		files  []string
+		output string
+		want   string
+	}{
+		{[]string{"in.txt"}, filepath.Join(dir, "in.txt"), "is also an input"},
+		{[]string{filepath.Join(dir, "in.txt")}, "./tree/../in.txt", "is also an input"},
+		{[]string{"tree"}, filepath.Join("tree", "tree.tar.zst"), "is inside input directory"},
+	}
+	for _, test := range tests {
+		_, err := compressFiles(CompressRequest{Files: test.files, Output: test.output, Level: 3})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v -> %s: got %v, want %q", test.files, test.output, err, test.want)
+		}
+	}
+
+	if got := readTestFile(t, filepath.Join(dir, "in.txt")); got != "precious" {
+		t.Errorf("input was overwritten with %q", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractBackslashSeparatedNames(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	archive := filepath.Join(dir, "windows.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: `docs\readme.txt`, body: "read me"},
+		testEntry{name: `docs\sub/mixed.txt`, body: "mixed"},
+		testEntry{name: `C:\data\drive.txt`, body: "drive"},
+	)
+
+	output := filepath.Join(dir, "out")
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: "out"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FileCount != 3 {
+		t.Errorf("extracted %d files, want 3", result.FileCount)
+	}
+
+	for name, body := range map[string]string{
+		"docs/readme.txt":    "read me",
+		"docs/sub/mixed.txt": "mixed",
+		"data/drive.txt":     "drive",
+	} {
+		if got := readTestFile(t, filepath.Join(output, filepath.FromSlash(name))); got != body {
+			t.Errorf("%s holds %q, want %q", name, got, body)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(output, "docs")); err != nil || !info.IsDir() {
+		t.Errorf("docs wasn't created as a directory: %v", err)
+	}
+}
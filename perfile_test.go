@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPerFileSumsToOriginalSize(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"in/small.txt":          "tiny",
+		"in/big.txt":            strings.Repeat("large file ", 5000),
+		"in/sub/medium.txt":     strings.Repeat("medium ", 300),
+		"in/sub/deeper/one.txt": "1",
+		"in/empty.txt":          "",
+	}
+	writeTestFiles(t, dir, files)
+
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "out.tar.zst"), Level: 3, PerFile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stats.PerFile) != len(files) {
+		t.Fatalf("per-file breakdown has %d files, want %d: %+v", len(stats.PerFile), len(files), stats.PerFile)
+	}
+	var sum int64
+	var percent float64
+	for _, file := range stats.PerFile {
+		want, ok := files[file.Name]
+		if !ok {
+			t.Errorf("unexpected file %s", file.Name)
+		} else if file.Size != int64(len(want)) {
+			t.Errorf("%s has size %d, want %d", file.Name, file.Size, len(want))
+		}
+		sum += file.Size
+		percent += file.Percent
+	}
+	if sum != stats.OriginalSize {
+		t.Errorf("per-file sizes sum to %d, OriginalSize is %d", sum, stats.OriginalSize)
+	}
+	if math.Abs(percent-100) > 0.001 {
+		t.Errorf("percentages sum to %f", percent)
+	}
+}
+
+func TestPerFileOffByDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "out.tar.zst"), Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.PerFile != nil {
+		t.Errorf("per-file breakdown given unasked: %+v", stats.PerFile)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestModesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"in/run.sh":       "#!/bin/sh\necho hi\n",
+		"in/private.txt":  "secret",
+		"in/setuid":       "elevated",
+		"in/shared/a.txt": "alpha",
+	})
+	modes := map[string]os.FileMode{
+		"in/run.sh":      0755,
+		"in/private.txt": 0600,
+		"in/setuid":      os.ModeSetuid | 0755,
+		"in/shared":      os.ModeDir | os.ModeSticky | 0777,
+	}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(dir, "modes.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A strict umask would mask the bits if they weren't set explicitly
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	for _, strip := range []bool{false, true} {
+		out := filepath.Join(dir, "out")
+		if strip {
+			out = filepath.Join(dir, "stripped")
+		}
+		if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: out, StripSetuid: strip}); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, mode := range modes {
+			if strip {
+				mode &^= os.ModeSetuid
+			}
+			info, err := os.Stat(filepath.Join(out, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode() & (os.ModeDir | os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky); got != mode {
+				t.Errorf("strip=%v: %s extracted with mode %v, want %v", strip, name, got, mode)
+			}
+		}
+		if info, err := os.Stat(filepath.Join(out, "in", "run.sh")); err != nil || info.Mode()&0111 == 0 {
+			t.Errorf("strip=%v: script isn't executable", strip)
+		}
+	}
+}
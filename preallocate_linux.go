@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize reserves space without changing the file's size
+const fallocKeepSize = 0x1 // FALLOC_FL_KEEP_SIZE
+
+// preallocate reserves size bytes of disk space for file up front, so the
+// filesystem can lay the archive out in few extents. The file's size is
+// unchanged; blocks past the end are released when it's truncated.
+func preallocate(file *os.File, size int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, size)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// allocatedBytes returns how much disk space path has reserved
+func allocatedBytes(t *testing.T, path string) int64 {
+	t.Helper()
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Blocks * 512
+}
+
+func TestPreallocateReservesSpace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reserved")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	const hint = 8 << 20
+	if err := preallocate(file, hint); err != nil {
+		t.Skipf("filesystem can't preallocate: %v", err)
+	}
+	if got := allocatedBytes(t, path); got < hint {
+		t.Errorf("%d bytes reserved, want at least %d", got, hint)
+	}
+	if info, err := file.Stat(); err != nil || info.Size() != 0 {
+		t.Errorf("preallocating changed the file's size: %v", err)
+	}
+}
+
+func TestOutputSizeHintIsReleased(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": strings.Repeat("hinted ", 10000)})
+
+	const hint = 8 << 20
+	archive := filepath.Join(dir, "hinted.tar.zst")
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: archive, Level: 3, OutputSizeHint: hint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != stats.CompressedSize {
+		t.Errorf("archive is %d bytes, stats say %d", info.Size(), stats.CompressedSize)
+	}
+	if got := allocatedBytes(t, archive); got >= hint {
+		t.Errorf("%d bytes still reserved after compressing, want the unused space released", got)
+	}
+	if got := archiveNames(t, archive)["a.txt"]; got != strings.Repeat("hinted ", 10000) {
+		t.Errorf("a.txt round trip gave %d bytes", len(got))
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// preallocate is a no-op where space can't be reserved without writing it
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
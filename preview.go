@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	previewTTL          = 10 * time.Minute
+	previewMaxEntrySize = 10 << 20  // 10 MB per previewed file
+	previewMaxTotalSize = 100 << 20 // 100 MB per preview
+)
+
+type PreviewRequest struct {
+	Archive string   `json:"archive"`
+	Entries []string `json:"entries"`
+}
+
+// previewSession is a read-only extraction kept in a temp directory until it expires
+type previewSession struct {
+	dir       string
+	expiresAt time.Time
+}
+
+var (
+	previewMu       sync.Mutex
+	previewSessions = make(map[string]*previewSession)
+)
+
+// handlePreview creates a preview with POST and serves a previewed file with GET
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handleCreatePreview(w, r)
+	case http.MethodGet:
+		handleServePreview(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleCreatePreview(w http.ResponseWriter, r *http.Request) {
+	var req PreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, false, "Invalid request format", nil)
+		return
+	}
+
+	if req.Archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "zstd_preview")
+	if err != nil {
+		sendResponse(w, false, "Failed to create temp directory", nil)
+		return
+	}
+
+	result, err := decompressFile(DecompressRequest{
+		Archive:      req.Archive,
+		OutputDir:    tempDir,
+		MaxEntrySize: previewMaxEntrySize,
+		MaxTotalSize: previewMaxTotalSize,
+		Entries:      req.Entries,
+	})
+	if err != nil {
+		os.RemoveAll(tempDir)
+		sendResponse(w, false, fmt.Sprintf("Preview failed: %v", err), nil)
+		return
+	}
+
+	files, err := listPreviewFiles(tempDir)
+	if err != nil {
+		os.RemoveAll(tempDir)
+		sendResponse(w, false, fmt.Sprintf("Preview failed: %v", err), nil)
+		return
+	}
+
+	token, err := newPreviewToken()
+	if err != nil {
+		os.RemoveAll(tempDir)
+		sendResponse(w, false, "Failed to create preview token", nil)
+		return
+	}
+
+	session := &previewSession{dir: tempDir, expiresAt: time.Now().Add(previewTTL)}
+
+	previewMu.Lock()
+	previewSessions[token] = session
+	previewMu.Unlock()
+
+	data := map[string]interface{}{
+		"token":     token,
+		"expiresAt": session.expiresAt.Format(time.RFC3339),
+		"files":     files,
+	}
+	if len(result.SkippedEntries) > 0 {
+		data["skippedEntries"] = result.SkippedEntries
+	}
+
+	sendResponse(w, true, fmt.Sprintf("Preview ready with %d files", len(files)), data)
+}
+
+func handleServePreview(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	name := r.URL.Query().Get("file")
+	if token == "" || name == "" {
+		http.Error(w, "Token and file parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	previewMu.Lock()
+	session, ok := previewSessions[token]
+	previewMu.Unlock()
+
+	if !ok || time.Now().After(session.expiresAt) {
+		http.Error(w, "Preview not found or expired", http.StatusNotFound)
+		return
+	}
+
+	// Resolve the requested file inside the preview directory only
+	cleanName := sanitizeExtractPath(name)
+	if cleanName == "" {
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+	filePath := filepath.Join(session.dir, cleanName)
+
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filepath.Base(filePath)}))
+
+	// Previewed HTML or SVG must not run scripts on this origin, nor other
+	// files be sniffed into something that does
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeFile(w, r, filePath)
+}
+
+// listPreviewFiles returns the slash-separated paths of all files under dir
+func listPreviewFiles(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+
+	return files, err
+}
+
+func newPreviewToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// cleanupExpiredPreviews removes every preview that expired before now
+func cleanupExpiredPreviews(now time.Time) {
+	previewMu.Lock()
+	var expired []*previewSession
+	for token, session := range previewSessions {
+		if now.After(session.expiresAt) {
+			expired = append(expired, session)
+			delete(previewSessions, token)
+		}
+	}
+	previewMu.Unlock()
+
+	for _, session := range expired {
+		if err := os.RemoveAll(session.dir); err != nil {
+			log.Printf("Failed to remove preview %s: %v", session.dir, err)
+		}
+	}
+}
+
+// janitor periodically removes expired previews, cached download zips and
+// uploads
+func janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		cleanupExpiredPreviews(now)
+		cleanupExpiredDownloads(now)
+		cleanupExpiredUploads(now)
+	}
+}
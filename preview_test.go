@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createTestPreview posts a preview of archive and returns its token
+func createTestPreview(t *testing.T, archive string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(PreviewRequest{Archive: archive})
+	rec := httptest.NewRecorder()
+	handlePreview(rec, httptest.NewRequest(http.MethodPost, "/api/preview", strings.NewReader(string(body))))
+
+	var resp struct {
+		Success bool
+		Message string
+		Data    struct{ Token string }
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Fatalf("preview failed: %s", resp.Message)
+	}
+	return resp.Data.Token
+}
+
+func servePreviewFile(token, name string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	query := url.Values{"token": {token}, "file": {name}}
+	handlePreview(rec, httptest.NewRequest(http.MethodGet, "/api/preview?"+query.Encode(), nil))
+	return rec
+}
+
+func TestPreviewServesFilesUntilExpiry(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "site.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "docs/readme.txt", body: "read me"})
+
+	token := createTestPreview(t, archive)
+	rec := servePreviewFile(token, "docs/readme.txt")
+	if rec.Code != http.StatusOK || rec.Body.String() != "read me" {
+		t.Fatalf("got %d %q, want the file", rec.Code, rec.Body.String())
+	}
+
+	previewMu.Lock()
+	dir := previewSessions[token].dir
+	previewMu.Unlock()
+
+	cleanupExpiredPreviews(time.Now().Add(previewTTL + time.Second))
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("preview directory %s still exists after expiry", dir)
+	}
+	if rec := servePreviewFile(token, "docs/readme.txt"); rec.Code != http.StatusNotFound {
+		t.Errorf("expired preview served with status %d", rec.Code)
+	}
+}
+
+func TestPreviewIsSandboxed(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "site.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: `page "x"; y.html`, body: "<script>alert(1)</script>"})
+
+	token := createTestPreview(t, archive)
+	defer cleanupExpiredPreviews(time.Now().Add(previewTTL + time.Second))
+
+	rec := servePreviewFile(token, `page "x"; y.html`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("Content-Security-Policy is %q, want sandbox", got)
+	}
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options is %q, want nosniff", got)
+	}
+	want := `inline; filename="page \"x\"; y.html"`
+	if got := rec.Header().Get("Content-Disposition"); got != want {
+		t.Errorf("Content-Disposition is %s, want %s", got, want)
+	}
+}
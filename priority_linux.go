@@ -0,0 +1,22 @@
+package main
+
+import "syscall"
+
+// lowPriorityNice is the niceness low priority compressions run at
+const lowPriorityNice = 10
+
+// lowerThreadPriority raises the niceness of the calling OS thread to
+// lowPriorityNice. Linux tracks niceness per thread, so the caller must be
+// locked to its thread for this to stick.
+func lowerThreadPriority() error {
+	// The raw syscall reports 20 - nice
+	priority, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0)
+	if err != nil {
+		return err
+	}
+	if 20-priority >= lowPriorityNice {
+		return nil
+	}
+
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, lowPriorityNice)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// lowerThreadPriority is a no-op where niceness can't be set per thread
+func lowerThreadPriority() error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often progress is reported while a job runs, even
+// when no file has finished in the meantime. Tests shorten it.
+var progressInterval = time.Second
+
+// Progress is a snapshot of a running compression or extraction
+type Progress struct {
+	Files   int    `json:"files"`   // files finished so far
+	Bytes   int64  `json:"bytes"`   // uncompressed bytes processed so far
+	Current string `json:"current"` // entry being processed
+
+	// Totals are only known when the job scanned its input up front
+	TotalFiles int   `json:"totalFiles,omitempty"`
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+}
+
+// progressTracker reports Progress when a file finishes and on a heartbeat
+// in between, so a single large file doesn't look like a hung job. Calls to
+// the callback are serialized. A nil *progressTracker reports nothing.
+type progressTracker struct {
+	mu       sync.Mutex
+	fn       func(Progress)
+	progress Progress
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+func startProgress(fn func(Progress)) *progressTracker {
+	if fn == nil {
+		return nil
+	}
+
+	t := &progressTracker{fn: fn, done: make(chan struct{}), stopped: make(chan struct{})}
+	go t.heartbeat()
+	return t
+}
+
+func (t *progressTracker) heartbeat() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.report(nil)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// report applies update, if any, and passes the result to the callback
+func (t *progressTracker) report(update func(p *Progress)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if update != nil {
+		update(&t.progress)
+	}
+	t.fn(t.progress)
+}
+
+// setTotals records the job's totals and reports them right away
+func (t *progressTracker) setTotals(files int, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.report(func(p *Progress) {
+		p.TotalFiles = files
+		p.TotalBytes = bytes
+	})
+}
+
+// track counts the bytes read from r towards the entry called name
+func (t *progressTracker) track(name string, r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+
+	t.mu.Lock()
+	t.progress.Current = name
+	t.mu.Unlock()
+
+	return &progressReader{r: r, tracker: t}
+}
+
+// finishEntry counts a finished file and reports right away
+func (t *progressTracker) finishEntry() {
+	if t == nil {
+		return
+	}
+	t.report(func(p *Progress) { p.Files++ })
+}
+
+// Stop ends the heartbeat and sends a final report
+func (t *progressTracker) Stop() {
+	if t == nil {
+		return
+	}
+	close(t.done)
+	<-t.stopped
+	t.report(func(p *Progress) { p.Current = "" })
+}
+
+type progressReader struct {
+	r       io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	r.tracker.mu.Lock()
+	r.tracker.progress.Bytes += int64(n)
+	r.tracker.mu.Unlock()
+
+	return n, err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressHeartbeatDuringLargeFile(t *testing.T) {
+	interval := progressInterval
+	progressInterval = 10 * time.Millisecond
+	t.Cleanup(func() { progressInterval = interval })
+
+	var mu sync.Mutex
+	var during []Progress
+	onProgress := func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if p.Files == 0 && p.Current != "" {
+			during = append(during, p)
+		}
+	}
+
+	// One file which takes a while to compress at level 19
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"large.txt": slowText(4 << 20)})
+	req := CompressRequest{Files: []string{filepath.Join(dir, "large.txt")}, Output: filepath.Join(dir, "large.tar.zst"), Level: 19, OnProgress: onProgress}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(during) < 3 {
+		t.Fatalf("got %d progress events while copying the file, want several", len(during))
+	}
+	for i := 1; i < len(during); i++ {
+		if during[i].Bytes < during[i-1].Bytes {
+			t.Errorf("byte count went backwards: %d after %d", during[i].Bytes, during[i-1].Bytes)
+		}
+	}
+	if first, last := during[0].Bytes, during[len(during)-1].Bytes; last <= first {
+		t.Errorf("byte count didn't grow during the copy: %d to %d", first, last)
+	}
+}
+
+func TestTwoPassExtractionReportsTotalsFirst(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "totals.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "dir/"},
+		testEntry{name: "dir/a.txt", body: "alpha"},
+		testEntry{name: "dir/b.txt", body: "bravo!"},
+		testEntry{name: "c.txt", body: "charlie"},
+	)
+
+	var mu sync.Mutex
+	var events []Progress
+	req := DecompressRequest{Archive: archive, OutputDir: filepath.Join(dir, "out"), TwoPass: true, OnProgress: func(p Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, p)
+	}}
+	if _, err := decompressFile(req); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) == 0 {
+		t.Fatal("no progress events")
+	}
+	if first := events[0]; first.TotalFiles != 3 || first.TotalBytes != 18 {
+		t.Errorf("first event has totals %d files, %d bytes, want 3 files, 18 bytes", first.TotalFiles, first.TotalBytes)
+	}
+	if last := events[len(events)-1]; last.Files != 3 || last.Bytes != 18 {
+		t.Errorf("last event has %d files, %d bytes, want 3 files, 18 bytes", last.Files, last.Bytes)
+	}
+}
+
+// The totals of a two-pass extraction leave out what the extraction itself
+// leaves out, so the last event matches the first
+func TestTwoPassTotalsMatchExtraction(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "filtered.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "keep.txt", body: "keep"},
+		testEntry{name: "drop.tmp", body: "dropped by the transform"},
+		testEntry{name: "with space.txt", body: "outside the charset"},
+		testEntry{name: "../escape.txt", body: "traversal"},
+		testEntry{name: "/abs/x.txt", body: "relocated"},
+	)
+
+	var mu sync.Mutex
+	var events []Progress
+	req := DecompressRequest{
+		Archive:     archive,
+		OutputDir:   filepath.Join(dir, "out"),
+		NameCharset: `[A-Za-z0-9._/-]`,
+		NameTransform: func(name string) (string, bool) {
+			return name, filepath.Ext(name) != ".tmp"
+		},
+		TwoPass: true,
+		OnProgress: func(p Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, p)
+		},
+	}
+	result, err := decompressFile(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if result.FileCount != 2 {
+		t.Fatalf("extracted %d files, want 2", result.FileCount)
+	}
+	if first := events[0]; first.TotalFiles != 2 || first.TotalBytes != 13 {
+		t.Errorf("first event has totals %d files, %d bytes, want 2 files, 13 bytes", first.TotalFiles, first.TotalBytes)
+	}
+	if last := events[len(events)-1]; last.Files != 2 || last.Bytes != 13 {
+		t.Errorf("last event has %d files, %d bytes, want 2 files, 13 bytes", last.Files, last.Bytes)
+	}
+}
+
+func TestTwoPassTotalsOfEncryptedArchive(t *testing.T) {
+	lowerPasswordWorkFactor(t)
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "secret.tar.zst")
+	entries := []ReaderEntry{{Name: "a.txt", Size: 5, R: strings.NewReader("alpha")}}
+	stats, err := compressReaders(entries, CompressRequest{Output: archive, Level: 3, Password: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first Progress
+	var once sync.Once
+	req := DecompressRequest{Archive: stats.OutputFile, OutputDir: filepath.Join(dir, "out"), Password: "hunter2", TwoPass: true, OnProgress: func(p Progress) {
+		once.Do(func() { first = p })
+	}}
+	if _, err := decompressFile(req); err != nil {
+		t.Fatal(err)
+	}
+	if first.TotalFiles != 1 || first.TotalBytes != 5 {
+		t.Errorf("first event has totals %d files, %d bytes, want 1 file, 5 bytes", first.TotalFiles, first.TotalBytes)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsUpgrader only accepts connections from the page's own origin
+var wsUpgrader = websocket.Upgrader{}
+
+// wsProgress is sent while a compression runs over /api/compress-ws
+type wsProgress struct {
+	Type           string `json:"type"` // "progress"
+	FilesProcessed int    `json:"filesProcessed"`
+	TotalFiles     int    `json:"totalFiles"`
+	BytesProcessed int64  `json:"bytesProcessed"`
+	TotalBytes     int64  `json:"totalBytes"`
+	CurrentFile    string `json:"currentFile"`
+}
+
+// wsResult is the last message of a compression, carrying what
+// /api/compress would have responded
+type wsResult struct {
+	Type string `json:"type"` // "result"
+	Response
+}
+
+// handleCompressWS runs a compression over a WebSocket. The client sends
+// the compress request as the first message, then receives progress
+// messages and a final result, which also goes to the request's webhook.
+// Closing the connection cancels the job.
+func handleCompressWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader already replied with an error
+	}
+	defer conn.Close()
+
+	var req CompressRequest
+	if err := conn.ReadJSON(&req); err != nil {
+		sendWSResult(conn, false, "Invalid request format", nil)
+		return
+	}
+	if err := requestPassword(r, &req.Password); err != nil {
+		sendWSResult(conn, false, err.Error(), nil)
+		return
+	}
+
+	globMatches, err := prepareCompressRequest(&req)
+	if err != nil {
+		sendWSResult(conn, false, err.Error(), nil)
+		return
+	}
+
+	// The client sends nothing more, so a failed read means it went away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	req.Context = ctx
+	req.TwoPass = true
+	req.OnProgress = func(p Progress) {
+		err := conn.WriteJSON(wsProgress{
+			Type:           "progress",
+			FilesProcessed: p.Files,
+			TotalFiles:     p.TotalFiles,
+			BytesProcessed: p.Bytes,
+			TotalBytes:     p.TotalBytes,
+			CurrentFile:    p.Current,
+		})
+		if err != nil {
+			cancel()
+		}
+	}
+
+	stats, err := compressFiles(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Compression to %s canceled: client disconnected or server shutting down", req.Output)
+			notifyWebhook(req.WebhookURL, "compress", false, "Compression canceled: client disconnected or server shutting down", nil)
+			return
+		}
+		sendWSResult(conn, false, fmt.Sprintf("Compression failed: %v", err), nil)
+		notifyWebhook(req.WebhookURL, "compress", false, fmt.Sprintf("Compression failed: %v", err), nil)
+		return
+	}
+	stats.GlobMatches = globMatches
+
+	sendWSResult(conn, true, compressMessage(req, stats), stats)
+	notifyWebhook(req.WebhookURL, "compress", true, compressMessage(req, stats), stats)
+}
+
+// sendWSResult sends the final result and closes the connection cleanly
+func sendWSResult(conn *websocket.Conn, success bool, message string, data interface{}) {
+	result := wsResult{Type: "result", Response: Response{Success: success, Message: message, Data: data}}
+	if err := conn.WriteJSON(result); err != nil {
+		return
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
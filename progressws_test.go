@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialCompressWS connects to a test server running handleCompressWS and
+// sends req as the first message
+func dialCompressWS(t *testing.T, req CompressRequest) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(handleCompressWS))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestCompressWSProgress(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": strings.Repeat("a", 1000), "b.txt": strings.Repeat("b", 500)})
+	output := filepath.Join(dir, "ws.tar.zst")
+	conn := dialCompressWS(t, CompressRequest{Files: []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")}, Output: output, Level: 3})
+
+	var progress []wsProgress
+	for {
+		var message json.RawMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		var kind struct{ Type string }
+		json.Unmarshal(message, &kind)
+		if kind.Type == "progress" {
+			var p wsProgress
+			json.Unmarshal(message, &p)
+			progress = append(progress, p)
+			continue
+		}
+
+		var result struct {
+			Type    string
+			Success bool
+			Message string
+			Data    CompressionStats
+		}
+		if err := json.Unmarshal(message, &result); err != nil {
+			t.Fatal(err)
+		}
+		if kind.Type != "result" || !result.Success {
+			t.Fatalf("got %s: %s", kind.Type, result.Message)
+		}
+		if result.Data.OriginalSize != 1500 || result.Data.OutputFile != output {
+			t.Errorf("final stats are %+v", result.Data)
+		}
+		break
+	}
+
+	// The totals are known from the first message on
+	if len(progress) == 0 {
+		t.Fatal("no progress messages")
+	}
+	for _, p := range progress {
+		if p.TotalFiles != 2 || p.TotalBytes != 1500 {
+			t.Errorf("progress has totals %d files, %d bytes, want 2 files, 1500 bytes", p.TotalFiles, p.TotalBytes)
+		}
+	}
+	if last := progress[len(progress)-1]; last.FilesProcessed != 2 || last.BytesProcessed != 1500 {
+		t.Errorf("last progress is %+v", last)
+	}
+}
+
+func TestCompressWSDisconnectCancels(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"large.txt": slowText(8 << 20)})
+	output := filepath.Join(dir, "canceled.tar.zst")
+
+	var logged syncBuffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	// Slow enough that the compression is still running when the client leaves
+	conn := dialCompressWS(t, CompressRequest{Files: []string{filepath.Join(dir, "large.txt")}, Output: output, Level: 19})
+	for {
+		var p wsProgress
+		if err := conn.ReadJSON(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.BytesProcessed > 0 {
+			break
+		}
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("output not started: %v", err)
+	}
+	conn.Close()
+
+	// The job stops once the client leaves, removing its partial output
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logged.String(), "canceled: client disconnected") {
+		if time.Now().After(deadline) {
+			t.Fatal("compression still running after the client disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("partial output left behind: %v", err)
+	}
+}
+
+// syncBuffer collects log output written from other goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvenanceReadBack(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha"})
+
+	compress := func(output string, hostname bool) string {
+		t.Helper()
+		output = filepath.Join(dir, output)
+		req := CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: output, Level: 3, Provenance: true, ProvenanceHostname: hostname}
+		if _, err := compressFiles(req); err != nil {
+			t.Fatal(err)
+		}
+		return output
+	}
+
+	before := time.Now().Add(-time.Second)
+	archive := compress("with-host.tar.zst", true)
+
+	// /api/info reads the metadata back
+	rec := httptest.NewRecorder()
+	handleInfo(rec, httptest.NewRequest(http.MethodGet, "/api/info?archive="+url.QueryEscape(archive), nil))
+	var resp struct {
+		Success bool
+		Message string
+		Data    struct{ Metadata map[string]string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	if !resp.Success {
+		t.Fatal(resp.Message)
+	}
+	metadata := resp.Data.Metadata
+
+	created, err := time.Parse(time.RFC3339, metadata["created"])
+	if err != nil || created.Before(before.Truncate(time.Second)) || created.After(time.Now()) {
+		t.Errorf("created is %q, want the time of compression", metadata["created"])
+	}
+	if metadata["version"] != version {
+		t.Errorf("version is %q, want %q", metadata["version"], version)
+	}
+	if current, err := user.Current(); err == nil && metadata["user"] != current.Username {
+		t.Errorf("user is %q, want %q", metadata["user"], current.Username)
+	}
+	if hostname, err := os.Hostname(); err == nil && metadata["hostname"] != hostname {
+		t.Errorf("hostname is %q, want %q", metadata["hostname"], hostname)
+	}
+
+	// The hostname is left out unless asked for
+	metadata, err = readArchiveMetadata(compress("without-host.tar.zst", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metadata["hostname"]; ok {
+		t.Error("hostname recorded without ProvenanceHostname")
+	}
+	if metadata["version"] != version {
+		t.Errorf("version is %q, want %q", metadata["version"], version)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket counting bytes. It refills at rate bytes a
+// second and holds at most a second's worth, so a job idle for a while can't
+// burst far past the cap afterwards.
+type rateLimiter struct {
+	ctx  context.Context
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(ctx context.Context, bytesPerSec int64) *rateLimiter {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &rateLimiter{ctx: ctx, rate: float64(bytesPerSec), last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, sleeping off whatever that leaves it
+// short. It returns early with the context's error once it's canceled.
+func (l *rateLimiter) wait(n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	l.mu.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(debt / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	}
+}
+
+// throttledReader reads through a rateLimiter
+type throttledReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.wait(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter writes through a rateLimiter
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	if err := t.limiter.wait(len(p)); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
+}
+
+// withThrottle wraps newEncoder so the archive it writes goes out no faster
+// than limiter allows
+func withThrottle(newEncoder func(w io.Writer) (io.WriteCloser, error), limiter *rateLimiter) func(w io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newEncoder(&throttledWriter{w: w, limiter: limiter})
+	}
+}
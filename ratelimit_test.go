@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaxBytesPerSecTakesMinimumTime(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeTestFiles(t, dir, map[string]string{"in/random.bin": string(data)})
+
+	const rate = 1 << 20
+	started := time.Now()
+	_, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "capped.tar.zst"), Level: 1, MaxBytesPerSec: rate})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket starts empty, so reading the input alone takes its size
+	// divided by the rate
+	minimum := time.Duration(float64(len(data)) / rate * float64(time.Second))
+	if elapsed := time.Since(started); elapsed < minimum {
+		t.Errorf("capped compression took %v, at least %v expected", elapsed, minimum)
+	}
+}
+
+func TestThrottledWriterTakesMinimumTime(t *testing.T) {
+	const rate = 1 << 20
+	writer := &throttledWriter{w: io.Discard, limiter: newRateLimiter(nil, rate)}
+
+	started := time.Now()
+	chunk := make([]byte, 32<<10)
+	for range 8 {
+		if _, err := writer.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed, minimum := time.Since(started), 250*time.Millisecond; elapsed < minimum {
+		t.Errorf("writing 256 KB at 1 MB/s took %v, at least %v expected", elapsed, minimum)
+	}
+}
+
+func TestRateLimiterStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter := newRateLimiter(ctx, 1)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	// Without the cancel this would wait for 1000 seconds
+	started := time.Now()
+	if err := limiter.wait(1000); err != context.Canceled {
+		t.Errorf("wait returned %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("cancel took %v to stop the wait", elapsed)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRatioForZeros(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"zeros.bin": strings.Repeat("\x00", 1<<20)})
+
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "zeros.bin")}, Output: filepath.Join(dir, "zeros.tar.zst"), Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.SpaceSavingPercent < 99 {
+		t.Errorf("space saving is %.2f%%, want above 99%%", stats.SpaceSavingPercent)
+	}
+	if stats.RatioX < 100 {
+		t.Errorf("ratio is %.1fx, want well above 1", stats.RatioX)
+	}
+	if stats.CompressionRatio > 1 {
+		t.Errorf("compression ratio is %.2f%%, want the compressed size as a small percentage", stats.CompressionRatio)
+	}
+}
+
+func TestRatioForEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"empty.txt": ""})
+
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "empty.txt")}, Output: filepath.Join(dir, "empty.tar.zst"), Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.CompressionRatio != 0 || stats.SpaceSavingPercent != 0 || stats.RatioX != 0 {
+		t.Errorf("empty archive has ratios %v, %v, %v, want 0", stats.CompressionRatio, stats.SpaceSavingPercent, stats.RatioX)
+	}
+	if _, err := json.Marshal(stats); err != nil {
+		t.Errorf("stats can't be sent as JSON: %v", err)
+	}
+}
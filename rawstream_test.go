@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeRawZstd compresses body the way the zstd tool does a single file
+func writeRawZstd(t *testing.T, path, body string) {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	encoder, err := zstd.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder.Write([]byte(body))
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressRawAndTarZstd(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		archive string
+		write   func(t *testing.T, path string)
+		want    map[string]string
+	}{
+		{
+			name:    "tar-wrapped",
+			archive: "wrapped.tar.zst",
+			write: func(t *testing.T, path string) {
+				writeTestArchive(t, path, testEntry{name: "a.txt", body: "alpha"})
+			},
+			want: map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name:    "raw",
+			archive: "notes.txt.zst",
+			write: func(t *testing.T, path string) {
+				writeRawZstd(t, path, strings.Repeat("not a tar header ", 100))
+			},
+			want: map[string]string{"notes.txt": strings.Repeat("not a tar header ", 100)},
+		},
+		{
+			name:    "raw shorter than a tar block",
+			archive: "short.zst",
+			write: func(t *testing.T, path string) {
+				writeRawZstd(t, path, "tiny")
+			},
+			want: map[string]string{"short": "tiny"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, test.archive)
+			test.write(t, archive)
+
+			output := filepath.Join(dir, "out")
+			result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.FileCount != len(test.want) {
+				t.Errorf("extracted %d files, want %d", result.FileCount, len(test.want))
+			}
+			for name, body := range test.want {
+				if got := readTestFile(t, filepath.Join(output, name)); got != body {
+					t.Errorf("%s holds %q, want %q", name, got, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOnReadErrorPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string // contents of broken.bin, or "" when writing it fails
+	}{
+		{"abort", ""},
+		{"skip-pad", "xxxx" + strings.Repeat("\x00", 6)},
+		{"truncate-entry", "xxxx"},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			var buf bytes.Buffer
+			b := &tarBuilder{tarWriter: tar.NewWriter(&buf), req: &CompressRequest{OnReadError: test.policy}}
+			// The same choice writeTarEntry makes
+			write := b.writeContents
+			if test.policy == "truncate-entry" {
+				write = b.writeSpooledContents
+			}
+
+			broken := &tar.Header{Name: "broken.bin", Mode: 0644, Typeflag: tar.TypeReg, Size: 10}
+			source := &readErrorRecorder{r: &failingReader{left: 4}}
+			_, err := write(broken, source, source)
+			if test.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "disk on fire") {
+					t.Fatalf("got %v, want the read error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b.warnings) != 1 {
+				t.Errorf("warnings are %v, want one", b.warnings)
+			}
+
+			// The archive carries on after the broken entry
+			ok := &tar.Header{Name: "ok.txt", Mode: 0644, Typeflag: tar.TypeReg, Size: 2}
+			source = &readErrorRecorder{r: strings.NewReader("ok")}
+			if _, err := write(ok, source, source); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.tarWriter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+			reader := tar.NewReader(&buf)
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[header.Name] = string(data)
+			}
+			if got["broken.bin"] != test.want || got["ok.txt"] != "ok" {
+				t.Errorf("archive holds %q", got)
+			}
+		})
+	}
+}
+
+// A canceled request is never taken for a read error to pad or truncate over
+func TestOnReadErrorStopsWhenCanceled(t *testing.T) {
+	for _, policy := range []string{"skip-pad", "truncate-entry"} {
+		t.Run(policy, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// The request is canceled while the body is being sent
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "1048576")
+				io.WriteString(w, "xxxx")
+				w.(http.Flusher).Flush()
+				cancel()
+			}))
+			defer server.Close()
+
+			archive := filepath.Join(t.TempDir(), "out.tar.zst")
+			req := CompressRequest{Files: []string{server.URL + "/slow.bin"}, Output: archive, Level: 3, OnReadError: policy, Context: ctx}
+			if stats, err := compressFiles(req); err == nil {
+				t.Errorf("compression succeeded with warnings %v, want it stopped", stats.Warnings)
+			}
+		})
+	}
+}
+
+// failingReader yields left bytes of x, then fails
+type failingReader struct {
+	left int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.left == 0 {
+		return 0, errors.New("disk on fire")
+	}
+	n := min(len(p), f.left)
+	for i := range n {
+		p[i] = 'x'
+	}
+	f.left -= n
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ReaderEntry is a file whose contents come from R rather than from disk,
+// for callers piping generated data into an archive. Size must be exactly
+// the number of bytes R yields. R is only read once, when the entry is
+// written, so dry runs and fingerprints see just the name and size.
+type ReaderEntry struct {
+	Name string
+	Size int64
+	R    io.Reader
+}
+
+// ContentEntry is a file posted inline with a compress request, stored
+// under Name with Content as its contents
+type ContentEntry struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// validateContents checks the inline contents of a compress request
+func validateContents(contents []ContentEntry) error {
+	for _, content := range contents {
+		if sanitizeTarPath(content.Name) == "" {
+			return errors.New("Every content entry needs a name")
+		}
+	}
+	return nil
+}
+
+// readerEntries builds the entries of the request's inline contents and
+// readers, in that order, named and filtered like the files of a walk
+func (b *tarBuilder) readerEntries() ([]tarEntry, error) {
+	readers := make([]ReaderEntry, 0, len(b.req.Contents)+len(b.req.Readers))
+	for _, content := range b.req.Contents {
+		readers = append(readers, ReaderEntry{
+			Name: content.Name,
+			Size: int64(len(content.Content)),
+			R:    strings.NewReader(content.Content),
+		})
+	}
+	readers = append(readers, b.req.Readers...)
+
+	var entries []tarEntry
+	for _, reader := range readers {
+		if reader.R == nil || reader.Size < 0 {
+			return nil, fmt.Errorf("reader %s has no contents", reader.Name)
+		}
+
+		b.entryCount++
+		if b.req.MaxEntries > 0 && b.entryCount > b.req.MaxEntries {
+			return nil, fmt.Errorf("archive exceeds the maximum of %d entries (stopped at %s)", b.req.MaxEntries, reader.Name)
+		}
+
+		name := reader.Name
+		if b.req.NameTransform != nil {
+			transformed, keep := b.req.NameTransform(name)
+			if !keep {
+				continue
+			}
+			name = transformed
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     sanitizeTarPath(name),
+			Size:     reader.Size,
+			Mode:     0644,
+			ModTime:  time.Now(),
+			Format:   b.format,
+		}
+		if header.Name == "" {
+			return nil, fmt.Errorf("reader %q has no usable name", reader.Name)
+		}
+		b.clampModTime(header)
+		if b.format == tar.FormatUSTAR {
+			if !fitsUSTAR(header.Name) {
+				return nil, fmt.Errorf("entry name %s is too long for the USTAR tar format, use pax or gnu instead", header.Name)
+			}
+			header.ModTime = header.ModTime.Truncate(time.Second)
+		}
+
+		if b.req.EntryFilter != nil && !b.req.EntryFilter(header) {
+			continue
+		}
+
+		contents := reader.R
+		entries = append(entries, tarEntry{header: header, path: reader.Name, open: func() (io.ReadCloser, error) {
+			return io.NopCloser(contents), nil
+		}})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCompressReaders(t *testing.T) {
+	dir := t.TempDir()
+	var generated bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		generated.WriteString("generated line\n")
+	}
+	want := map[string]string{
+		"report.csv":       "id,value\n1,one\n",
+		"logs/app.log":     generated.String(),
+		"empty.txt":        "",
+		"nested/deep/x.js": "export {}",
+	}
+
+	var entries []ReaderEntry
+	for _, name := range []string{"report.csv", "logs/app.log", "empty.txt", "nested/deep/x.js"} {
+		body := want[name]
+		entries = append(entries, ReaderEntry{Name: name, Size: int64(len(body)), R: bytes.NewBufferString(body)})
+	}
+
+	archive := filepath.Join(dir, "readers.tar.zst")
+	stats, err := compressReaders(entries, CompressRequest{Output: archive, Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+	if size := int64(generated.Len() + len(want["report.csv"]) + len(want["nested/deep/x.js"])); stats.OriginalSize != size {
+		t.Errorf("stats counted %d bytes, want %d", stats.OriginalSize, size)
+	}
+}
+
+func TestCompressReadersAfterFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/disk.txt": "from disk"})
+
+	archive := filepath.Join(dir, "mixed.tar.zst")
+	req := CompressRequest{
+		Files:    []string{filepath.Join(dir, "in")},
+		Contents: []ContentEntry{{Name: "inline.txt", Content: "inline"}},
+		Output:   archive,
+		Level:    3,
+		NameTransform: func(name string) (string, bool) {
+			return strings.TrimPrefix(name, "in/"), !strings.HasSuffix(name, ".skip")
+		},
+	}
+	entries := []ReaderEntry{
+		{Name: "piped.txt", Size: 5, R: strings.NewReader("piped")},
+		{Name: "dropped.skip", Size: 4, R: strings.NewReader("gone")},
+	}
+	if _, err := compressReaders(entries, req); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"in": "", "disk.txt": "from disk", "inline.txt": "inline", "piped.txt": "piped"}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
+
+// Measuring never opens a file, so the reader path runs without touching
+// the filesystem at all
+// Unordered file inputs are written as the walk reaches them, not after it
+func TestCompressFilesStreamsWalk(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a", "in/b.txt": "b", "in/c.txt": "c"})
+
+	var events []string
+	written := 0
+	_, err := compressFiles(CompressRequest{
+		Files:  []string{filepath.Join(dir, "in")},
+		Output: filepath.Join(dir, "out.tar.zst"),
+		Level:  3,
+		NameTransform: func(name string) (string, bool) {
+			events = append(events, "walk "+name)
+			return name, true
+		},
+		OnProgress: func(p Progress) {
+			if p.Files > written {
+				written = p.Files
+				events = append(events, "written")
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i := slices.Index(events, "written"); i < 0 || i > slices.Index(events, "walk in/c.txt") {
+		t.Errorf("entries weren't written during the walk: %v", events)
+	}
+}
+
+func TestCompressReadersInMemory(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	body := strings.Repeat("in memory\n", 500)
+	entries := []ReaderEntry{{Name: "a.txt", Size: int64(len(body)), R: strings.NewReader(body)}}
+	stats, err := compressReaders(entries, CompressRequest{Output: "unused.tar.zst", Level: 3, Measure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.OriginalSize != int64(len(body)) || stats.CompressedSize == 0 {
+		t.Errorf("measured %d bytes compressing to %d", stats.OriginalSize, stats.CompressedSize)
+	}
+
+	if left, err := os.ReadDir(dir); err != nil || len(left) > 0 {
+		t.Errorf("measuring readers wrote %v (%v)", left, err)
+	}
+}
+
+func TestCompressReadersRejectsBadEntries(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		entry ReaderEntry
+		want  string
+	}{
+		{ReaderEntry{Name: "short.txt", Size: 100, R: strings.NewReader("only a few bytes")}, "EOF"},
+		{ReaderEntry{Name: "nil.txt", Size: 1}, "has no contents"},
+		{ReaderEntry{Name: "../", Size: 1, R: strings.NewReader("x")}, "no usable name"},
+	} {
+		_, err := compressReaders([]ReaderEntry{tt.entry}, CompressRequest{Output: filepath.Join(dir, "bad.tar.zst"), Level: 3})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s got %v, want an error mentioning %q", tt.entry.Name, err, tt.want)
+		}
+	}
+}
+
+func TestCompressInlineContents(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "inline.tar.zst")
+	body, _ := json.Marshal(CompressRequest{
+		Contents: []ContentEntry{{Name: "a.txt", Content: "alpha"}, {Name: "sub/b.json", Content: `{"b":2}`}},
+		Output:   archive,
+	})
+	rec := httptest.NewRecorder()
+	handleCompress(rec, httptest.NewRequest(http.MethodPost, "/api/compress", bytes.NewReader(body)))
+
+	var resp Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success {
+		t.Fatalf("compress failed: %s", resp.Message)
+	}
+	want := map[string]string{"a.txt": "alpha", "sub/b.json": `{"b":2}`}
+	if got := archiveNames(t, archive); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	remoteMaxSize      = 1 << 30 // 1 GB per URL
+	remoteFetchTimeout = 10 * time.Minute
+)
+
+var remoteClient = &http.Client{Timeout: remoteFetchTimeout}
+
+func isRemoteURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// remoteEntries builds the entry for a URL input, or none when the name
+// transform drops it. The entry is named after the last segment of the URL
+// path (or the host), or after name when it is set. Its size is only known
+// once the URL is fetched.
+func (b *tarBuilder) remoteEntries(rawURL, name string) ([]tarEntry, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+
+	if name == "" {
+		name = path.Base(u.Path)
+		if name == "/" || name == "." {
+			name = u.Hostname()
+		}
+	}
+
+	if b.req.NameTransform != nil {
+		transformed, keep := b.req.NameTransform(name)
+		if !keep {
+			return nil, nil
+		}
+		name = transformed
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     sanitizeTarPath(name),
+		Mode:     0644,
+		ModTime:  time.Now(),
+		Format:   b.format,
+	}
+	b.remoteModTime(header)
+
+	entry := tarEntry{header: header, path: rawURL}
+	entry.open = func() (io.ReadCloser, error) {
+		return b.openRemote(entry)
+	}
+	return []tarEntry{entry}, nil
+}
+
+// remoteModTime clamps a remote entry's time like a local file's, and drops
+// the sub-seconds USTAR has no field for
+func (b *tarBuilder) remoteModTime(header *tar.Header) {
+	b.clampModTime(header)
+	if b.format == tar.FormatUSTAR {
+		header.ModTime = header.ModTime.Truncate(time.Second)
+	}
+}
+
+// openRemote fetches the entry's URL and fills in its size. When the server
+// doesn't send a Content-Length the body is buffered to a temp file first.
+func (b *tarBuilder) openRemote(entry tarEntry) (io.ReadCloser, error) {
+	// Canceling the job stops the fetch too, not only the copy after it
+	ctx := b.req.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", entry.path, err)
+	}
+
+	resp, err := remoteClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", entry.path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %s", entry.path, resp.Status)
+	}
+
+	if resp.ContentLength > remoteMaxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s is %d bytes, exceeding the limit of %d bytes", entry.path, resp.ContentLength, remoteMaxSize)
+	}
+
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		entry.header.ModTime = lastModified
+		b.remoteModTime(entry.header)
+	}
+
+	if resp.ContentLength >= 0 {
+		entry.header.Size = resp.ContentLength
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+
+	// Unknown length: buffer to disk so the header can carry the real size
+	spool, err := os.CreateTemp("", "zstd_remote")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+
+	n, err := io.Copy(spool, io.LimitReader(resp.Body, remoteMaxSize+1))
+	if err == nil && n > remoteMaxSize {
+		err = fmt.Errorf("%s exceeds the limit of %d bytes", entry.path, remoteMaxSize)
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		return nil, fmt.Errorf("failed to fetch %s: %v", entry.path, err)
+	}
+
+	entry.header.Size = n
+	return &tempFile{File: spool}, nil
+}
+
+// tempFile is a file that's deleted when closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressRemoteFiles(t *testing.T) {
+	modified := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/report.txt":
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+			io.WriteString(w, "remote report")
+		case "/stream":
+			// No Content-Length, so the body is spooled first
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "streamed body")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	archive := filepath.Join(t.TempDir(), "remote.tar.zst")
+	req := CompressRequest{Files: []string{server.URL + "/files/report.txt", server.URL + "/stream"}, Output: archive, Level: 3}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	times := map[string]time.Time{}
+	walkTestArchive(t, archive, func(header *tar.Header, body io.Reader) {
+		data, _ := io.ReadAll(body)
+		contents[header.Name], times[header.Name] = string(data), header.ModTime
+	})
+	if contents["report.txt"] != "remote report" || contents["stream"] != "streamed body" {
+		t.Errorf("archive holds %v", contents)
+	}
+	if !times["report.txt"].Equal(modified) {
+		t.Errorf("report.txt modified %v, want Last-Modified %v", times["report.txt"], modified)
+	}
+
+	// USTAR has no sub-second times, even for a clamped Last-Modified
+	clamp := modified.Add(-time.Hour + 500*time.Millisecond)
+	builder := &tarBuilder{req: &CompressRequest{ClampMtime: clamp}, format: tar.FormatUSTAR}
+	entries, err := builder.remoteEntries(server.URL+"/files/report.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := entries[0].open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if want := clamp.Truncate(time.Second); !entries[0].header.ModTime.Equal(want) {
+		t.Errorf("USTAR entry modified %v, want %v", entries[0].header.ModTime, want)
+	}
+
+	req.Files = []string{server.URL + "/missing"}
+	if _, err := compressFiles(req); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing URL got %v", err)
+	}
+}
+
+func TestCancelStopsRemoteFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hang until the client gives up
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	archive := filepath.Join(t.TempDir(), "remote.tar.zst")
+	started := time.Now()
+	_, err := compressFiles(CompressRequest{Files: []string{server.URL + "/hang"}, Output: archive, Level: 3, Context: ctx})
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("canceled fetch got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > remoteFetchTimeout/2 {
+		t.Errorf("fetch ran %v after the job was canceled", elapsed)
+	}
+}
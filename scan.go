@@ -0,0 +1,252 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scanRoot stands in for the output directory while scanning or streaming
+// an archive, so link targets resolve the way they would during extraction
+var scanRoot = filepath.Join(string(os.PathSeparator), "scan-output")
+
+// ScanRisk is an entry that is dangerous to extract, or that extraction
+// wouldn't write as named, and what extraction would do with it
+type ScanRisk struct {
+	Name   string `json:"name"`
+	Risk   string `json:"risk"`
+	Detail string `json:"detail,omitempty"`
+	Action string `json:"action"`
+}
+
+// ScanReport is the result of /api/scan. Safe is set when no entry carries
+// a risk. Abort names the first entry that would fail the extraction under
+// the request's policies.
+type ScanReport struct {
+	Entries   int        `json:"entries"`
+	TotalSize int64      `json:"totalSize"`
+	Safe      bool       `json:"safe"`
+	Abort     string     `json:"abort,omitempty"`
+	Risks     []ScanRisk `json:"risks"`
+}
+
+// handleScan checks an archive for dangerous entries without extracting
+// it: path traversal, absolute paths, symlinks escaping the output
+// directory, names that collide on case-insensitive filesystems and so on.
+// It takes the same request as /api/decompress and reports what extraction
+// would do with each risky entry under its policies.
+func handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DecompressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, false, "Invalid request format", nil)
+		return
+	}
+	if err := requestPassword(r, &req.Password); err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+	if req.Archive == "" {
+		sendResponse(w, false, "No archive file specified", nil)
+		return
+	}
+	if err := checkDecompressFeatures(req); err != nil {
+		sendResponse(w, false, err.Error(), nil)
+		return
+	}
+
+	if !checkAbsolutePathPolicy(w, req.AbsolutePathPolicy) {
+		return
+	}
+	switch req.NameCharsetPolicy {
+	case "", "skip", "sanitize", "abort":
+	default:
+		sendResponse(w, false, "Invalid name charset policy", nil)
+		return
+	}
+	if req.NameTransformSpec != "" {
+		transform, err := parseNameTransform(req.NameTransformSpec)
+		if err != nil {
+			sendResponse(w, false, err.Error(), nil)
+			return
+		}
+		req.NameTransform = transform
+	}
+
+	report, err := scanArchiveSafety(req)
+	if err != nil {
+		sendResponse(w, false, fmt.Sprintf("Failed to scan archive: %v", err), nil)
+		return
+	}
+
+	message := fmt.Sprintf("No risky entries among %d", report.Entries)
+	if !report.Safe {
+		message = fmt.Sprintf("%d risky entries among %d", len(report.Risks), report.Entries)
+	}
+	sendResponse(w, true, message, report)
+}
+
+// scanArchiveSafety runs the checks extractArchive makes on every entry of
+// req's archive, without writing anything
+func scanArchiveSafety(req DecompressRequest) (*ScanReport, error) {
+	var nameCharset *regexp.Regexp
+	if req.NameCharset != "" {
+		var err error
+		if nameCharset, err = parseNameCharset(req.NameCharset); err != nil {
+			return nil, err
+		}
+	}
+
+	read, err := decompressReadOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ScanReport{Risks: []ScanRisk{}}
+	add := func(header *tar.Header, risk, detail, action string) {
+		report.Risks = append(report.Risks, ScanRisk{Name: header.Name, Risk: risk, Detail: detail, Action: action})
+		if action == "aborts extraction" && report.Abort == "" {
+			report.Abort = header.Name
+		}
+	}
+
+	names := make(map[string]string) // lowercased path -> first entry there
+	guard := newEntryGuard(scanRoot, nil)
+	var totalSize int64
+
+	err = walkArchiveWith(req.Archive, read, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			return nil
+		}
+		report.Entries++
+
+		name := header.Name
+		if req.NameTransform != nil {
+			transformed, keep := req.NameTransform(name)
+			if !keep {
+				return nil
+			}
+			name = transformed
+		}
+
+		if nameCharset != nil {
+			if sanitized, replaced := applyNameCharset(nameCharset, name); replaced {
+				switch req.NameCharsetPolicy {
+				case "sanitize":
+					add(header, "invalid-name", "characters outside the allowed set", "renamed to "+sanitized)
+					name = sanitized
+				case "abort":
+					add(header, "invalid-name", "characters outside the allowed set", "aborts extraction")
+					return nil
+				default:
+					add(header, "invalid-name", "characters outside the allowed set", "skipped")
+					return nil
+				}
+			}
+		}
+
+		place, risk := guard.place(name, req.AbsolutePathPolicy)
+		switch risk {
+		case "traversal":
+			add(header, risk, "name contains ..", "skipped")
+			return nil
+		case "empty-name":
+			add(header, risk, "name has no path left once cleaned", "skipped")
+			return nil
+		}
+		cleanName := place.cleanName
+
+		if place.absolute {
+			switch {
+			case req.AbsolutePathPolicy == "reject":
+				add(header, "absolute-path", "", "aborts extraction")
+				return nil
+			case place.restored:
+				add(header, "absolute-path", "", "written to "+place.targetPath)
+			default:
+				add(header, "absolute-path", "", "extracted under the output directory as "+filepath.ToSlash(cleanName))
+			}
+		}
+
+		if len(req.Entries) > 0 && !matchesEntry(filepath.ToSlash(cleanName), req.Entries) {
+			return nil
+		}
+
+		if dir, ok := guard.belowSymlink(place); ok {
+			add(header, "below-symlink", "parent "+filepath.ToSlash(dir)+" is a symlink", "skipped")
+			return nil
+		}
+
+		if header.Typeflag != tar.TypeDir {
+			key := strings.ToLower(cleanName)
+			if first, ok := names[key]; ok {
+				if first == cleanName {
+					add(header, "duplicate", "", "overwrites an earlier entry")
+				} else {
+					add(header, "case-collision", "same name as "+filepath.ToSlash(first)+" but for case",
+						"overwrites it on case-insensitive filesystems")
+				}
+			} else {
+				names[key] = cleanName
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg:
+			if req.MaxEntrySize > 0 && header.Size > req.MaxEntrySize {
+				action := "skipped"
+				if req.EntrySizePolicy == "abort" {
+					action = "aborts extraction"
+				}
+				add(header, "oversized", fmt.Sprintf("%d bytes", header.Size), action)
+				return nil
+			}
+			totalSize += header.Size
+			report.TotalSize = totalSize
+			if req.MaxTotalSize > 0 && totalSize > req.MaxTotalSize && totalSize-header.Size <= req.MaxTotalSize {
+				add(header, "total-size", fmt.Sprintf("archive exceeds %d bytes here", req.MaxTotalSize), "aborts extraction")
+			}
+
+		case tar.TypeSymlink:
+			if _, reason := guard.checkLink(place, header.Linkname); reason != "" {
+				add(header, "symlink-escape", reason+": "+header.Linkname, "skipped")
+				return nil
+			}
+			guard.addLink(place)
+
+		case tar.TypeLink:
+			add(header, "hardlink", "link to "+header.Linkname, "skipped, hard links aren't extracted")
+
+		default:
+			kind := fmt.Sprintf("entry type %q", header.Typeflag)
+			switch header.Typeflag {
+			case tar.TypeChar:
+				kind = "character device"
+			case tar.TypeBlock:
+				kind = "block device"
+			case tar.TypeFifo:
+				kind = "named pipe"
+			}
+			add(header, "special-file", kind, "skipped, only files, directories and symlinks are extracted")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Safe = len(report.Risks) == 0
+	return report, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanFlagsTraversalAndEscapingSymlink(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "risky.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "ok.txt", body: "fine"},
+		testEntry{name: "../evil.txt", body: "escaped"},
+		testEntry{name: "passwd", link: "/etc/passwd"},
+	)
+	output := filepath.Join(dir, "out")
+
+	body, err := json.Marshal(DecompressRequest{Archive: archive, OutputDir: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handleScan(rec, httptest.NewRequest(http.MethodPost, "/api/scan", bytes.NewReader(body)))
+
+	var resp struct {
+		Success bool       `json:"success"`
+		Message string     `json:"message"`
+		Data    ScanReport `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	if !resp.Success {
+		t.Fatalf("scan failed: %s", resp.Message)
+	}
+
+	risks := map[string]string{}
+	for _, risk := range resp.Data.Risks {
+		risks[risk.Name] = risk.Risk
+	}
+	want := map[string]string{"../evil.txt": "traversal", "passwd": "symlink-escape"}
+	for name, risk := range want {
+		if risks[name] != risk {
+			t.Errorf("%s flagged as %q, want %q", name, risks[name], risk)
+		}
+	}
+	if len(risks) != len(want) || resp.Data.Safe || resp.Data.Entries != 3 {
+		t.Errorf("got report %+v", resp.Data)
+	}
+
+	// Scanning writes nothing: no output directory, nothing beside the archive
+	if _, err := os.Lstat(output); !os.IsNotExist(err) {
+		t.Errorf("scan created the output directory: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("scan left %d entries beside the archive", len(entries)-1)
+	}
+}
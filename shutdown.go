@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownCleanupTimeout is how long canceled requests get to remove their
+// partial outputs once the drain timeout has passed
+const shutdownCleanupTimeout = 5 * time.Second
+
+// inFlight counts running requests, including WebSocket ones, which
+// http.Server.Shutdown doesn't wait for once they're hijacked
+var inFlight sync.WaitGroup
+
+// trackInFlight counts every request handled by next in inFlight
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitInFlight waits for running requests to finish, reporting false if ctx
+// is done first
+func waitInFlight(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdownServer stops accepting connections and gives running requests up
+// to timeout to finish. Requests still running then are canceled through
+// cancelRequests, which cancels their contexts, so compressions and
+// extractions stop and clean up after themselves. Upload directories and
+// cached archives are removed last.
+func shutdownServer(server *http.Server, cancelRequests context.CancelFunc, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
+		log.Printf("Shutdown failed: %v", err)
+	}
+
+	if !waitInFlight(ctx) {
+		log.Printf("Requests still running after %s, canceling them", timeout)
+		cancelRequests()
+
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), shutdownCleanupTimeout)
+		defer cleanupCancel()
+		if !waitInFlight(cleanupCtx) {
+			log.Printf("Requests still running after being canceled, exiting anyway")
+		}
+	}
+
+	sweepUploadDirs()
+	clearArchiveCache()
+}
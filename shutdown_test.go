@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startTestServer serves handler the way main does, returning its URL
+func startTestServer(t *testing.T, handler http.Handler, requestsCtx context.Context) (*http.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler:     trackInFlight(handler),
+		BaseContext: func(net.Listener) context.Context { return requestsCtx },
+	}
+	go server.Serve(listener)
+	return server, "http://" + listener.Addr().String()
+}
+
+func TestShutdownWaitsForRunningRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	})
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server, url := startTestServer(t, handler, requestsCtx)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		shutdownServer(server, cancelRequests, 5*time.Second)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned while a request was running")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-body; got != "finished" {
+		t.Errorf("request got %q, want it to finish", got)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't return after the request finished")
+	}
+	if requestsCtx.Err() != nil {
+		t.Error("requests were canceled though they finished in time")
+	}
+}
+
+func TestShutdownCancelsRequestsAfterTimeout(t *testing.T) {
+	started, canceled := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(canceled)
+	})
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server, url := startTestServer(t, handler, requestsCtx)
+
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	shutdownServer(server, cancelRequests, 50*time.Millisecond)
+	select {
+	case <-canceled:
+	default:
+		t.Error("shutdown returned without canceling the running request")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSkipIfUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "a"})
+	output := filepath.Join(dir, "in.tar.zst")
+	req := CompressRequest{
+		Files:           []string{filepath.Join(dir, "in")},
+		Output:          output,
+		Level:           3,
+		StateFile:       filepath.Join(dir, "state"),
+		SkipIfUnchanged: true,
+	}
+
+	first, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Unchanged {
+		t.Fatal("first run was skipped")
+	}
+	before, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Unchanged {
+		t.Error("run on unchanged inputs wasn't skipped")
+	}
+	if after, err := os.Stat(output); err != nil || !after.ModTime().Equal(before.ModTime()) {
+		t.Error("skipped run rewrote the archive")
+	}
+
+	// A changed input is compressed again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "in", "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if third, err := compressFiles(req); err != nil || third.Unchanged {
+		t.Errorf("run on changed inputs got %+v, %v", third, err)
+	}
+
+	// So is a missing archive
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	if fourth, err := compressFiles(req); err != nil || fourth.Unchanged {
+		t.Errorf("run without the archive got %+v, %v", fourth, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSlowestFilesReportsSlowReader(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for i := range 5 {
+		files[fmt.Sprintf("in/quick%d.txt", i)] = fmt.Sprintf("quick file %d", i)
+	}
+	slow := slowText(2 << 20)
+	files["in/slow.txt"] = slow
+	writeTestFiles(t, dir, files)
+
+	req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: filepath.Join(dir, "slowest.tar.zst"), Level: 19, SlowestFiles: 2}
+	stats, err := compressFiles(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stats.Slowest) != 2 {
+		t.Fatalf("got %d slowest files, want 2", len(stats.Slowest))
+	}
+	first := stats.Slowest[0]
+	if first.Name != "in/slow.txt" || first.Size != int64(len(slow)) {
+		t.Errorf("slowest file is %s (%d bytes), want slow.txt (%d bytes)", first.Name, first.Size, len(slow))
+	}
+	elapsed, err := time.ParseDuration(first.Duration)
+	if err != nil || elapsed <= 0 {
+		t.Errorf("slow.txt took %s", first.Duration)
+	}
+	if first.Throughput <= 0 || first.Throughput > float64(len(slow))/elapsed.Seconds()*1.01 {
+		t.Errorf("slow.txt throughput is %v bytes/s over %s", first.Throughput, first.Duration)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSortEntriesIsReproducible(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"b/2.txt": "two", "a/1.txt": "one", "c.txt": "three"})
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"a", "a/1.txt", "b", "b/2.txt", "c.txt"} {
+		if err := os.Chtimes(filepath.Join(dir, name), when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inputs := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c.txt")}
+	reversed := []string{inputs[2], inputs[1], inputs[0]}
+
+	compress := func(files []string, output string, sorted bool) string {
+		t.Helper()
+		output = filepath.Join(dir, output)
+		if _, err := compressFiles(CompressRequest{Files: files, Output: output, Level: 3, SortEntries: sorted}); err != nil {
+			t.Fatal(err)
+		}
+		return readTestFile(t, output)
+	}
+
+	if compress(inputs, "1.tar.zst", true) != compress(reversed, "2.tar.zst", true) {
+		t.Error("sorted archives of reordered inputs differ")
+	}
+	if compress(inputs, "3.tar.zst", false) == compress(reversed, "4.tar.zst", false) {
+		t.Error("unsorted archives of reordered inputs are identical, the test proves nothing")
+	}
+}
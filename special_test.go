@@ -0,0 +1,83 @@
+//go:build unix
+
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSocketSkippedWithWarning(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "alpha"})
+	listener, err := net.Listen("unix", filepath.Join(dir, "in", "app.sock"))
+	if err != nil {
+		t.Skipf("can't create a socket: %v", err)
+	}
+	defer listener.Close()
+
+	archive := filepath.Join(dir, "out.tar.zst")
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archive, Level: 3})
+	if err != nil {
+		t.Fatalf("a socket failed the archive: %v", err)
+	}
+
+	if got := archiveNames(t, archive); len(got) != 2 || got["in/a.txt"] != "alpha" {
+		t.Errorf("archive holds %v", got)
+	}
+	if !slices.ContainsFunc(stats.Warnings, func(warning string) bool {
+		return strings.Contains(warning, "Skipped socket") && strings.Contains(warning, "app.sock")
+	}) {
+		t.Errorf("warnings are %q", stats.Warnings)
+	}
+}
+
+func TestDeviceNodes(t *testing.T) {
+	var null syscall.Stat_t
+	if err := syscall.Stat("/dev/null", &null); err != nil {
+		t.Skipf("no /dev/null: %v", err)
+	}
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "alpha"})
+	if err := syscall.Mknod(filepath.Join(dir, "in", "null"), syscall.S_IFCHR|0666, int(null.Rdev)); err != nil {
+		t.Skipf("can't create a device node: %v", err)
+	}
+
+	// Skipped with a warning by default
+	skipped := filepath.Join(dir, "skipped.tar.zst")
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: skipped, Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := archiveNames(t, skipped)["in/null"]; ok {
+		t.Error("device node archived by default")
+	}
+	if len(stats.Warnings) != 1 || !strings.Contains(stats.Warnings[0], "Skipped device") {
+		t.Errorf("warnings are %q", stats.Warnings)
+	}
+
+	// Archived as a character device when asked
+	archived := filepath.Join(dir, "devices.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: archived, Level: 3, SpecialFiles: "devices"}); err != nil {
+		t.Fatal(err)
+	}
+	var device *tar.Header
+	err = walkArchive(archived, func(header *tar.Header, body io.Reader) error {
+		if header.Name == "in/null" {
+			device = header
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device == nil || device.Typeflag != tar.TypeChar {
+		t.Fatalf("device node archived as %+v", device)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaxExtractedFileSizeSplitsFile(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "big.tar.zst")
+	big := strings.Repeat("0123456789", 25) // 250 bytes
+	writeTestArchive(t, archive, testEntry{name: "data/big.bin", body: big}, testEntry{name: "small.txt", body: "small"})
+
+	output := filepath.Join(dir, "out")
+	result, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, MaxExtractedFileSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.SplitFiles, []string{"data/big.bin"}) {
+		t.Errorf("split files are %v", result.SplitFiles)
+	}
+
+	if _, err := os.Stat(filepath.Join(output, "data", "big.bin")); !os.IsNotExist(err) {
+		t.Error("the oversized file was also extracted whole")
+	}
+	if readTestFile(t, filepath.Join(output, "small.txt")) != "small" {
+		t.Error("small.txt wasn't extracted whole")
+	}
+
+	var manifest splitManifest
+	if err := json.Unmarshal([]byte(readTestFile(t, filepath.Join(output, "data", "big.bin.parts.json"))), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Name != "big.bin" || manifest.Size != 250 || !reflect.DeepEqual(manifest.Parts, []string{"big.bin.part001", "big.bin.part002", "big.bin.part003"}) {
+		t.Errorf("manifest is %+v", manifest)
+	}
+
+	// Rejoining the parts as the manifest says gives the file back
+	var joined strings.Builder
+	for _, part := range manifest.Parts {
+		body := readTestFile(t, filepath.Join(output, "data", part))
+		if len(body) > 100 {
+			t.Errorf("%s is %d bytes, over the limit", part, len(body))
+		}
+		joined.WriteString(body)
+	}
+	if joined.String() != big {
+		t.Error("rejoined parts differ from the file")
+	}
+}
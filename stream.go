@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Trailers of a streamed compression, carrying its stats once the archive
+// has been sent. The ratios are those of CompressionStats: the compressed
+// size as a percentage of the original, the share of it saved and how many
+// times smaller the archive is.
+const (
+	trailerOriginalSize   = "X-Original-Size"
+	trailerCompressedSize = "X-Compressed-Size"
+	trailerRatio          = "X-Compression-Ratio"
+	trailerSpaceSaving    = "X-Space-Saving-Percent"
+	trailerRatioX         = "X-Ratio-X"
+	trailerDuration       = "X-Duration"
+	trailerSHA256         = "X-Archive-Sha256"
+)
+
+// handleCompressStream compresses the request body into a single-file tar.zst
+// and streams the archive back as the response, without touching the disk.
+// The body must have a Content-Length, since tar records the size up front.
+// The stats follow the archive as HTTP trailers, and are posted to the
+// webhookUrl parameter when it's set.
+func handleCompressStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Base(sanitizeTarPath(r.URL.Query().Get("filename")))
+	if name == "" || name == "." || name == "/" {
+		http.Error(w, "Filename parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength < 0 {
+		http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+		return
+	}
+
+	level := 3
+	if value := r.URL.Query().Get("level"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLevel {
+			http.Error(w, "Invalid level", http.StatusBadRequest)
+			return
+		}
+		level = parsed
+	}
+
+	webhookURL := r.URL.Query().Get("webhookUrl")
+	if webhookURL != "" {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if disabledFeatures["webhooks"] {
+			http.Error(w, featureError("webhooks").Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Name the archive like /api/compress would: the file without its extension
+	baseName := name
+	if trimmed := strings.TrimSuffix(name, path.Ext(name)); trimmed != "" {
+		baseName = trimmed
+	}
+
+	// The archive is sent while the body is still arriving; HTTP/1 servers
+	// stop reading the body once the response starts unless told otherwise.
+	// HTTP/2 is always full duplex, and reports this as unsupported.
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil && r.ProtoMajor == 1 {
+		log.Printf("Stream compression of %s may be cut short: %v", name, err)
+	}
+
+	w.Header().Set("Content-Type", "application/zstd")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": baseName + ".zst"}))
+	w.Header().Set("Trailer", strings.Join([]string{trailerOriginalSize, trailerCompressedSize, trailerRatio, trailerSpaceSaving, trailerRatioX, trailerDuration, trailerSHA256}, ", "))
+
+	// Once the first bytes are out, errors can only be logged (and sent to
+	// the webhook); the client sees a truncated archive
+	fail := func(err error) {
+		log.Printf("Stream compression of %s failed: %v", name, err)
+		notifyWebhook(webhookURL, "compress", false, fmt.Sprintf("Compression failed: %v", err), nil)
+	}
+	flushing := &flushWriter{w: w}
+	if f, ok := w.(http.Flusher); ok {
+		flushing.flusher = f
+	}
+	hasher := sha256.New()
+	out := &countingWriter{w: io.MultiWriter(flushing, hasher)}
+	startTime := time.Now()
+
+	encoderLevel := zstd.EncoderLevelFromZstd(level)
+	encoder, err := getEncoder(out, encoderLevel)
+	if err != nil {
+		http.Error(w, "Failed to create encoder", http.StatusInternalServerError)
+		notifyWebhook(webhookURL, "compress", false, "Failed to create encoder", nil)
+		return
+	}
+	defer putEncoder(encoder, encoderLevel)
+
+	tarWriter := tar.NewWriter(encoder)
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     r.ContentLength,
+		Mode:     0644,
+		ModTime:  time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		fail(err)
+		return
+	}
+	if _, err := io.CopyN(tarWriter, r.Body, r.ContentLength); err != nil {
+		fail(err)
+		return
+	}
+	if err := tarWriter.Close(); err != nil {
+		fail(err)
+		return
+	}
+	if err := encoder.Close(); err != nil {
+		fail(err)
+		return
+	}
+
+	stats := &CompressionStats{
+		Level:          level,
+		OriginalSize:   r.ContentLength,
+		CompressedSize: out.n,
+		Duration:       time.Since(startTime).String(),
+		OutputFile:     baseName + ".zst",
+		Checksum:       hex.EncodeToString(hasher.Sum(nil)),
+	}
+	stats.setRatios()
+	w.Header().Set(trailerOriginalSize, strconv.FormatInt(stats.OriginalSize, 10))
+	w.Header().Set(trailerCompressedSize, strconv.FormatInt(stats.CompressedSize, 10))
+	w.Header().Set(trailerRatio, fmt.Sprintf("%.2f", stats.CompressionRatio))
+	w.Header().Set(trailerSpaceSaving, fmt.Sprintf("%.2f", stats.SpaceSavingPercent))
+	w.Header().Set(trailerRatioX, fmt.Sprintf("%.2f", stats.RatioX))
+	w.Header().Set(trailerDuration, stats.Duration)
+	w.Header().Set(trailerSHA256, stats.Checksum)
+
+	notifyWebhook(webhookURL, "compress", true, fmt.Sprintf("Compressed %s to %s", name, stats.OutputFile), stats)
+}
+
+// flushWriter flushes the response after every write, so compressed blocks
+// reach the client as soon as the encoder emits them
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
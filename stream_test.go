@@ -0,0 +1,116 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Bodies larger than the encoder's first block are still arriving when the
+// archive starts going out, which an HTTP/1 server that isn't full duplex
+// cuts short
+func TestCompressStreamRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleCompressStream))
+	defer server.Close()
+
+	for _, size := range []int{0, 1, 64 << 10, 300 << 10, 1 << 20, 8 << 20} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			body := make([]byte, size)
+			rand.New(rand.NewSource(int64(size))).Read(body)
+
+			resp, err := http.Post(server.URL+"?filename=data.bin&level=1", "application/octet-stream", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %s", resp.Status)
+			}
+
+			archive, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, err := zstd.NewReader(bytes.NewReader(archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+			tarReader := tar.NewReader(decoder)
+			header, err := tarReader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := io.ReadAll(tarReader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Name != "data.bin" || !bytes.Equal(contents, body) {
+				t.Fatalf("archive holds %s with %d bytes, want data.bin with %d", header.Name, len(contents), size)
+			}
+
+			sum := sha256.Sum256(archive)
+			if got := resp.Trailer.Get(trailerSHA256); got != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s trailer is %q, want the archive's hash", trailerSHA256, got)
+			}
+			if got := resp.Trailer.Get(trailerOriginalSize); got != strconv.Itoa(size) {
+				t.Errorf("%s trailer is %q, want %d", trailerOriginalSize, got, size)
+			}
+		})
+	}
+}
+
+func TestCompressStreamTrailers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleCompressStream))
+	defer server.Close()
+
+	body := strings.Repeat("compressible stream ", 10000)
+	resp, err := http.Post(server.URL+"?filename=notes.txt", "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// All of the stats are announced ahead of the archive
+	for _, name := range []string{trailerOriginalSize, trailerCompressedSize, trailerRatio, trailerSpaceSaving, trailerRatioX, trailerDuration, trailerSHA256} {
+		if _, ok := resp.Trailer[name]; !ok {
+			t.Errorf("%s trailer wasn't announced", name)
+		}
+	}
+
+	// and filled in after it
+	archive, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(archive)
+	want := map[string]string{
+		trailerOriginalSize:   strconv.Itoa(len(body)),
+		trailerCompressedSize: strconv.Itoa(len(archive)),
+		trailerRatio:          fmt.Sprintf("%.2f", float64(len(archive))/float64(len(body))*100),
+		trailerSpaceSaving:    fmt.Sprintf("%.2f", (1-float64(len(archive))/float64(len(body)))*100),
+		trailerRatioX:         fmt.Sprintf("%.2f", float64(len(body))/float64(len(archive))),
+		trailerSHA256:         hex.EncodeToString(sum[:]),
+	}
+	for name, value := range want {
+		if got := resp.Trailer.Get(name); got != value {
+			t.Errorf("%s trailer is %q, want %q", name, got, value)
+		}
+	}
+	if duration, err := time.ParseDuration(resp.Trailer.Get(trailerDuration)); err != nil || duration <= 0 {
+		t.Errorf("%s trailer is %q", trailerDuration, resp.Trailer.Get(trailerDuration))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkRoundTrip(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	writeTestFiles(t, source, map[string]string{"data/file.txt": "hello"})
+	if err := os.Symlink(filepath.Join("data", "file.txt"), filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "source.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{source}, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(output, "source", "link")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("link wasn't restored as a symlink: %v", err)
+	}
+	if target != filepath.Join("data", "file.txt") {
+		t.Errorf("link target is %q, want data/file.txt", target)
+	}
+	if got := readTestFile(t, link); got != "hello" {
+		t.Errorf("link reads %q, want hello", got)
+	}
+}
+
+func TestSymlinkOutsideOutputIsSkipped(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "up", link: "../outside"},
+		testEntry{name: "abs", link: "/etc/passwd"},
+		testEntry{name: "root", link: "."},
+		testEntry{name: "d/ok", link: "../file.txt"},
+	)
+
+	output := filepath.Join(dir, "out")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"up", "abs", "root"} {
+		if _, err := os.Lstat(filepath.Join(output, name)); !os.IsNotExist(err) {
+			t.Errorf("symlink %s was extracted", name)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(output, "d", "ok")); err != nil || target != filepath.Join("..", "file.txt") {
+		t.Errorf("d/ok -> %q (%v), want ../file.txt", target, err)
+	}
+}
+
+// A link whose target only looks safe as a string can lead out through
+// another link: d/e/s -> t/../../escaped with d/e/t -> ../../f follows t
+// to f, then climbs twice from there.
+func TestSymlinkChainCantEscape(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "a", "out")
+	first := filepath.Join(dir, "first.tar.zst")
+	writeTestArchive(t, first,
+		testEntry{name: "f/"},
+		testEntry{name: "d/e/t", link: "../../f"},
+		testEntry{name: "d/e/s", link: "t/../../escaped"},
+	)
+	if _, err := decompressFile(DecompressRequest{Archive: first, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(output, "d", "e", "s")); !os.IsNotExist(err) {
+		t.Errorf("a symlink through another symlink was extracted")
+	}
+
+	// Plant the escaping link as an earlier run might have, then extract
+	// over it: nothing may be written through it
+	if err := os.Symlink("t/../../escaped", filepath.Join(output, "d", "e", "s")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "a", "escaped"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	second := filepath.Join(dir, "second.tar.zst")
+	writeTestArchive(t, second, testEntry{name: "d/e/s/evil.txt", body: "evil"})
+	if _, err := decompressFile(DecompressRequest{Archive: second, OutputDir: output, ExistingFiles: "overwrite"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "a", "escaped", "evil.txt")); !os.IsNotExist(err) {
+		t.Fatalf("a file was written outside the output directory")
+	}
+}
+
+func TestSymlinkTargetIsCleaned(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar.zst")
+	writeTestArchive(t, archive,
+		testEntry{name: "d/file.txt", body: "data"},
+		testEntry{name: "d/link", link: "./x/../file.txt"},
+	)
+
+	output := filepath.Join(dir, "out")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(output, "d", "link")); err != nil || target != "file.txt" {
+		t.Errorf("d/link -> %q (%v), want file.txt", target, err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A symlink given as an input is followed one level
+func TestSymlinkedInputIsFollowed(t *testing.T) {
+	skipWithoutSymlinks(t)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"real/a.txt": "a", "real/sub/b.txt": "b"})
+	if err := os.Symlink("real", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("link", filepath.Join(dir, "chain")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "link.tar.zst")
+	stats, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "link")}, Output: archive, Level: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.DereferencedInputs) != 1 || stats.DereferencedInputs[0] != filepath.Join(dir, "link") {
+		t.Errorf("dereferenced inputs are %v", stats.DereferencedInputs)
+	}
+	entries := archiveNames(t, archive)
+	if entries["link/a.txt"] != "a" || entries["link/sub/b.txt"] != "b" {
+		t.Errorf("archive holds %v, want the contents of real under link/", entries)
+	}
+
+	// A link to a link is followed once, leaving the second link as it is
+	archive = filepath.Join(dir, "chain.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "chain")}, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	err = walkArchive(archive, func(header *tar.Header, body io.Reader) error {
+		if header.Typeflag == tar.TypeSymlink {
+			links = append(links, header.Name+" -> "+header.Linkname)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0] != "chain -> real" {
+		t.Errorf("chain archived links %v, want chain -> real", links)
+	}
+}
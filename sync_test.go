@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// recordSyncs replaces fsync for a test, recording the synced paths and
+// whether each still existed under its name when synced
+func recordSyncs(t *testing.T) *[]string {
+	t.Helper()
+
+	var synced []string
+	old := fsync
+	fsync = func(file *os.File) error {
+		name := file.Name()
+		if _, err := os.Stat(name); err != nil {
+			name += " (gone)"
+		}
+		synced = append(synced, name)
+		return old(file)
+	}
+	t.Cleanup(func() { fsync = old })
+	return &synced
+}
+
+func TestSyncFlushesArchiveAndDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "a"})
+	output := filepath.Join(dir, "out", "a.tar.zst")
+	if err := os.Mkdir(filepath.Dir(output), 0755); err != nil {
+		t.Fatal(err)
+	}
+	synced := recordSyncs(t)
+
+	req := CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: output, Level: 3}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+	if len(*synced) != 0 {
+		t.Fatalf("synced %v without Sync", *synced)
+	}
+
+	req.Sync = true
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{output, filepath.Dir(output)}; !slices.Equal(*synced, want) {
+		t.Errorf("synced %v, want %v", *synced, want)
+	}
+}
+
+// An appended archive's copy is synced before it replaces the original
+func TestSyncFlushesAppendBeforeRename(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "a", "b.txt": "b"})
+	output := filepath.Join(dir, "a.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: output, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+	synced := recordSyncs(t)
+
+	req := CompressRequest{Files: []string{filepath.Join(dir, "b.txt")}, Output: output, Level: 3, Append: true, Sync: true}
+	if _, err := prepareCompressRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressFiles(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*synced) != 2 || filepath.Dir((*synced)[0]) != dir || (*synced)[0] == output || strings.HasSuffix((*synced)[0], "(gone)") || (*synced)[1] != dir {
+		t.Errorf("synced %v, want the archive's copy before its rename, then %s", *synced, dir)
+	}
+	if got := archiveNames(t, output); got["a.txt"] != "a" || got["b.txt"] != "b" {
+		t.Errorf("archive holds %v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestTarFormats(t *testing.T) {
+	dir := t.TempDir()
+	// A long name needs PAX or GNU records; USTAR splits it into its prefix
+	longName := strings.Repeat("d", 60) + "/" + strings.Repeat("f", 80) + ".txt"
+	writeTestFiles(t, dir, map[string]string{"in/" + longName: "long", "in/short.txt": "short"})
+
+	for _, test := range []struct {
+		format string
+		want   tar.Format
+	}{
+		{"ustar", tar.FormatUSTAR},
+		{"pax", tar.FormatPAX},
+		{"gnu", tar.FormatGNU},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			output := filepath.Join(dir, test.format+".tar.zst")
+			req := CompressRequest{Files: []string{filepath.Join(dir, "in")}, Output: output, Level: 3, TarFormat: test.format}
+			if _, err := compressFiles(req); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			decoder, err := zstd.NewReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+
+			contents := map[string]string{}
+			reader := tar.NewReader(decoder)
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Only the long name needs the format's extensions; the other
+				// headers read back as plain USTAR
+				if header.Name == "in/"+longName && header.Format != test.want {
+					t.Errorf("%s written as %v, want %v", header.Name, header.Format, test.want)
+				}
+				body, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				contents[header.Name] = string(body)
+			}
+			if contents["in/"+longName] != "long" || contents["in/short.txt"] != "short" {
+				t.Errorf("archive holds %v", contents)
+			}
+		})
+	}
+}
+
+func TestTarFormatUnknown(t *testing.T) {
+	if _, err := parseTarFormat("v7"); err == nil {
+		t.Error("v7 accepted as a tar format")
+	}
+}
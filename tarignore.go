@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tarIgnoreFile holds ignore patterns for the directory it's in and
+// everything below it
+const tarIgnoreFile = ".tarignore"
+
+// ignoreRule is one line of a .tarignore file, with gitignore semantics: a
+// pattern without a slash matches a name at any depth, a pattern with one is
+// relative to the .tarignore's directory (and may use "**" for any number of
+// directories), a trailing slash matches only directories and a leading !
+// re-includes what an earlier rule ignored.
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseIgnoreRule parses one pattern in .tarignore syntax
+func parseIgnoreRule(line string) (ignoreRule, error) {
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	for _, segment := range strings.Split(line, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return rule, err
+		}
+	}
+
+	rule.pattern = line
+	return rule, nil
+}
+
+// parseExcludeRules parses CompressRequest.Exclude
+func parseExcludeRules(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, pattern := range patterns {
+		rule, err := parseIgnoreRule(strings.TrimSpace(pattern))
+		if err == nil && rule.pattern == "" {
+			err = fmt.Errorf("empty pattern")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exclude pattern %q: %v", pattern, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// tarIgnore collects the rules of every .tarignore found during a walk,
+// keyed by the directory they were found in, after the exclude rules the
+// request gave for the walk's root
+type tarIgnore struct {
+	root    string
+	exclude []ignoreRule
+	rules   map[string][]ignoreRule
+}
+
+func newTarIgnore(root string, exclude []ignoreRule) *tarIgnore {
+	return &tarIgnore{root: root, exclude: exclude, rules: make(map[string][]ignoreRule)}
+}
+
+// load reads dir's .tarignore, if it has one
+func (t *tarIgnore) load(dir string) error {
+	file, err := os.Open(filepath.Join(dir, tarIgnoreFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseIgnoreRule(line)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q in %s: %v", line, filepath.Join(dir, tarIgnoreFile), err)
+		}
+		if rule.pattern != "" {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(rules) > 0 {
+		t.rules[dir] = rules
+	}
+	return nil
+}
+
+// ignored reports whether p is excluded by the rules of the directories
+// above it. Rules closer to p override those further up, and within a file
+// the last matching rule wins.
+func (t *tarIgnore) ignored(p string, isDir bool) bool {
+	if p == t.root {
+		return false
+	}
+
+	// Directories from the root down to p's parent
+	var dirs []string
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == t.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := applyIgnoreRules(t.exclude, t.root, p, isDir, false)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		ignored = applyIgnoreRules(t.rules[dirs[i]], dirs[i], p, isDir, ignored)
+	}
+	return ignored
+}
+
+// applyIgnoreRules applies the rules found in dir to p, returning whether p
+// is ignored after them
+func applyIgnoreRules(rules []ignoreRule, dir, p string, isDir, ignored bool) bool {
+	if len(rules) == 0 {
+		return ignored
+	}
+
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return ignored
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		var matched bool
+		if rule.anchored {
+			matched = matchGlobSegments(strings.Split(rule.pattern, "/"), strings.Split(rel, "/"))
+		} else {
+			matched, _ = path.Match(rule.pattern, path.Base(rel))
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globExcluded reports whether the glob match p, or a directory above it, is
+// excluded by rules. Anchored rules are relative to the working directory,
+// or to the root for an absolute p.
+func globExcluded(rules []ignoreRule, p string) bool {
+	p = filepath.Clean(p)
+	root := "."
+	if filepath.IsAbs(p) {
+		root = filepath.VolumeName(p) + string(filepath.Separator)
+	}
+
+	t := newTarIgnore(root, rules)
+	for dir := filepath.Dir(p); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if t.ignored(dir, true) {
+			return true
+		}
+	}
+	return t.ignored(p, false)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNestedTarIgnore(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeTestFiles(t, root, map[string]string{
+		".tarignore":          "*.log\n!keep.log\nbuild/\n",
+		"a.log":               "ignored by the root",
+		"keep.log":            "re-included by the root",
+		"c.tmp":               "only ignored below sub",
+		"build/out.bin":       "ignored directory",
+		"sub/.tarignore":      "*.tmp\n!important.log\n/only.txt\n",
+		"sub/b.tmp":           "ignored by sub",
+		"sub/other.log":       "ignored by the root rule",
+		"sub/important.log":   "re-included by sub",
+		"sub/only.txt":        "ignored by the anchored rule",
+		"sub/deeper/d.tmp":    "ignored by sub's cascading rule",
+		"sub/deeper/only.txt": "anchored rule doesn't reach here",
+		"sub/deeper/build/x":  "ignored by the root's directory rule",
+	})
+
+	archive := filepath.Join(dir, "ignored.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: []string{root}, Output: archive, Level: 3, TarIgnore: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for name := range archiveNames(t, archive) {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	want := []string{
+		"root",
+		"root/.tarignore",
+		"root/c.tmp",
+		"root/keep.log",
+		"root/sub",
+		"root/sub/.tarignore",
+		"root/sub/deeper",
+		"root/sub/deeper/only.txt",
+		"root/sub/important.log",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("archived %v\nwant %v", names, want)
+	}
+}
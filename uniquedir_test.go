@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUniqueExtractionDirectories(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.tar.zst")
+	writeTestArchive(t, archive, testEntry{name: "a.txt", body: "a"})
+
+	output := filepath.Join(dir, "out")
+	first, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output, Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.OutputDir != output || second.OutputDir != output+"-1" {
+		t.Errorf("extracted to %s and %s, want %s and %s-1", first.OutputDir, second.OutputDir, output, output)
+	}
+	for _, result := range []*extractResult{first, second} {
+		if got := readTestFile(t, filepath.Join(result.OutputDir, "a.txt")); got != "a" {
+			t.Errorf("%s/a.txt holds %q", result.OutputDir, got)
+		}
+	}
+}
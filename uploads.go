@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// uploadTTL is how long uploaded files are kept for a compression to use
+// them; 0 keeps them until the server shuts down
+var uploadTTL = time.Hour
+
+// maxUploadBytes caps each uploaded file and maxUploadTotalBytes all the
+// files of one upload request, set with -max-upload-size and
+// -max-upload-total, so a client can't fill the disk
+var (
+	maxUploadBytes      int64 = 4 << 30
+	maxUploadTotalBytes int64 = 8 << 30
+)
+
+// uploadFormOverhead allows for the multipart boundaries and headers
+// around the files of an upload
+const uploadFormOverhead = 1 << 20
+
+// errUploadTooLarge is returned for a file over maxUploadBytes
+var errUploadTooLarge = errors.New("upload too large")
+
+// parseUploadForm parses a multipart upload, failing once the body is
+// larger than maxUploadTotalBytes. Parts that don't fit in memory are
+// spooled to temp files; the caller removes them with r.MultipartForm.RemoveAll.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadTotalBytes+uploadFormOverhead)
+	err := r.ParseMultipartForm(32 << 20) // 32 MB max memory
+	if r.MultipartForm != nil && err != nil {
+		r.MultipartForm.RemoveAll()
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("Upload too large: the limit is %d bytes in total", maxUploadTotalBytes)
+	}
+	if err != nil {
+		return errors.New("Failed to parse form")
+	}
+	return nil
+}
+
+// saveUpload copies an uploaded file into dir, at most maxUploadBytes of
+// it. A file over the limit is removed again and errUploadTooLarge returned.
+func saveUpload(fileHeader *multipart.FileHeader, dir string) (string, error) {
+	if fileHeader.Size > maxUploadBytes {
+		return "", errUploadTooLarge
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open uploaded file: %v", err)
+	}
+	defer file.Close()
+
+	destPath := filepath.Join(dir, filepath.Base(fileHeader.Filename))
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create destination file: %v", err)
+	}
+
+	// Read one byte past the limit to tell a file that fits from one that doesn't
+	written, err := io.Copy(destFile, io.LimitReader(file, maxUploadBytes+1))
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxUploadBytes {
+		err = errUploadTooLarge
+	}
+	if err != nil {
+		os.Remove(destPath)
+		if err == errUploadTooLarge {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to save file: %v", err)
+	}
+	return destPath, nil
+}
+
+// uploadDir is a temp directory holding one request's uploaded files
+type uploadDir struct {
+	path      string
+	createdAt time.Time
+}
+
+var (
+	uploadDirsMu sync.Mutex
+	uploadDirs   []uploadDir
+)
+
+// newUploadDir creates a temp directory for uploaded files, removed once
+// it's older than uploadTTL or when the server shuts down
+func newUploadDir() (string, error) {
+	dir, err := os.MkdirTemp("", "zstd_upload")
+	if err != nil {
+		return "", err
+	}
+
+	uploadDirsMu.Lock()
+	uploadDirs = append(uploadDirs, uploadDir{path: dir, createdAt: time.Now()})
+	uploadDirsMu.Unlock()
+	return dir, nil
+}
+
+// cleanupExpiredUploads removes every upload directory older than uploadTTL
+func cleanupExpiredUploads(now time.Time) {
+	if uploadTTL <= 0 {
+		return
+	}
+
+	uploadDirsMu.Lock()
+	var expired, kept []uploadDir
+	for _, dir := range uploadDirs {
+		if now.Sub(dir.createdAt) > uploadTTL {
+			expired = append(expired, dir)
+		} else {
+			kept = append(kept, dir)
+		}
+	}
+	uploadDirs = kept
+	uploadDirsMu.Unlock()
+
+	removeUploadDirs(expired)
+}
+
+// sweepUploadDirs removes every upload directory created by this process
+func sweepUploadDirs() {
+	uploadDirsMu.Lock()
+	dirs := uploadDirs
+	uploadDirs = nil
+	uploadDirsMu.Unlock()
+
+	removeUploadDirs(dirs)
+}
+
+func removeUploadDirs(dirs []uploadDir) {
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir.path); err != nil {
+			log.Printf("Failed to remove upload directory %s: %v", dir.path, err)
+		}
+	}
+}
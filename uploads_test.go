@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upload posts files to handleUpload as a browser would
+func upload(t *testing.T, files map[string]string) (*httptest.ResponseRecorder, UploadResponse) {
+	t.Helper()
+	return postUpload(t, handleUpload, "files", files)
+}
+
+// postUpload posts files to handler as the form field named field
+func postUpload(t *testing.T, handler http.HandlerFunc, field string, files map[string]string) (*httptest.ResponseRecorder, UploadResponse) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, contents := range files {
+		part, err := form.CreateFormFile(field, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(contents))
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	var resp UploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	return rec, resp
+}
+
+func TestUploadDirRemovedAfterCompression(t *testing.T) {
+	t.Cleanup(sweepUploadDirs)
+
+	_, resp := upload(t, map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+	if !resp.Success || len(resp.Data.FilePaths) != 2 {
+		t.Fatalf("upload failed: %+v", resp)
+	}
+	uploadDir := filepath.Dir(resp.Data.FilePaths[0])
+
+	archive := filepath.Join(t.TempDir(), "uploaded.tar.zst")
+	if _, err := compressFiles(CompressRequest{Files: resp.Data.FilePaths, Output: archive, Level: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept while a compression might still use it, gone once it expires
+	cleanupExpiredUploads(time.Now())
+	if _, err := os.Stat(uploadDir); err != nil {
+		t.Fatalf("upload directory removed before it expired: %v", err)
+	}
+	cleanupExpiredUploads(time.Now().Add(uploadTTL + time.Second))
+	if _, err := os.Stat(uploadDir); !os.IsNotExist(err) {
+		t.Errorf("upload directory %s still there after it expired: %v", uploadDir, err)
+	}
+	if got := archiveNames(t, archive)["a.txt"]; got != "alpha" {
+		t.Errorf("a.txt holds %q", got)
+	}
+}
+
+func TestUploadDirsSweptAtShutdown(t *testing.T) {
+	old := uploadTTL
+	uploadTTL = 0
+	t.Cleanup(func() { uploadTTL = old })
+
+	_, resp := upload(t, map[string]string{"a.txt": "alpha"})
+	if !resp.Success {
+		t.Fatalf("upload failed: %s", resp.Message)
+	}
+	uploadDir := filepath.Dir(resp.Data.FilePaths[0])
+
+	// A TTL of 0 keeps uploads until shutdown
+	cleanupExpiredUploads(time.Now().Add(24 * time.Hour))
+	if _, err := os.Stat(uploadDir); err != nil {
+		t.Fatalf("upload directory removed with no TTL: %v", err)
+	}
+	sweepUploadDirs()
+	if _, err := os.Stat(uploadDir); !os.IsNotExist(err) {
+		t.Errorf("upload directory %s still there after shutdown: %v", uploadDir, err)
+	}
+}
+
+// limitUploads lowers the upload limits for a test, and points the temp
+// directory uploads are saved under at an empty one it returns
+func limitUploads(t *testing.T, perFile, total int64) string {
+	t.Helper()
+	oldFile, oldTotal := maxUploadBytes, maxUploadTotalBytes
+	maxUploadBytes, maxUploadTotalBytes = perFile, total
+	t.Cleanup(func() { maxUploadBytes, maxUploadTotalBytes = oldFile, oldTotal })
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	return tmp
+}
+
+// assertEmptyDir fails the test if anything was left in dir
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("%s left behind", entry.Name())
+	}
+}
+
+func TestOversizedUploadRejected(t *testing.T) {
+	t.Cleanup(sweepUploadDirs)
+	tmp := limitUploads(t, 100, 1000)
+
+	// The file that fits is saved first, and removed with the rest
+	_, resp := upload(t, map[string]string{"a.txt": "small", "b.txt": strings.Repeat("x", 101)})
+	if resp.Success || !strings.Contains(resp.Message, "b.txt too large") {
+		t.Errorf("oversized file got %+v", resp)
+	}
+	assertEmptyDir(t, tmp)
+
+	_, resp = upload(t, map[string]string{"a.txt": strings.Repeat("x", 100)})
+	if !resp.Success {
+		t.Errorf("a file at the limit was rejected: %s", resp.Message)
+	}
+}
+
+func TestOversizedUploadTotalRejected(t *testing.T) {
+	tmp := limitUploads(t, 4<<20, 1000)
+
+	// The limit allows for the form around the files
+	_, resp := upload(t, map[string]string{"a.txt": strings.Repeat("x", 3<<20)})
+	if resp.Success || !strings.Contains(resp.Message, "in total") {
+		t.Errorf("oversized upload got %+v", resp)
+	}
+	assertEmptyDir(t, tmp)
+}
+
+func TestOversizedArchiveUploadRejected(t *testing.T) {
+	tmp := limitUploads(t, 100, 1000)
+
+	_, resp := postUpload(t, handleUploadArchive, "archive", map[string]string{"big.tar.zst": strings.Repeat("x", 101)})
+	if resp.Success || !strings.Contains(resp.Message, "Archive too large") {
+		t.Errorf("oversized archive got %+v", resp)
+	}
+	assertEmptyDir(t, tmp)
+}
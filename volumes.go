@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// volumeIndexSuffix ends the name of the index written next to the volumes
+// of a split archive
+const volumeIndexSuffix = ".index.json"
+
+// volumeIndex lists which entries went into which volume of a split archive.
+// Volume files are relative to the index.
+type volumeIndex struct {
+	Volumes []volumeInfo `json:"volumes"`
+}
+
+type volumeInfo struct {
+	File    string   `json:"file"`
+	Entries []string `json:"entries"`
+}
+
+// volumeWriter is an archiveWriter that starts a new volume every
+// MaxEntriesPerVolume entries and writes the index when closed.
+type volumeWriter struct {
+	req  *CompressRequest
+	open func(out io.Writer) (archiveWriter, io.WriteCloser, error)
+
+	base, ext string
+	indexPath string
+
+	file    *os.File
+	archive archiveWriter
+	encoder io.WriteCloser
+	entries int // entries in the current volume
+
+	globals []*tar.Header // global headers written to every volume
+
+	index  volumeIndex
+	size   int64 // compressed size of the finished volumes
+	closed bool
+}
+
+func newVolumeWriter(req *CompressRequest, open func(out io.Writer) (archiveWriter, io.WriteCloser, error)) *volumeWriter {
+	ext := filepath.Ext(req.Output)
+	base := strings.TrimSuffix(req.Output, ext)
+
+	return &volumeWriter{
+		req:       req,
+		open:      open,
+		base:      base,
+		ext:       ext,
+		indexPath: base + volumeIndexSuffix,
+	}
+}
+
+func (v *volumeWriter) WriteHeader(header *tar.Header) error {
+	// Archive metadata is repeated at the start of every volume
+	if header.Typeflag == tar.TypeXGlobalHeader {
+		v.globals = append(v.globals, header)
+		if v.archive != nil {
+			return v.archive.WriteHeader(header)
+		}
+		return nil
+	}
+
+	if v.archive == nil || v.entries == v.req.MaxEntriesPerVolume {
+		if err := v.nextVolume(); err != nil {
+			return err
+		}
+	}
+
+	v.entries++
+	volume := &v.index.Volumes[len(v.index.Volumes)-1]
+	volume.Entries = append(volume.Entries, header.Name)
+
+	return v.archive.WriteHeader(header)
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	if v.archive == nil {
+		return 0, fmt.Errorf("write before header")
+	}
+	return v.archive.Write(p)
+}
+
+// nextVolume finishes the current volume and starts the next one
+func (v *volumeWriter) nextVolume() error {
+	if err := v.finishVolume(); err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s.vol%03d%s", v.base, len(v.index.Volumes)+1, v.ext)
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create volume: %v", err)
+	}
+
+	archive, encoder, err := v.open(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	v.file, v.archive, v.encoder, v.entries = file, archive, encoder, 0
+	v.index.Volumes = append(v.index.Volumes, volumeInfo{File: filepath.Base(path)})
+
+	for _, header := range v.globals {
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishVolume flushes and closes the current volume, if any
+func (v *volumeWriter) finishVolume() error {
+	if v.archive == nil {
+		return nil
+	}
+	defer v.file.Close()
+
+	if err := v.archive.Close(); err != nil {
+		return fmt.Errorf("failed to finalize volume: %v", err)
+	}
+	if v.encoder != nil {
+		if err := v.encoder.Close(); err != nil {
+			return fmt.Errorf("failed to finalize zstd stream: %v", err)
+		}
+	}
+	if v.req.Sync {
+		if err := fsync(v.file); err != nil {
+			return fmt.Errorf("failed to sync volume: %v", err)
+		}
+	}
+
+	info, err := v.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get volume stats: %v", err)
+	}
+	v.size += info.Size()
+
+	v.file, v.archive, v.encoder = nil, nil, nil
+	return nil
+}
+
+// Close finishes the last volume and writes the index
+func (v *volumeWriter) Close() error {
+	if v.closed {
+		return nil
+	}
+	v.closed = true
+
+	if err := v.finishVolume(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v.index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode volume index: %v", err)
+	}
+	if err := os.WriteFile(v.indexPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write volume index: %v", err)
+	}
+
+	if v.req.Sync {
+		if err := syncDir(filepath.Dir(v.indexPath)); err != nil {
+			return fmt.Errorf("failed to sync output directory: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// walkVolumes calls fn for every entry of every volume listed in an index
+func walkVolumes(indexPath string, read readOptions, fn func(header *tar.Header, body io.Reader) error) error {
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return fmt.Errorf("failed to open volume index: %v", err)
+	}
+
+	var index volumeIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return fmt.Errorf("invalid volume index: %v", err)
+	}
+
+	for _, volume := range index.Volumes {
+		// Volumes must sit next to the index
+		if volume.File != filepath.Base(volume.File) {
+			return fmt.Errorf("invalid volume name %q", volume.File)
+		}
+
+		if err := walkArchiveWith(filepath.Join(filepath.Dir(indexPath), volume.File), read, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove deletes every volume written so far and the index
+func (v *volumeWriter) remove() {
+	for _, volume := range v.index.Volumes {
+		os.Remove(filepath.Join(filepath.Dir(v.indexPath), volume.File))
+	}
+	os.Remove(v.indexPath)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVolumesByEntryCount(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	files := map[string]string{}
+	for i := range 10 {
+		files[fmt.Sprintf("%02d.txt", i)] = fmt.Sprintf("file %d", i)
+	}
+	writeTestFiles(t, input, files)
+
+	stats, err := compressFiles(CompressRequest{Files: []string{input}, Output: filepath.Join(dir, "split.tar.zst"), Level: 3, MaxEntriesPerVolume: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(stats.OutputFile, volumeIndexSuffix) {
+		t.Fatalf("output is %s, want the volume index", stats.OutputFile)
+	}
+
+	var index volumeIndex
+	if err := json.Unmarshal([]byte(readTestFile(t, stats.OutputFile)), &index); err != nil {
+		t.Fatal(err)
+	}
+	// The input directory and its 10 files make 11 entries
+	if len(index.Volumes) != 4 {
+		t.Fatalf("got %d volumes, want 4", len(index.Volumes))
+	}
+	total := 0
+	for i, volume := range index.Volumes {
+		if len(volume.Entries) > 3 {
+			t.Errorf("volume %d holds %d entries, more than the cap", i+1, len(volume.Entries))
+		}
+		total += len(volume.Entries)
+		if want := fmt.Sprintf(".vol%03d.zst", i+1); !strings.HasSuffix(volume.File, want) {
+			t.Errorf("volume %d is named %s, want a %s suffix", i+1, volume.File, want)
+		}
+		if _, err := os.Stat(filepath.Join(dir, volume.File)); err != nil {
+			t.Error(err)
+		}
+	}
+	if total != 11 {
+		t.Errorf("index lists %d entries, want 11", total)
+	}
+
+	output := filepath.Join(dir, "output")
+	if _, err := decompressFile(DecompressRequest{Archive: stats.OutputFile, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		if got := readTestFile(t, filepath.Join(output, "input", name)); got != body {
+			t.Errorf("%s holds %q, want %q", name, got, body)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// webhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+	// the body under -webhook-secret
+	webhookSignatureHeader = "X-Webhook-Signature-256"
+	webhookEventHeader     = "X-Webhook-Event"
+
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+// webhookRetryDelay is the wait before retrying a failed delivery, doubled
+// after each attempt. Tests shorten it.
+var webhookRetryDelay = 2 * time.Second
+
+// webhookSecret signs webhook bodies, set with -webhook-secret or the
+// WEBHOOK_SECRET environment variable. Without it webhooks go unsigned.
+var webhookSecret string
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookPayload is what a webhook receives: the response the request got,
+// under the name of the operation that produced it
+type webhookPayload struct {
+	Event string `json:"event"`
+	Response
+}
+
+// checkWebhookURL validates the URL a request wants its result posted to
+func checkWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Invalid webhook URL, it must be an http or https URL")
+	}
+	return nil
+}
+
+// sendJobResponse replies as sendResponse does and, when webhookURL is set,
+// also posts the reply to it for event
+func sendJobResponse(w http.ResponseWriter, webhookURL, event string, success bool, message string, data interface{}) {
+	sendResponse(w, success, message, data)
+	notifyWebhook(webhookURL, event, success, message, data)
+}
+
+// notifyWebhook posts a result to webhookURL in the background, retrying
+// failed deliveries. The body is encoded before returning, so the caller
+// may reuse data. Deliveries count as running requests, so a shutdown
+// waits for them like it does for the requests themselves.
+func notifyWebhook(webhookURL, event string, success bool, message string, data interface{}) {
+	if webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		Event:    event,
+		Response: Response{Success: success, Message: message, Data: data},
+	})
+	if err != nil {
+		log.Printf("Failed to encode the %s webhook for %s: %v", event, webhookURL, err)
+		return
+	}
+
+	inFlight.Add(1)
+	go func() {
+		defer inFlight.Done()
+
+		delay := webhookRetryDelay
+		for attempt := 1; ; attempt++ {
+			err := deliverWebhook(webhookURL, event, body)
+			if err == nil {
+				return
+			}
+			if attempt == webhookAttempts {
+				log.Printf("Giving up on the %s webhook for %s after %d attempts: %v", event, webhookURL, attempt, err)
+				return
+			}
+			log.Printf("The %s webhook for %s failed, retrying in %s: %v", event, webhookURL, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}()
+}
+
+// deliverWebhook makes one attempt at posting body; any status but 2xx
+// counts as a failure
+func deliverWebhook(webhookURL, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-zstd-compressor/"+version)
+	req.Header.Set(webhookEventHeader, event)
+	if webhookSecret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(webhookSecret, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver replied %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature returns the hex HMAC-SHA256 of body under secret, which
+// receivers recompute to check a webhook came from this server
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookDelivery is one request a test webhook receiver got
+type webhookDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+// startWebhookReceiver records what it's posted, replying with the
+// statuses given in turn and 200 once they run out
+func startWebhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, chan webhookDelivery) {
+	t.Helper()
+	deliveries := make(chan webhookDelivery, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- webhookDelivery{header: r.Header, body: body}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, deliveries
+}
+
+func receiveWebhook(t *testing.T, deliveries chan webhookDelivery) webhookDelivery {
+	t.Helper()
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook never arrived")
+		return webhookDelivery{}
+	}
+}
+
+func TestWebhookSignedOnCompletion(t *testing.T) {
+	old := webhookSecret
+	webhookSecret = "shared secret"
+	t.Cleanup(func() { webhookSecret = old })
+	server, deliveries := startWebhookReceiver(t)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"in/a.txt": "alpha"})
+	for _, tt := range []struct {
+		files   []string
+		success bool
+	}{
+		{[]string{filepath.Join(dir, "in")}, true},
+		{[]string{filepath.Join(dir, "missing")}, false},
+	} {
+		body, _ := json.Marshal(CompressRequest{Files: tt.files, Output: filepath.Join(dir, "out.tar.zst"), WebhookURL: server.URL})
+		rec := httptest.NewRecorder()
+		handleCompress(rec, httptest.NewRequest(http.MethodPost, "/api/compress", bytes.NewReader(body)))
+
+		delivery := receiveWebhook(t, deliveries)
+		want := "sha256=" + webhookSignature("shared secret", delivery.body)
+		if got := delivery.header.Get(webhookSignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+			t.Errorf("signature is %q, want %q", got, want)
+		}
+		if got := delivery.header.Get(webhookEventHeader); got != "compress" {
+			t.Errorf("event header is %q", got)
+		}
+
+		var payload webhookPayload
+		if err := json.Unmarshal(delivery.body, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Event != "compress" || payload.Success != tt.success {
+			t.Errorf("webhook payload is %+v, want success %v", payload, tt.success)
+		}
+
+		// The webhook carries the reply the request got
+		var resp Response
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Message != resp.Message {
+			t.Errorf("webhook message %q, reply %q", payload.Message, resp.Message)
+		}
+	}
+}
+
+func TestWebhookRetried(t *testing.T) {
+	old := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	t.Cleanup(func() { webhookRetryDelay = old })
+	server, deliveries := startWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+
+	notifyWebhook(server.URL, "decompress", true, "done", nil)
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		delivery := receiveWebhook(t, deliveries)
+		if !strings.Contains(string(delivery.body), `"done"`) {
+			t.Errorf("attempt %d posted %s", attempt, delivery.body)
+		}
+	}
+	inFlight.Wait()
+	select {
+	case delivery := <-deliveries:
+		t.Errorf("delivered again after succeeding: %s", delivery.body)
+	default:
+	}
+}
+
+func TestInvalidWebhookURLRejected(t *testing.T) {
+	for _, webhookURL := range []string{"ftp://example.com/hook", "not a url", "http://"} {
+		if err := checkWebhookURL(webhookURL); err == nil {
+			t.Errorf("webhook URL %q was accepted", webhookURL)
+		}
+	}
+}
+
+func TestWebhookOnWebSocketCompression(t *testing.T) {
+	server, deliveries := startWebhookReceiver(t)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.txt": "alpha"})
+	output := filepath.Join(dir, "ws.tar.zst")
+	conn := dialCompressWS(t, CompressRequest{Files: []string{filepath.Join(dir, "a.txt")}, Output: output, Level: 3, WebhookURL: server.URL})
+
+	var result wsResult
+	for result.Type != "result" {
+		if err := conn.ReadJSON(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(receiveWebhook(t, deliveries).body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "compress" || !payload.Success || payload.Message != result.Message {
+		t.Errorf("webhook payload is %+v, result %+v", payload, result.Response)
+	}
+}
+
+func TestWebhookOnStreamCompression(t *testing.T) {
+	receiver, deliveries := startWebhookReceiver(t)
+	server := httptest.NewServer(http.HandlerFunc(handleCompressStream))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?filename=data.txt&webhookUrl="+receiver.URL, "text/plain", strings.NewReader("streamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var payload struct {
+		Event   string
+		Success bool
+		Data    CompressionStats
+	}
+	if err := json.Unmarshal(receiveWebhook(t, deliveries).body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "compress" || !payload.Success || payload.Data.OriginalSize != 8 {
+		t.Errorf("webhook payload is %+v", payload)
+	}
+	if got := resp.Trailer.Get(trailerSHA256); payload.Data.Checksum != got {
+		t.Errorf("webhook checksum %s, trailer %s", payload.Data.Checksum, got)
+	}
+
+	resp, err = http.Post(server.URL+"?filename=data.txt&webhookUrl=ftp://example.com", "text/plain", strings.NewReader("streamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid webhook URL got status %d", resp.StatusCode)
+	}
+}
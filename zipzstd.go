@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zipMethodZstd is the zip compression method ID for zstd (93). It's listed
+// in the zip specification since 6.3.7, but many tools (including Windows
+// Explorer and older unzip builds) can only list such entries, not extract
+// them.
+const zipMethodZstd uint16 = 93
+
+var zipMagic = []byte{'P', 'K', 0x03, 0x04}
+
+func init() {
+	zip.RegisterDecompressor(zipMethodZstd, func(r io.Reader) io.ReadCloser {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return io.NopCloser(errReader{err})
+		}
+		return decoder.IOReadCloser()
+	})
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// archiveWriter is what the archive builder writes entries to: a tar.Writer,
+// or a zipEntryWriter for zip output.
+type archiveWriter interface {
+	WriteHeader(header *tar.Header) error
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+// zipEntryWriter adapts a zip.Writer to the tar-style WriteHeader/Write calls
+// the archive builder makes, compressing each file with zstd. Entries other
+// than files, directories and symlinks are dropped.
+type zipEntryWriter struct {
+	zipWriter *zip.Writer
+	current   io.Writer
+}
+
+func newZipEntryWriter(out io.Writer, newEncoder func(w io.Writer) (io.WriteCloser, error)) *zipEntryWriter {
+	zipWriter := zip.NewWriter(out)
+	zipWriter.RegisterCompressor(zipMethodZstd, newEncoder)
+	return &zipEntryWriter{zipWriter: zipWriter}
+}
+
+func (z *zipEntryWriter) WriteHeader(header *tar.Header) error {
+	fileHeader := &zip.FileHeader{Name: header.Name, Modified: header.ModTime}
+	fileHeader.SetMode(header.FileInfo().Mode())
+
+	switch header.Typeflag {
+	case tar.TypeReg:
+		fileHeader.Method = zipMethodZstd
+	case tar.TypeDir:
+		if fileHeader.Name[len(fileHeader.Name)-1] != '/' {
+			fileHeader.Name += "/"
+		}
+		fileHeader.Method = zip.Store
+	case tar.TypeSymlink:
+		fileHeader.Method = zip.Store
+	default:
+		z.current = io.Discard
+		return nil
+	}
+
+	w, err := z.zipWriter.CreateHeader(fileHeader)
+	if err != nil {
+		return err
+	}
+	z.current = w
+
+	// Zip stores a symlink's target as its contents
+	if header.Typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(w, header.Linkname)
+	}
+	return err
+}
+
+func (z *zipEntryWriter) Write(p []byte) (int, error) {
+	if z.current == nil {
+		return 0, fmt.Errorf("write before header")
+	}
+	return z.current.Write(p)
+}
+
+func (z *zipEntryWriter) Close() error {
+	return z.zipWriter.Close()
+}
+
+// pooledEncoder returns its encoder to the pool on the first Close
+type pooledEncoder struct {
+	*zstd.Encoder
+	level    zstd.EncoderLevel
+	returned bool
+}
+
+func (e *pooledEncoder) Close() error {
+	if e.returned {
+		return nil
+	}
+	e.returned = true
+
+	err := e.Encoder.Close()
+	putEncoder(e.Encoder, e.level)
+	return err
+}
+
+// walkZipArchive calls fn for every entry of a zip archive, converting the
+// zip headers to tar headers so zip and tar archives share one code path.
+func walkZipArchive(file *os.File, fn func(header *tar.Header, body io.Reader) error) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+
+	zipReader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %v", err)
+	}
+
+	for _, f := range zipReader.File {
+		header, err := tar.FileInfoHeader(f.FileInfo(), "")
+		if err != nil {
+			return fmt.Errorf("failed to read zip entry %s: %v", f.Name, err)
+		}
+		// Directories are named without the trailing slash, as in tar
+		header.Name = strings.TrimSuffix(f.Name, "/")
+		header.ModTime = f.Modified
+
+		if err := walkZipEntry(f, header, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkZipEntry(f *zip.File, header *tar.Header, fn func(header *tar.Header, body io.Reader) error) error {
+	if header.Typeflag == tar.TypeDir {
+		return fn(header, bytes.NewReader(nil))
+	}
+
+	body, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open zip entry %s: %v", f.Name, err)
+	}
+	defer body.Close()
+
+	if header.Typeflag == tar.TypeSymlink {
+		target, err := io.ReadAll(io.LimitReader(body, 4096))
+		if err != nil {
+			return fmt.Errorf("failed to read zip entry %s: %v", f.Name, err)
+		}
+		header.Linkname = string(target)
+		header.Size = 0
+		return fn(header, bytes.NewReader(nil))
+	}
+
+	return fn(header, body)
+}
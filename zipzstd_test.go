@@ -0,0 +1,58 @@
+package main
+
+import (
+	"archive/zip"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestZipZstdRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	files := map[string]string{"a.txt": strings.Repeat("alpha ", 1000), "sub/b.txt": "bravo"}
+	writeTestFiles(t, input, files)
+
+	archive := filepath.Join(dir, "out.zip")
+	if _, err := compressFiles(CompressRequest{Files: []string{input}, Output: archive, Level: 3, Format: "zip"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files use the zstd method, so any entry can be read on its own
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if !file.FileInfo().IsDir() && file.Method != zipMethodZstd {
+			t.Errorf("%s uses method %d, want %d", file.Name, file.Method, zipMethodZstd)
+		}
+	}
+
+	output := filepath.Join(dir, "output")
+	if _, err := decompressFile(DecompressRequest{Archive: archive, OutputDir: output}); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		if got := readTestFile(t, filepath.Join(output, "input", filepath.FromSlash(name))); got != body {
+			t.Errorf("%s holds %q, want %q", name, got, body)
+		}
+	}
+
+	// Standard tools can at least list the entries
+	unzip, err := exec.LookPath("unzip")
+	if err != nil {
+		t.Skip("unzip not installed")
+	}
+	listing, err := exec.Command(unzip, "-l", archive).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unzip -l failed: %v\n%s", err, listing)
+	}
+	for name := range files {
+		if !strings.Contains(string(listing), "input/"+name) {
+			t.Errorf("unzip -l doesn't list %s:\n%s", name, listing)
+		}
+	}
+}